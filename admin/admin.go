@@ -0,0 +1,282 @@
+// Package admin is an HTTP handler inspecting and controlling a running ktcp server, it is meant to be
+// served on a private address, e.g. http.ListenAndServe("127.0.0.1:6060", admin.Handler(srv)):
+//
+//	GET  /sessions               lists the sessions, ?user= filters them by the bound user
+//	GET  /sessions/{id}          shows a session in detail
+//	POST /sessions/{id}/kick     closes a session
+//	POST /broadcast              sends a notice to the sessions, see Notice
+//	GET  /routes                 dumps the request message ids routed to the service methods
+//	GET  /messages               dumps the message id table, see package msgtype
+//	GET  /metrics                exposes the metrics, see MetricsHandler
+//
+// The responses are JSON.
+package admin
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kwstars/ktcp"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/kwstars/ktcp/negotiate"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Option is an admin handler option.
+type Option func(*handler)
+
+// MetricsHandler serves /metrics with h, the expvar handler by default, see package metrics/expvar.
+func MetricsHandler(h http.Handler) Option {
+	return func(a *handler) {
+		a.metrics = h
+	}
+}
+
+// Handler returns the admin HTTP handler of srv.
+func Handler(srv *ktcp.Server, opts ...Option) http.Handler {
+	a := &handler{srv: srv, metrics: expvar.Handler()}
+	for _, o := range opts {
+		o(a)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", a.sessions)
+	mux.HandleFunc("/sessions/", a.session)
+	mux.HandleFunc("/broadcast", a.broadcast)
+	mux.HandleFunc("/routes", a.routes)
+	mux.HandleFunc("/messages", a.messages)
+	mux.Handle("/metrics", a.metrics)
+	return mux
+}
+
+type handler struct {
+	srv     *ktcp.Server
+	metrics http.Handler
+}
+
+// Session is a session of /sessions.
+type Session struct {
+	ID         string    `json:"id"`
+	Listener   string    `json:"listener"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserID     string    `json:"user_id,omitempty"`
+	State      string    `json:"state"` // active, or the close reason of a closing session
+	CreatedAt  time.Time `json:"created_at"`
+	Uptime     float64   `json:"uptime"` // seconds
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	LastActive time.Time `json:"last_active,omitempty"`
+}
+
+// SessionDetail is a session of /sessions/{id}.
+type SessionDetail struct {
+	Session
+	LocalAddr  string            `json:"local_addr"`
+	FramesIn   int64             `json:"frames_in"`
+	FramesOut  int64             `json:"frames_out"`
+	Codec      string            `json:"codec,omitempty"`
+	Negotiated *negotiate.Result `json:"negotiated,omitempty"`
+}
+
+func sessionOf(sess *ktcp.Session, now time.Time) Session {
+	stats := sess.Stats()
+	state := "active"
+	if r := sess.CloseReason(); r != 0 {
+		state = r.String()
+	}
+	return Session{
+		ID:         sess.ID(),
+		Listener:   sess.ListenerName(),
+		RemoteAddr: sess.RemoteAddr().String(),
+		UserID:     sess.UserID(),
+		State:      state,
+		CreatedAt:  sess.CreatedAt(),
+		Uptime:     now.Sub(sess.CreatedAt()).Seconds(),
+		BytesIn:    stats.BytesIn,
+		BytesOut:   stats.BytesOut,
+		LastActive: stats.LastActive,
+	}
+}
+
+func (a *handler) sessions(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	user := r.URL.Query().Get("user")
+	now := time.Now()
+	sessions := make([]Session, 0)
+	a.srv.RangeSessions(func(sess *ktcp.Session) bool {
+		if user == "" || sess.UserID() == user {
+			sessions = append(sessions, sessionOf(sess, now))
+		}
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// session serves /sessions/{id} and /sessions/{id}/kick.
+func (a *handler) session(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/")
+	switch action {
+	case "":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		sess, ok := a.srv.Session(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("session %s not found", id))
+			return
+		}
+		stats := sess.Stats()
+		detail := SessionDetail{
+			Session:    sessionOf(sess, time.Now()),
+			LocalAddr:  sess.LocalAddr().String(),
+			FramesIn:   stats.FramesIn,
+			FramesOut:  stats.FramesOut,
+			Negotiated: sess.Negotiated(),
+		}
+		if c := sess.Codec(); c != nil {
+			detail.Codec = c.Name()
+		}
+		writeJSON(w, http.StatusOK, detail)
+	case "kick":
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		if !a.srv.Kick(id) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("session %s not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"kicked": id})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Notice is the request of /broadcast, the payload is the protojson of the registered message of ID,
+// it is sent with the codec of each session.
+type Notice struct {
+	ID      uint32          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+	// UserID sends the notice to the sessions bound to the user only if set.
+	UserID string `json:"user_id,omitempty"`
+}
+
+// BroadcastResult is the response of /broadcast.
+type BroadcastResult struct {
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+}
+
+func (a *handler) broadcast(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	var notice Notice
+	if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid notice: %s", err))
+		return
+	}
+	msg, err := msgtype.New(notice.ID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(notice.Payload) > 0 {
+		if err := protojson.Unmarshal(notice.Payload, msg); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid payload of message %d: %s", notice.ID, err))
+			return
+		}
+	}
+
+	var res BroadcastResult
+	a.srv.RangeSessions(func(sess *ktcp.Session) bool {
+		if notice.UserID != "" && sess.UserID() != notice.UserID {
+			return true
+		}
+		if err := sess.SendMsg(notice.ID, msg); err != nil {
+			res.Failed++
+		} else {
+			res.Sent++
+		}
+		return true
+	})
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Route is a route of /routes.
+type Route struct {
+	ID        uint32 `json:"id"`
+	Operation string `json:"operation"`
+	Request   string `json:"request"`
+}
+
+func (a *handler) routes(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	routes := make([]Route, 0)
+	msgtype.Range(func(t msgtype.Type) bool {
+		if t.Direction == msgtype.Request && t.Service != "" {
+			routes = append(routes, Route{
+				ID:        t.ID,
+				Operation: "/" + t.Service + "/" + t.Method,
+				Request:   string(t.MessageType.Descriptor().FullName()),
+			})
+		}
+		return true
+	})
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// Message is a message type of /messages.
+type Message struct {
+	ID        uint32 `json:"id"`
+	Direction string `json:"direction"`
+	Name      string `json:"name"`
+	Service   string `json:"service,omitempty"`
+	Method    string `json:"method,omitempty"`
+}
+
+func (a *handler) messages(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	messages := make([]Message, 0)
+	msgtype.Range(func(t msgtype.Type) bool {
+		messages = append(messages, Message{
+			ID:        t.ID,
+			Direction: t.Direction.String(),
+			Name:      string(t.MessageType.Descriptor().FullName()),
+			Service:   t.Service,
+			Method:    t.Method,
+		})
+		return true
+	})
+	writeJSON(w, http.StatusOK, messages)
+}
+
+// allow replies 405 if the method of r is not method.
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	echoID   = 300
+	noticeID = 301
+)
+
+func init() {
+	msgtype.Register(
+		msgtype.Type{
+			ID:          echoID,
+			Direction:   msgtype.Request,
+			Service:     "test.Echo",
+			Method:      "Echo",
+			MessageType: (*testData.TestModel)(nil).ProtoReflect().Type(),
+		},
+		msgtype.Type{
+			ID:          noticeID,
+			Direction:   msgtype.Response,
+			MessageType: (*testData.TestModel)(nil).ProtoReflect().Type(),
+		},
+	)
+}
+
+// echoHandler binds the sessions to the user "alice" and echoes the requests.
+type echoHandler struct{}
+
+func (echoHandler) OnConnect(sess *ktcp.Session) { sess.SetUserID("alice") }
+
+func (echoHandler) OnMessage(c ktcp.Context) {
+	var in testData.TestModel
+	if err := c.Bind(&in); err == nil {
+		_ = c.Send(c.GetReqMsg().ID, &in)
+	}
+}
+
+func (echoHandler) OnClose(*ktcp.Session) {}
+
+// server is a ktcp server with its admin handler.
+type server struct {
+	*ktcp.Server
+	addr string
+}
+
+func serve(t *testing.T) (*server, *httptest.Server) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := ktcp.NewServer(echoHandler{}, ktcp.Listener(lis))
+	go func() { _ = srv.Serve() }()
+	t.Cleanup(func() { _ = srv.Stop(context.Background()) })
+
+	ts := httptest.NewServer(Handler(srv))
+	t.Cleanup(ts.Close)
+	return &server{Server: srv, addr: lis.Addr().String()}, ts
+}
+
+func dial(t *testing.T, srv *server, recv chan<- *message.Message) *ktcp.Client {
+	t.Helper()
+	c, err := ktcp.Dial("tcp", srv.addr, ktcp.ClientHandler(func(msg *message.Message) {
+		recv <- &message.Message{ID: msg.ID, Data: append([]byte(nil), msg.Data...)}
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func do(t *testing.T, method, url, body string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if v != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+func receive(t *testing.T, recv <-chan *message.Message) *message.Message {
+	t.Helper()
+	select {
+	case msg := <-recv:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message")
+		return nil
+	}
+}
+
+func TestHandler_Sessions(t *testing.T) {
+	srv, ts := serve(t)
+	recv := make(chan *message.Message, 1)
+	c := dial(t, srv, recv)
+	require.NoError(t, c.Send(echoID, &testData.TestModel{Name: "hello"}))
+	receive(t, recv)
+
+	var sessions []Session
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/sessions?user=alice", "", &sessions))
+	require.Len(t, sessions, 1)
+	s := sessions[0]
+	assert.Equal(t, "alice", s.UserID)
+	assert.Equal(t, "active", s.State)
+	assert.Equal(t, ktcp.DefaultListenerName, s.Listener)
+	assert.Greater(t, s.BytesIn, int64(0))
+	assert.Equal(t, s.BytesIn, s.BytesOut)
+	assert.False(t, s.LastActive.IsZero())
+
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/sessions?user=bob", "", &sessions))
+	assert.Empty(t, sessions)
+
+	var detail SessionDetail
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/sessions/"+s.ID, "", &detail))
+	assert.Equal(t, s.ID, detail.ID)
+	assert.Equal(t, int64(1), detail.FramesIn)
+	assert.Equal(t, "proto", detail.Codec)
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodGet, ts.URL+"/sessions/unknown", "", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, do(t, http.MethodGet, ts.URL+"/sessions/"+s.ID+"/kick", "", nil))
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, ts.URL+"/sessions/"+s.ID+"/kick", "", nil))
+	assert.Eventually(t, func() bool {
+		_, ok := srv.Session(s.ID)
+		return !ok
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), srv.Closed(ktcp.CloseKicked))
+}
+
+func TestHandler_Broadcast(t *testing.T) {
+	srv, ts := serve(t)
+	recv := make(chan *message.Message, 2)
+	dial(t, srv, recv)
+	dial(t, srv, recv)
+	assert.Eventually(t, func() bool {
+		var sessions []Session
+		do(t, http.MethodGet, ts.URL+"/sessions", "", &sessions)
+		return len(sessions) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	var res BroadcastResult
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, ts.URL+"/broadcast", `{"id": 301, "payload": {"name": "maintenance"}}`, &res))
+	assert.Equal(t, BroadcastResult{Sent: 2}, res)
+	for i := 0; i < 2; i++ {
+		msg := receive(t, recv)
+		assert.Equal(t, uint32(noticeID), msg.ID)
+		var notice testData.TestModel
+		require.NoError(t, proto.Unmarshal(msg.Data, &notice))
+		assert.Equal(t, "maintenance", notice.Name)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(t, http.MethodPost, ts.URL+"/broadcast", `{"id": 999}`, nil))
+}
+
+func TestHandler_Tables(t *testing.T) {
+	_, ts := serve(t)
+
+	var routes []Route
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/routes", "", &routes))
+	assert.Contains(t, routes, Route{ID: echoID, Operation: "/test.Echo/Echo", Request: "test.test_model"})
+	for _, r := range routes {
+		assert.NotEqual(t, uint32(noticeID), r.ID)
+	}
+
+	var messages []Message
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/messages", "", &messages))
+	assert.Contains(t, messages, Message{ID: noticeID, Direction: "response", Name: "test.test_model"})
+
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/metrics", "", nil))
+}
@@ -0,0 +1,126 @@
+package ktcp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// WriteBatch coalesces the outbound frames of a session, they are written to the connection
+// by a single vectored write once maxBytes is pending or delay elapsed since the first pending frame.
+// Zero maxBytes or delay disables the threshold, the frames are pending until Session.Flush then.
+// Session.Flush writes the pending frames right away.
+func WriteBatch(delay time.Duration, maxBytes int) ServerOption {
+	return func(s *Server) {
+		s.batchDelay = delay
+		s.batchMaxBytes = maxBytes
+	}
+}
+
+// writeBatch holds the pending outbound frames of a session.
+type writeBatch struct {
+	delay    time.Duration
+	maxBytes int
+	bufs     net.Buffers // pending frames
+	pooled   []*[]byte   // pooled buffers of the pending frames
+	size     int
+	timer    *time.Timer
+	armed    bool
+}
+
+func newWriteBatch(sess *Session, delay time.Duration, maxBytes int) *writeBatch {
+	w := &writeBatch{delay: delay, maxBytes: maxBytes}
+	w.timer = time.AfterFunc(time.Hour, func() {
+		if err := sess.Flush(); err != nil {
+			sess.log.Errorf("session %s flush err: %s", sess.id, err)
+		}
+	})
+	w.timer.Stop()
+	return w
+}
+
+// Flush writes the pending outbound frames to the connection.
+func (s *Session) Flush() error {
+	if s.batch == nil {
+		return nil
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.flushLocked()
+}
+
+// batchMessage packs the message into a pooled buffer and appends it to the pending frames.
+func (s *Session) batchMessage(msg *message.Message) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	buf := packing.GetBuffer()
+	b, err := packing.AppendPack(s.packer, *buf, msg)
+	if err != nil {
+		packing.PutBuffer(buf)
+		return fmt.Errorf("session %s pack outbound message err: %s", s.id, err)
+	}
+	*buf = b
+
+	w := s.batch
+	w.bufs = append(w.bufs, b)
+	w.pooled = append(w.pooled, buf)
+	w.size += len(b)
+	s.measureBatch(1)
+	if w.maxBytes > 0 && w.size >= w.maxBytes {
+		return s.flushLocked()
+	}
+	if !w.armed && w.delay > 0 {
+		w.armed = true
+		w.timer.Reset(w.delay)
+	}
+	return nil
+}
+
+// flushLocked writes the pending frames, s.wmu must be held.
+func (s *Session) flushLocked() (err error) {
+	w := s.batch
+	if w.armed {
+		w.timer.Stop()
+		w.armed = false
+	}
+	if len(w.bufs) == 0 {
+		return nil
+	}
+
+	bufs := w.bufs
+	if err = s.attemptConnWriteBuffers(&bufs, s.writeAttemptTimes); err != nil {
+		err = fmt.Errorf("session %s conn write err: %s", s.id, err)
+	}
+
+	for i, buf := range w.pooled {
+		packing.PutBuffer(buf)
+		w.pooled[i] = nil
+	}
+	s.measureBatch(-len(w.pooled))
+	w.bufs, w.pooled, w.size = w.bufs[:0], w.pooled[:0], 0
+	return
+}
+
+// attemptConnWriteBuffers writes bufs by a vectored write if the connection supports it,
+// the unwritten frames are retried on temporary errors.
+func (s *Session) attemptConnWriteBuffers(bufs *net.Buffers, attemptTimes int) (err error) {
+	for i := 0; i < attemptTimes; i++ {
+		_, err = bufs.WriteTo(s.conn)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				s.log.Infof("session %s write attempt %d temporary err: %s", s.id, i+1, err)
+				s.measureRetry()
+				time.Sleep(tempErrDelay * time.Duration(i))
+				continue
+			}
+			return fmt.Errorf("session %s write attempt %d err: %s", s.id, i+1, err)
+		}
+		return
+	}
+
+	return fmt.Errorf("attemptConnWriteBuffers write failure threshold exceeded")
+}
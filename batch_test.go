@@ -0,0 +1,86 @@
+package ktcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// burstHandler replies n frames to every request.
+type burstHandler struct {
+	echoHandler
+	n     int
+	flush bool
+}
+
+func (h *burstHandler) OnMessage(c Context) {
+	for i := 0; i < h.n; i++ {
+		_ = c.GetSession().SendMsg(uint32(i), &message.Message{})
+	}
+	if h.flush {
+		_ = c.GetSession().Flush()
+	}
+}
+
+// withRawCodec serves the messages with rawCodec.
+func withRawCodec(s *Server) {
+	s.Codec = rawCodec{}
+}
+
+// rawCodec marshals *message.Message as its data.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) { return v.(*message.Message).Data, nil }
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	v.(*message.Message).Data = data
+	return nil
+}
+
+func (rawCodec) Name() string { return "raw" }
+
+func TestSession_WriteBatchDelay(t *testing.T) {
+	_, conn := serveTest(t, &burstHandler{n: 100}, withRawCodec, WriteBatch(50*time.Millisecond, 0))
+	require.NoError(t, packing.PackTo(conn, packing.NewDefaultPacker(), &message.Message{ID: 1}))
+
+	start := time.Now()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+	for i := 0; i < 100; i++ {
+		msg, err := packing.NewDefaultPacker().Unpack(conn)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(i), msg.ID)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestSession_WriteBatchMaxBytes(t *testing.T) {
+	// 10 bytes per frame, the first 90 frames are flushed by the threshold.
+	_, conn := serveTest(t, &burstHandler{n: 95}, withRawCodec, WriteBatch(0, 300))
+	require.NoError(t, packing.PackTo(conn, packing.NewDefaultPacker(), &message.Message{ID: 1}))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+	for i := 0; i < 90; i++ {
+		msg, err := packing.NewDefaultPacker().Unpack(conn)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(i), msg.ID)
+	}
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err := packing.NewDefaultPacker().Unpack(conn)
+	assert.Error(t, err)
+}
+
+func TestSession_Flush(t *testing.T) {
+	_, conn := serveTest(t, &burstHandler{n: 5, flush: true}, withRawCodec, WriteBatch(time.Hour, 0))
+	require.NoError(t, packing.PackTo(conn, packing.NewDefaultPacker(), &message.Message{ID: 1}))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	for i := 0; i < 5; i++ {
+		msg, err := packing.NewDefaultPacker().Unpack(conn)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(i), msg.ID)
+	}
+}
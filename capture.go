@@ -0,0 +1,38 @@
+package ktcp
+
+import (
+	"time"
+
+	"github.com/kwstars/ktcp/capture"
+	"github.com/kwstars/ktcp/message"
+)
+
+// Capture records the decoded frames of the sessions to w, see package capture. filter selects the sessions
+// captured from their start, e.g. by their listener or remote address, all of them if nil.
+// Session.SetCapture starts or stops the capture of a session later, e.g. once it is bound to a user.
+func Capture(w *capture.Writer, filter func(sess *Session) bool) ServerOption {
+	return func(s *Server) {
+		s.capture = w
+		s.captureFilter = filter
+	}
+}
+
+// SetCapture starts or stops the capture of the session frames, it does nothing without the Capture option.
+func (s *Session) SetCapture(on bool) {
+	if on {
+		s.capturing.SetTrue()
+	} else {
+		s.capturing.SetFalse()
+	}
+}
+
+// captureFrame records the frame msg of the session if it is captured.
+func (s *Session) captureFrame(dir capture.Direction, msg *message.Message) {
+	if s.capture == nil || !s.capturing.IsSet() {
+		return
+	}
+	r := &capture.Record{Time: time.Now(), Direction: dir, Session: s.id, Message: msg}
+	if err := s.capture.Write(r); err != nil {
+		s.log.Errorf("session %s capture message %d err: %s", s.id, msg.ID, err)
+	}
+}
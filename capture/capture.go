@@ -0,0 +1,121 @@
+// Package capture records the frames of ktcp sessions to files, e.g. to reproduce the bugs of a client
+// with the ktcp-replay command or package capture/replay, see the Capture server option.
+//
+// The frames are recorded decoded: decrypted, reassembled and decompressed, with the header section
+// still encoded in the data, so that they can be sent again as they are. A capture file is:
+//
+//	file:    magic "KTCPCAP1" | record...
+//	record:  time(8) | direction(1) | session size(1) | session | frame
+//
+// The time is the unix time of the frame in nanoseconds, little endian, the direction is 1 for the frames
+// received by the server and 2 for the frames sent by it, the session is the session id. The frame is the
+// message packed by the packer of the capture, DefaultPacker by default: its id, flag and data.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// Magic starts a capture file.
+const Magic = "KTCPCAP1"
+
+// ErrInvalidFile is returned by NewReader for a file which does not start with Magic.
+var ErrInvalidFile = errors.New("capture: not a capture file")
+
+// Direction is the direction of a frame.
+type Direction uint8
+
+const (
+	// Inbound is a frame received by the server.
+	Inbound Direction = iota + 1
+	// Outbound is a frame sent by the server.
+	Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "in"
+	case Outbound:
+		return "out"
+	default:
+		return "unknown"
+	}
+}
+
+// Record is a captured frame.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Session   string
+	Message   *message.Message
+}
+
+// DefaultPacker returns the packer of the frames of a capture file by default,
+// a packing.DefaultPacker without its data size limit.
+func DefaultPacker() packing.Packer {
+	return &packing.DefaultPacker{MaxDataSize: 1 << 30}
+}
+
+// appendRecord appends the record r with the frame packed by p to b.
+func appendRecord(b []byte, p packing.Packer, r *Record) ([]byte, error) {
+	if len(r.Session) > 0xff {
+		return nil, fmt.Errorf("capture: session id %q is longer than 255 bytes", r.Session)
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(r.Time.UnixNano()))
+	b = append(b, byte(r.Direction), byte(len(r.Session)))
+	b = append(b, r.Session...)
+	return packing.AppendPack(p, b, r.Message)
+}
+
+// Reader reads the records of a capture file.
+type Reader struct {
+	r *bufio.Reader
+	p packing.Packer
+}
+
+// NewReader returns a Reader of the capture file r whose frames are packed by p, DefaultPacker if nil.
+func NewReader(r io.Reader, p packing.Packer) (*Reader, error) {
+	if p == nil {
+		p = DefaultPacker()
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != Magic {
+		return nil, ErrInvalidFile
+	}
+	return &Reader{r: br, p: p}, nil
+}
+
+// Read returns the next record, io.EOF once there are no more records.
+func (r *Reader) Read() (*Record, error) {
+	var head [10]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("capture: truncated record: %w", err)
+		}
+		return nil, err
+	}
+	session := make([]byte, head[9])
+	if _, err := io.ReadFull(r.r, session); err != nil {
+		return nil, fmt.Errorf("capture: truncated record: %w", err)
+	}
+	msg, err := r.p.Unpack(r.r)
+	if err != nil {
+		return nil, fmt.Errorf("capture: read frame err: %w", err)
+	}
+	return &Record{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(head[:8]))),
+		Direction: Direction(head[8]),
+		Session:   string(session),
+		Message:   msg,
+	}, nil
+}
@@ -0,0 +1,114 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, path string) []*Record {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := NewReader(f, nil)
+	require.NoError(t, err)
+	var records []*Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, rec)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ktcp.cap")
+	w, err := NewWriter(path)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, w.Write(&Record{Time: now, Direction: Inbound, Session: "s1", Message: &message.Message{ID: 1, Flag: packing.OKType, Data: []byte("ping")}}))
+	require.NoError(t, w.Write(&Record{Time: now.Add(time.Millisecond), Direction: Outbound, Session: "s1", Message: &message.Message{ID: 2, Flag: packing.ErrType}}))
+	require.NoError(t, w.Close())
+
+	// appends to the existing file.
+	w, err = NewWriter(path)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&Record{Time: now, Direction: Inbound, Session: "s2", Message: &message.Message{ID: 3}}))
+	require.NoError(t, w.Close())
+
+	records := readAll(t, path)
+	require.Len(t, records, 3)
+	assert.Equal(t, now.UnixNano(), records[0].Time.UnixNano())
+	assert.Equal(t, Inbound, records[0].Direction)
+	assert.Equal(t, "s1", records[0].Session)
+	assert.Equal(t, uint32(1), records[0].Message.ID)
+	assert.Equal(t, uint16(packing.OKType), records[0].Message.Flag)
+	assert.Equal(t, []byte("ping"), records[0].Message.Data)
+	assert.Equal(t, Outbound, records[1].Direction)
+	assert.Equal(t, uint16(packing.ErrType), records[1].Message.Flag)
+	assert.Equal(t, "s2", records[2].Session)
+}
+
+func TestWriter_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ktcp.cap")
+	// a record is 8+2+2+10+100 bytes, two records per file.
+	w, err := NewWriter(path, MaxSize(300), MaxFiles(3))
+	require.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		require.NoError(t, w.Write(&Record{Time: time.Now(), Direction: Inbound, Session: "s1", Message: &message.Message{ID: uint32(i), Data: make([]byte, 100)}}))
+	}
+	require.NoError(t, w.Close())
+
+	ids := func(path string) (ids []uint32) {
+		for _, r := range readAll(t, path) {
+			ids = append(ids, r.Message.ID)
+		}
+		return
+	}
+	assert.Equal(t, []uint32{6}, ids(path))
+	assert.Equal(t, []uint32{4, 5}, ids(path+".1"))
+	assert.Equal(t, []uint32{2, 3}, ids(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNewReader_Invalid(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("not a capture")), nil)
+	assert.Equal(t, ErrInvalidFile, err)
+}
+
+func TestWriter_RotateFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ktcp.cap")
+	w, err := NewWriter(path, MaxSize(300), MaxFiles(2))
+	require.NoError(t, err)
+	write := func(id uint32) error {
+		return w.Write(&Record{Time: time.Now(), Direction: Inbound, Session: "s1", Message: &message.Message{ID: id, Data: make([]byte, 100)}})
+	}
+	require.NoError(t, write(0))
+	require.NoError(t, write(1))
+
+	// path.1 cannot be replaced by the current file.
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "dir"), 0o755))
+	assert.Error(t, write(2))
+	require.NoError(t, os.RemoveAll(path+".1"))
+	// the file is opened again and rotated.
+	require.NoError(t, write(3))
+	require.NoError(t, w.Close())
+	assert.Equal(t, os.ErrClosed, write(4))
+
+	assert.Len(t, readAll(t, path+".1"), 2)
+	records := readAll(t, path)
+	require.Len(t, records, 1)
+	assert.Equal(t, uint32(3), records[0].Message.ID)
+}
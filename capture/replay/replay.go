@@ -0,0 +1,358 @@
+// Package replay replays the capture files of a ktcp server against a server, see package capture,
+// and diffs its responses with the captured ones. It is the library of the ktcp-replay command.
+//
+// The inbound frames of each captured session are sent on a new connection at their recorded times,
+// divided by the replay speed, and the responses are compared with the captured outbound frames of their
+// message id: the equal ones match out of order, the others are compared in order. The server must accept
+// the connections without the encryption, integrity and negotiation handshakes, e.g. on a dedicated listener.
+// The frames are captured reassembled, see Fragmentation for the servers with a small max data size.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kwstars/ktcp/capture"
+	"github.com/kwstars/ktcp/compress"
+	"github.com/kwstars/ktcp/fragment"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// Session is the records of a captured session in order.
+type Session struct {
+	ID      string
+	Records []*capture.Record
+}
+
+// Load reads the records of the capture files, packed by p, grouped by session in order of their first record.
+// The capture files are packed by capture.DefaultPacker if p is nil.
+func Load(paths []string, p packing.Packer) ([]*Session, error) {
+	var records []*capture.Record
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r, err := capture.NewReader(f, p)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			records = append(records, rec)
+		}
+		f.Close()
+	}
+	// the rotated files are given in any order.
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	var sessions []*Session
+	byID := make(map[string]*Session)
+	for _, rec := range records {
+		s, ok := byID[rec.Session]
+		if !ok {
+			s = &Session{ID: rec.Session}
+			byID[rec.Session] = s
+			sessions = append(sessions, s)
+		}
+		s.Records = append(s.Records, rec)
+	}
+	return sessions, nil
+}
+
+// Option is a Replayer option.
+type Option func(*Replayer)
+
+// Speed with the replay speed, 1 for the recorded speed by default, 0 for no delay between the frames.
+func Speed(speed float64) Option {
+	return func(r *Replayer) {
+		r.speed = speed
+	}
+}
+
+// Wait awaits the responses of a session until no frame is received for d, 1s by default.
+func Wait(d time.Duration) Option {
+	return func(r *Replayer) {
+		r.wait = d
+	}
+}
+
+// Ignore with the message ids which are neither sent nor compared.
+func Ignore(ids ...uint32) Option {
+	return func(r *Replayer) {
+		for _, id := range ids {
+			r.ignore[id] = true
+		}
+	}
+}
+
+// Fragmentation splits the sent messages larger than conf.ChunkSize into fragments, for the servers
+// with a max data size below the captured messages, and reassembles the received fragments within the
+// limits of conf. The received fragments are reassembled with the default limits of package fragment
+// and the messages are sent whole by default.
+func Fragmentation(conf fragment.Config) Option {
+	return func(r *Replayer) {
+		r.fragment = conf
+	}
+}
+
+// Output writes the differences of the sessions to w, os.Stdout by default.
+func Output(w io.Writer) Option {
+	return func(r *Replayer) {
+		r.out = w
+	}
+}
+
+// Replayer replays the inbound frames of the captured sessions against a server
+// and diffs its responses with the captured outbound frames.
+type Replayer struct {
+	network  string
+	addr     string
+	packer   packing.Packer // packer of the server frames
+	speed    float64        // replay speed, 1 for the recorded speed, 0 for no delay between the frames
+	wait     time.Duration  // the responses are awaited until no frame is received for wait
+	ignore   map[uint32]bool
+	fragment fragment.Config // the sent messages are not fragmented if ChunkSize is zero
+	out      io.Writer
+	mu       sync.Mutex // serializes the writes to out
+}
+
+// New creates a *Replayer of the server of addr on network, p packs the frames of the server.
+func New(network, addr string, p packing.Packer, opts ...Option) *Replayer {
+	r := &Replayer{
+		network: network,
+		addr:    addr,
+		packer:  p,
+		speed:   1,
+		wait:    time.Second,
+		ignore:  make(map[uint32]bool),
+		out:     os.Stdout,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// Run replays the sessions concurrently from the time of the first record, it returns the number of differences.
+func (r *Replayer) Run(ctx context.Context, sessions []*Session) (int, error) {
+	if len(sessions) == 0 {
+		return 0, nil
+	}
+	origin := sessions[0].Records[0].Time
+	start := time.Now()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		diffs int
+		first error
+	)
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			n, err := r.replay(ctx, s, origin, start)
+			mu.Lock()
+			defer mu.Unlock()
+			diffs += n
+			if err != nil && first == nil {
+				first = fmt.Errorf("session %s: %s", s.ID, err)
+			}
+		}(s)
+	}
+	wg.Wait()
+	return diffs, first
+}
+
+// replay replays the session s, the frame recorded at origin is sent at start.
+func (r *Replayer) replay(ctx context.Context, s *Session, origin, start time.Time) (int, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, r.network, r.addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	recv := make(chan *message.Message, 64)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(recv)
+		// the frames are captured reassembled and decompressed.
+		reassembler := fragment.NewReassembler(r.fragment)
+		for {
+			msg, err := r.packer.Unpack(conn)
+			if err != nil {
+				return
+			}
+			if msg.Flag&packing.FlagFragment != 0 {
+				full, err := reassembler.Add(msg, time.Now())
+				if err != nil {
+					return
+				}
+				if full == nil {
+					continue
+				}
+				msg = full
+			}
+			if _, err := compress.DecompressMessage(msg, 0); err != nil {
+				return
+			}
+			select {
+			case recv <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var (
+		sent, fragments int
+		expected        []*message.Message
+	)
+	for _, rec := range s.Records {
+		if r.ignore[rec.Message.ID] {
+			continue
+		}
+		if rec.Direction == capture.Outbound {
+			expected = append(expected, rec.Message)
+			continue
+		}
+		if r.speed > 0 {
+			at := start.Add(time.Duration(float64(rec.Time.Sub(origin)) / r.speed))
+			select {
+			case <-time.After(time.Until(at)):
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+		if r.fragment.ChunkSize > 0 && len(rec.Message.Data) > r.fragment.ChunkSize {
+			fragments++
+			err = fragment.Split(rec.Message, uint32(fragments), r.fragment.ChunkSize, func(msg *message.Message) error {
+				return packing.PackTo(conn, r.packer, msg)
+			})
+		} else {
+			err = packing.PackTo(conn, r.packer, rec.Message)
+		}
+		if err != nil {
+			return 0, err
+		}
+		sent++
+	}
+
+	var received []*message.Message
+	timer := time.NewTimer(r.wait)
+	defer timer.Stop()
+	for len(received) < len(expected) {
+		select {
+		case msg, ok := <-recv:
+			if !ok {
+				return r.report(s, sent, expected, received), nil
+			}
+			if !r.ignore[msg.ID] {
+				received = append(received, msg)
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(r.wait)
+		case <-timer.C:
+			return r.report(s, sent, expected, received), nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	return r.report(s, sent, expected, received), nil
+}
+
+// report writes the differences between the expected and the received frames of s. A received frame
+// equal to an expected one of its id matches it, since the handlers may reply out of order; the other
+// frames of an id are compared in order. It returns the number of differences.
+func (r *Replayer) report(s *Session, sent int, expected, received []*message.Message) int {
+	byID := make(map[uint32][]*message.Message)
+	for _, msg := range received {
+		byID[msg.ID] = append(byID[msg.ID], msg)
+	}
+	used := make(map[*message.Message]bool)
+	matched := make([]bool, len(expected))
+	for i, want := range expected {
+		for _, got := range byID[want.ID] {
+			if !used[got] && got.Flag == want.Flag && bytes.Equal(got.Data, want.Data) {
+				used[got], matched[i] = true, true
+				break
+			}
+		}
+	}
+	next := func(id uint32) *message.Message {
+		for _, got := range byID[id] {
+			if !used[got] {
+				used[got] = true
+				return got
+			}
+		}
+		return nil
+	}
+
+	var lines []string
+	for i, want := range expected {
+		if matched[i] {
+			continue
+		}
+		got := next(want.ID)
+		if got == nil {
+			lines = append(lines, fmt.Sprintf("  - missing #%d id %d flag %#x (%d bytes)", i, want.ID, want.Flag, len(want.Data)))
+			continue
+		}
+		if got.Flag != want.Flag {
+			lines = append(lines, fmt.Sprintf("  ~ #%d id %d: flag %#x, want %#x", i, want.ID, got.Flag, want.Flag))
+		}
+		if !bytes.Equal(got.Data, want.Data) {
+			lines = append(lines, fmt.Sprintf("  ~ #%d id %d: data differs at byte %d (%d bytes, want %d)",
+				i, want.ID, mismatch(got.Data, want.Data), len(got.Data), len(want.Data)))
+		}
+	}
+	for _, msg := range received {
+		if !used[msg] {
+			lines = append(lines, fmt.Sprintf("  + unexpected id %d flag %#x (%d bytes)", msg.ID, msg.Flag, len(msg.Data)))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.out, "session %s: %d sent, %d expected, %d received, %d differences\n",
+		s.ID, sent, len(expected), len(received), len(lines))
+	for _, line := range lines {
+		fmt.Fprintln(r.out, line)
+	}
+	return len(lines)
+}
+
+// mismatch returns the offset of the first different byte of a and b.
+func mismatch(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) < len(b) {
+		return len(a)
+	}
+	return len(b)
+}
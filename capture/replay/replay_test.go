@@ -0,0 +1,141 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp"
+	"github.com/kwstars/ktcp/capture"
+	"github.com/kwstars/ktcp/fragment"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// greeter replies the requests with the greeting of their name.
+type greeter struct {
+	greeting string
+}
+
+func (greeter) OnConnect(*ktcp.Session) {}
+
+func (g greeter) OnMessage(c ktcp.Context) {
+	var in testData.TestModel
+	if err := c.Bind(&in); err != nil {
+		return
+	}
+	_ = c.Send(c.GetReqMsg().ID+1, &testData.TestModel{Name: g.greeting + " " + in.Name})
+}
+
+func (greeter) OnClose(*ktcp.Session) {}
+
+func serve(t *testing.T, h ktcp.Handler, opts ...ktcp.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := ktcp.NewServer(h, append(opts, ktcp.Listener(lis))...)
+	go func() { _ = srv.Serve() }()
+	t.Cleanup(func() { _ = srv.Stop(context.Background()) })
+
+	// the server serves once it has a session, Stop must not race with Serve.
+	probe, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		n := 0
+		srv.RangeSessions(func(*ktcp.Session) bool { n++; return true })
+		return n > 0
+	}, 3*time.Second, 10*time.Millisecond)
+	probe.Close()
+	return lis.Addr().String()
+}
+
+// record captures a session greeting names.
+func record(t *testing.T, names ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ktcp.cap")
+	w, err := capture.NewWriter(path)
+	require.NoError(t, err)
+	addr := serve(t, greeter{greeting: "hello"}, ktcp.Capture(w, nil))
+
+	recv := make(chan struct{}, len(names))
+	c, err := ktcp.Dial("tcp", addr, ktcp.ClientHandler(func(*message.Message) { recv <- struct{}{} }))
+	require.NoError(t, err)
+	for _, name := range names {
+		require.NoError(t, c.Send(1, &testData.TestModel{Name: name}))
+		select {
+		case <-recv:
+		case <-time.After(3 * time.Second):
+			t.Fatal("no response")
+		}
+	}
+	require.NoError(t, c.Close())
+	require.NoError(t, w.Close())
+	return path
+}
+
+func replay(t *testing.T, path, addr string, opts ...Option) (int, string) {
+	t.Helper()
+	sessions, err := Load([]string{path}, nil)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	var out bytes.Buffer
+	r := New("tcp", addr, packing.NewDefaultPacker(), append([]Option{Speed(10), Output(&out)}, opts...)...)
+	diffs, err := r.Run(context.Background(), sessions)
+	require.NoError(t, err)
+	return diffs, out.String()
+}
+
+func TestReplay(t *testing.T) {
+	path := record(t, "alice", "bob")
+
+	sessions, err := Load([]string{path}, nil)
+	require.NoError(t, err)
+	var dirs []capture.Direction
+	for _, rec := range sessions[0].Records {
+		dirs = append(dirs, rec.Direction)
+	}
+	assert.Equal(t, []capture.Direction{capture.Inbound, capture.Outbound, capture.Inbound, capture.Outbound}, dirs)
+
+	diffs, out := replay(t, path, serve(t, greeter{greeting: "hello"}))
+	assert.Equal(t, 0, diffs, out)
+	assert.Contains(t, out, "2 sent, 2 expected, 2 received, 0 differences")
+
+	diffs, out = replay(t, path, serve(t, greeter{greeting: "bye"}))
+	assert.Equal(t, 2, diffs, out)
+	assert.Equal(t, 2, strings.Count(out, "id 2: data differs"), out)
+
+	diffs, out = replay(t, path, serve(t, greeter{greeting: "bye"}), Ignore(2))
+	assert.Equal(t, 0, diffs, out)
+}
+
+func TestReplay_Missing(t *testing.T) {
+	path := record(t, "alice")
+	diffs, out := replay(t, path, serve(t, ktcp.Handler(silent{})))
+	assert.Equal(t, 1, diffs)
+	assert.Contains(t, out, "- missing #0 id 2")
+}
+
+// silent does not reply.
+type silent struct{ greeter }
+
+func (silent) OnMessage(ktcp.Context) {}
+
+func TestReplay_Fragmentation(t *testing.T) {
+	path := record(t, strings.Repeat("alice", 8))
+
+	// the server refuses the captured request beyond its max data size, and fragments its response.
+	conf := fragment.Config{ChunkSize: 16}
+	addr := serve(t, greeter{greeting: "hello"}, ktcp.Fragmentation(conf), func(s *ktcp.Server) {
+		s.Packer = &packing.DefaultPacker{MaxDataSize: 32}
+	})
+	diffs, out := replay(t, path, addr, Fragmentation(conf))
+	assert.Equal(t, 0, diffs, out)
+	assert.Contains(t, out, "1 sent, 1 expected, 1 received, 0 differences")
+}
@@ -0,0 +1,167 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/kwstars/ktcp/packing"
+)
+
+// Option is a Writer option.
+type Option func(*Writer)
+
+// MaxSize rotates the capture file once it is n bytes, 64MB by default.
+func MaxSize(n int64) Option {
+	return func(w *Writer) {
+		w.maxSize = n
+	}
+}
+
+// MaxFiles keeps n capture files: the current one and the n-1 rotated ones, 5 by default.
+// The rotated files are suffixed by .1, .2, ... from the newest one.
+func MaxFiles(n int) Option {
+	return func(w *Writer) {
+		w.maxFiles = n
+	}
+}
+
+// Packer packs the frames of the capture files by p, DefaultPacker by default.
+func Packer(p packing.Packer) Option {
+	return func(w *Writer) {
+		w.packer = p
+	}
+}
+
+// Writer writes the records to a rotating capture file, it is safe for concurrent use.
+type Writer struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	packer   packing.Packer
+
+	mu     sync.Mutex
+	f      *os.File // nil if closed or a rotation failed, the file is opened again by the next Write
+	closed bool
+	size   int64
+	buf    []byte
+}
+
+// NewWriter opens the capture file of path, the records are appended if it exists.
+func NewWriter(path string, opts ...Option) (*Writer, error) {
+	w := &Writer{
+		path:     path,
+		maxSize:  64 << 20,
+		maxFiles: 5,
+		packer:   DefaultPacker(),
+	}
+	for _, o := range opts {
+		o(w)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the file of w.path, it writes the magic of a new file.
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("capture: open %s err: %s", w.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("capture: stat %s err: %s", w.path, err)
+	}
+	size := info.Size()
+	if size == 0 {
+		n, err := f.WriteString(Magic)
+		size += int64(n)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("capture: write %s err: %s", w.path, err)
+		}
+	}
+	w.f, w.size = f, size
+	return nil
+}
+
+// rotate renames the current file to path.1, and the rotated ones to the next suffix.
+// The current file is closed even if rotate fails.
+func (w *Writer) rotate() error {
+	err := w.f.Close()
+	w.f = nil
+	if err != nil {
+		return fmt.Errorf("capture: close %s err: %s", w.path, err)
+	}
+	if w.maxFiles <= 1 {
+		if err := os.Remove(w.path); err != nil {
+			return fmt.Errorf("capture: rotate %s err: %s", w.path, err)
+		}
+		return w.open()
+	}
+	for i := w.maxFiles - 2; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return fmt.Errorf("capture: rotate %s err: %s", w.path, err)
+	}
+	return w.open()
+}
+
+// Write appends the record r to the capture file.
+func (w *Writer) Write(r *Record) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.f == nil {
+		// the last rotation failed.
+		if err = w.open(); err != nil {
+			return err
+		}
+	}
+	if w.buf, err = appendRecord(w.buf[:0], w.packer, r); err != nil {
+		return err
+	}
+	if w.size > int64(len(Magic)) && w.size+int64(len(w.buf)) > w.maxSize {
+		if err = w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.f.Write(w.buf)
+	if err != nil {
+		if n > 0 {
+			w.discard()
+		}
+		return fmt.Errorf("capture: write %s err: %s", w.path, err)
+	}
+	w.size += int64(n)
+	return nil
+}
+
+// discard truncates the partial record written after w.size, so the next records are readable.
+// The file is rotated if it can't be truncated, the partial record ends the rotated file.
+func (w *Writer) discard() {
+	if err := w.f.Truncate(w.size); err == nil {
+		return
+	}
+	_ = w.rotate()
+}
+
+// Close closes the capture file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.f == nil {
+		w.closed = true
+		return nil
+	}
+	err := w.f.Close()
+	w.f, w.closed = nil, true
+	return err
+}
@@ -0,0 +1,59 @@
+package ktcp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/capture"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureHandler starts the capture of the session on the message 2.
+type captureHandler struct{ rawEchoHandler }
+
+func (captureHandler) OnMessage(c Context) {
+	if c.GetReqMsg().ID == 2 {
+		c.GetSession().SetCapture(true)
+	}
+	_ = c.GetSession().SendMsg(c.GetReqMsg().ID, c.GetReqMsg())
+}
+
+func TestServer_Capture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ktcp.cap")
+	w, err := capture.NewWriter(path)
+	require.NoError(t, err)
+	_, conn := serveTest(t, captureHandler{}, withRawCodec, Capture(w, func(*Session) bool { return false }))
+
+	p := packing.NewDefaultPacker()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+	for _, id := range []uint32{2, 3} {
+		require.NoError(t, packing.PackTo(conn, p, &message.Message{ID: id, Flag: packing.OKType, Data: []byte("data")}))
+		_, err = p.Unpack(conn)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := capture.NewReader(f, nil)
+	require.NoError(t, err)
+	var got []string
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data"), rec.Message.Data)
+		got = append(got, rec.Direction.String()+" "+string(rune('0'+rec.Message.ID)))
+	}
+	// the message 2 is captured once the handler started the capture.
+	assert.Equal(t, []string{"out 2", "in 3", "out 3"}, got)
+}
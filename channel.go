@@ -0,0 +1,496 @@
+package ktcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// The virtual channels are multiplexed on a connection as the frames marked with packing.FlagChannel,
+// whose data start with the channel header:
+//
+//	channel id(4)|type(1)|payload(n)
+//
+// A side opens a channel with an open frame whose payload is the kind of the channel and the receive
+// window of the opener, kind(2)|window(4). The channels opened by the client have odd ids, the ones
+// opened by the server even ids. The acceptor answers with a credit frame granting its receive window.
+//
+// Every channel has its own credit-based flow control: a side sends a data frame of a channel once it
+// has the credits of its data, the size of the data is deducted from them. The receiver grants the consumed bytes
+// back with credit frames, whose payload is the granted bytes, credits(4). A side ends a channel with
+// an empty close frame.
+
+const (
+	channelHeaderSize = 4 + 1
+	// DefaultChannelWindow is the default receive window of a channel.
+	DefaultChannelWindow = 64 * KB
+	// DefaultMaxChannels is the default max number of the open channels opened by the peer of a connection.
+	DefaultMaxChannels = 64
+	// channelAcceptQueueSize is the number of the opened channels waiting for AcceptChannel,
+	// the channels beyond are closed.
+	channelAcceptQueueSize = 16
+)
+
+const (
+	channelData byte = iota
+	channelOpen
+	channelCredit
+	channelClose
+)
+
+// ErrChannelClosed is returned when a channel or its connection is closed.
+var ErrChannelClosed = errors.New("channel closed")
+
+// ChannelWindow with the receive window of the channels in bytes, DefaultChannelWindow by default.
+func ChannelWindow(n int) ServerOption {
+	return func(s *Server) {
+		s.channelWindow = n
+	}
+}
+
+// MaxChannels with the max number of the open channels opened by the client of a session,
+// DefaultMaxChannels by default. The channels opened beyond are closed.
+func MaxChannels(n int) ServerOption {
+	return func(s *Server) {
+		s.maxChannels = n
+	}
+}
+
+// ChannelHandler handles the channels of kind opened by the clients, h is called in a new goroutine.
+// The channels of the kinds without a handler are returned by Session.AcceptChannel, Channel.Session
+// returns the session of a channel.
+func ChannelHandler(kind uint16, h func(ch *Channel)) ServerOption {
+	return func(s *Server) {
+		if s.channelHandlers == nil {
+			s.channelHandlers = make(map[uint16]func(ch *Channel))
+		}
+		s.channelHandlers[kind] = h
+	}
+}
+
+// OpenChannel opens a channel of kind, it returns once the client accepts it or ctx is done.
+func (s *Session) OpenChannel(ctx context.Context, kind uint16) (*Channel, error) {
+	return s.channels.open(ctx, kind)
+}
+
+// AcceptChannel returns the next channel opened by the client without a ChannelHandler.
+func (s *Session) AcceptChannel(ctx context.Context) (*Channel, error) {
+	return s.channels.accept(ctx)
+}
+
+// Channel is a virtual channel of a connection, it is safe for concurrent use.
+type Channel struct {
+	mux      *channelMux
+	id       uint32
+	kind     uint16
+	accepted chan struct{} // closed when the peer grants the first credits or the channel is closed
+	once     sync.Once
+
+	mu       sync.Mutex
+	credit   int // send credits
+	window   int // the receive window of the peer, a message beyond is never sendable
+	queue    []*message.Message
+	queued   int // the received bytes which are not consumed
+	consumed int // the consumed bytes which are not granted back
+	closed   bool
+	err      error         // returned once the queue is drained
+	sendable chan struct{} // signals new credits or the close
+	readable chan struct{} // signals a new message or the close
+}
+
+// ID returns the channel id.
+func (c *Channel) ID() uint32 {
+	return c.id
+}
+
+// Kind returns the channel kind.
+func (c *Channel) Kind() uint16 {
+	return c.kind
+}
+
+// Session returns the session of the channel, nil for the channels of a Client.
+func (c *Channel) Session() *Session {
+	return c.mux.sess
+}
+
+// Send sends v as a message of id, it blocks until the channel has the credits of the message or ctx is done.
+func (c *Channel) Send(ctx context.Context, id uint32, v interface{}) error {
+	codec := c.mux.codec()
+	if codec == nil {
+		return fmt.Errorf("message codec is nil")
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(ctx, &message.Message{ID: id, Flag: packing.OKType, Data: data})
+}
+
+// SendMessage sends msg, it blocks until the channel has the credits of the message or ctx is done.
+// It returns an error if the message is beyond the receive window of the peer.
+func (c *Channel) SendMessage(ctx context.Context, msg *message.Message) error {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			signal(c.sendable)
+			return ErrChannelClosed
+		}
+		if len(msg.Data) > c.window {
+			c.mu.Unlock()
+			return fmt.Errorf("the dataSize %d is beyond the channel window: %d", len(msg.Data), c.window)
+		}
+		if c.credit >= len(msg.Data) {
+			c.credit -= len(msg.Data)
+			if c.credit > 0 {
+				signal(c.sendable)
+			}
+			c.mu.Unlock()
+			break
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.sendable:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return c.mux.write(channelFrame(c.id, channelData, msg.ID, msg.Flag, msg.Data))
+}
+
+// Recv receives the next message, it returns io.EOF once the peer closes the channel.
+// The message can be released once it is handled.
+func (c *Channel) Recv(ctx context.Context) (*message.Message, error) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			msg := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.queued -= len(msg.Data)
+			c.consumed += len(msg.Data)
+			grant := 0
+			if c.consumed >= c.mux.window/2 && c.err == nil {
+				grant, c.consumed = c.consumed, 0
+			}
+			if len(c.queue) > 0 {
+				signal(c.readable)
+			}
+			c.mu.Unlock()
+			if grant > 0 {
+				_ = c.mux.writeControl(c.id, channelCredit, uint32(grant))
+			}
+			return msg, nil
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			signal(c.readable)
+			return nil, err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.readable:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close closes the channel, the messages not received are dropped.
+func (c *Channel) Close() error {
+	if !c.mux.remove(c.id) {
+		return nil
+	}
+	c.shutdown(ErrChannelClosed)
+	return c.mux.writeControl(c.id, channelClose, 0)
+}
+
+// shutdown stops the sending of the channel, Recv returns err once the queue is drained.
+func (c *Channel) shutdown(err error) {
+	c.mu.Lock()
+	c.closed = true
+	if c.err == nil {
+		c.err = err
+	}
+	if err == ErrChannelClosed {
+		for _, msg := range c.queue {
+			msg.Release()
+		}
+		c.queue = nil
+	}
+	c.mu.Unlock()
+	c.accept()
+	signal(c.sendable)
+	signal(c.readable)
+}
+
+// accept marks the channel accepted by the peer or closed.
+func (c *Channel) accept() {
+	c.once.Do(func() { close(c.accepted) })
+}
+
+// signal wakes up a waiter of ch, the waiters signal the next one while the state allows it.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// channelFrame returns a channel frame message.
+func channelFrame(channelID uint32, typ byte, id uint32, flag uint16, payload []byte) *message.Message {
+	data := make([]byte, channelHeaderSize, channelHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data, channelID)
+	data[4] = typ
+	return &message.Message{ID: id, Flag: flag | packing.FlagChannel, Data: append(data, payload...)}
+}
+
+// channelMux multiplexes the channels of a connection.
+type channelMux struct {
+	sess     *Session // nil on the client side
+	write    func(msg *message.Message) error
+	codec    func() encoding.Codec
+	window   int
+	max      int // max number of the open channels opened by the peer
+	handlers map[uint16]func(ch *Channel)
+	accepts  chan *Channel
+
+	mu       sync.Mutex
+	channels map[uint32]*Channel
+	lastID   uint32 // the id of the last channel opened by this side
+	accepted int    // the number of the open channels opened by the peer
+	closed   bool
+}
+
+// newChannelMux creates a *channelMux of sess, or of the client side if sess is nil.
+// The channels opened by the client side have odd ids.
+func newChannelMux(sess *Session, write func(msg *message.Message) error, codec func() encoding.Codec, window, max int, handlers map[uint16]func(ch *Channel)) *channelMux {
+	if window <= 0 {
+		window = DefaultChannelWindow
+	}
+	if max <= 0 {
+		max = DefaultMaxChannels
+	}
+	m := &channelMux{
+		sess:     sess,
+		write:    write,
+		codec:    codec,
+		window:   window,
+		max:      max,
+		handlers: handlers,
+		accepts:  make(chan *Channel, channelAcceptQueueSize),
+		channels: make(map[uint32]*Channel),
+	}
+	if sess == nil {
+		m.lastID = 1<<32 - 1 // the first id is 1.
+	}
+	return m
+}
+
+func (m *channelMux) newChannel(id uint32, kind uint16) *Channel {
+	return &Channel{
+		mux:      m,
+		id:       id,
+		kind:     kind,
+		accepted: make(chan struct{}),
+		sendable: make(chan struct{}, 1),
+		readable: make(chan struct{}, 1),
+	}
+}
+
+func (m *channelMux) writeControl(id uint32, typ byte, v uint32) error {
+	var payload []byte
+	if typ == channelCredit {
+		payload = binary.BigEndian.AppendUint32(nil, v)
+	}
+	return m.write(channelFrame(id, typ, 0, packing.OKType, payload))
+}
+
+func (m *channelMux) open(ctx context.Context, kind uint16) (*Channel, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrChannelClosed
+	}
+	m.lastID += 2
+	ch := m.newChannel(m.lastID, kind)
+	m.channels[ch.id] = ch
+	m.mu.Unlock()
+
+	payload := binary.BigEndian.AppendUint16(nil, kind)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(m.window))
+	if err := m.write(channelFrame(ch.id, channelOpen, 0, packing.OKType, payload)); err != nil {
+		m.remove(ch.id)
+		return nil, err
+	}
+	select {
+	case <-ch.accepted:
+		ch.mu.Lock()
+		closed := ch.closed
+		ch.mu.Unlock()
+		if closed {
+			return nil, ErrChannelClosed
+		}
+		return ch, nil
+	case <-ctx.Done():
+		_ = ch.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (m *channelMux) accept(ctx context.Context) (*Channel, error) {
+	select {
+	case ch, ok := <-m.accepts:
+		if !ok {
+			return nil, ErrChannelClosed
+		}
+		return ch, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *channelMux) remove(id uint32) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.channels[id]; !ok {
+		return false
+	}
+	delete(m.channels, id)
+	if id%2 != m.lastID%2 {
+		m.accepted--
+	}
+	return true
+}
+
+// dispatch dispatches the inbound channel frame msg, an error breaks the connection.
+func (m *channelMux) dispatch(msg *message.Message) error {
+	if len(msg.Data) < channelHeaderSize {
+		msg.Release()
+		return fmt.Errorf("the channel frame size %d is less than the header", len(msg.Data))
+	}
+	id, typ := binary.BigEndian.Uint32(msg.Data), msg.Data[4]
+	msg.Data = msg.Data[:copy(msg.Data, msg.Data[channelHeaderSize:])]
+
+	if typ != channelData {
+		defer msg.Release()
+	}
+	m.mu.Lock()
+	ch := m.channels[id]
+	m.mu.Unlock()
+
+	switch typ {
+	case channelOpen:
+		if len(msg.Data) != 2+4 {
+			return fmt.Errorf("channel %d invalid open frame", id)
+		}
+		if ch != nil || id%2 == m.lastID%2 {
+			return fmt.Errorf("channel %d is opened twice", id)
+		}
+		m.acceptOpen(id, binary.BigEndian.Uint16(msg.Data), int(binary.BigEndian.Uint32(msg.Data[2:])))
+	case channelCredit:
+		if len(msg.Data) != 4 {
+			return fmt.Errorf("channel %d invalid credit frame", id)
+		}
+		if ch == nil {
+			return nil
+		}
+		credit := int(binary.BigEndian.Uint32(msg.Data))
+		ch.mu.Lock()
+		if ch.window == 0 {
+			// the first credits grant the receive window of the acceptor.
+			ch.window = credit
+		}
+		ch.credit += credit
+		ch.mu.Unlock()
+		ch.accept()
+		signal(ch.sendable)
+	case channelClose:
+		if m.remove(id) {
+			ch.shutdown(io.EOF)
+		}
+	case channelData:
+		if ch == nil {
+			msg.Release()
+			return nil
+		}
+		ch.mu.Lock()
+		if ch.queued+len(msg.Data) > m.window {
+			ch.mu.Unlock()
+			msg.Release()
+			return fmt.Errorf("channel %d exceeds the window %d", id, m.window)
+		}
+		if ch.closed {
+			ch.mu.Unlock()
+			msg.Release()
+			return nil
+		}
+		msg.Flag &^= packing.FlagChannel
+		ch.queue = append(ch.queue, msg)
+		ch.queued += len(msg.Data)
+		ch.mu.Unlock()
+		signal(ch.readable)
+	default:
+		return fmt.Errorf("channel %d invalid frame type %d", id, typ)
+	}
+	return nil
+}
+
+// acceptOpen registers the channel opened by the peer and grants it the receive window,
+// the channel is closed if the peer has too many open channels or the accept queue is full.
+func (m *channelMux) acceptOpen(id uint32, kind uint16, credit int) {
+	ch := m.newChannel(id, kind)
+	ch.credit, ch.window = credit, credit
+	ch.accept()
+
+	h := m.handlers[kind]
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	if m.accepted >= m.max || h == nil && len(m.accepts) == cap(m.accepts) {
+		m.mu.Unlock()
+		_ = m.writeControl(id, channelClose, 0)
+		return
+	}
+	m.channels[id] = ch
+	m.accepted++
+	if h == nil {
+		m.accepts <- ch
+	}
+	m.mu.Unlock()
+
+	_ = m.writeControl(id, channelCredit, uint32(m.window))
+	if h != nil {
+		go h(ch)
+	}
+}
+
+// close closes the channels once the connection is closed.
+func (m *channelMux) close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	channels := m.channels
+	m.channels = make(map[uint32]*Channel)
+	m.accepted = 0
+	close(m.accepts)
+	m.mu.Unlock()
+	for _, ch := range channels {
+		ch.shutdown(ErrChannelClosed)
+	}
+}
@@ -0,0 +1,160 @@
+package ktcp
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	echoChannel = iota + 1
+	holdChannel
+	pushChannel
+)
+
+// channelHandler opens a push channel to every client.
+type channelHandler struct{}
+
+func (channelHandler) OnConnect(sess *Session) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		ch, err := sess.OpenChannel(ctx, pushChannel)
+		if err != nil {
+			return
+		}
+		_ = ch.SendMessage(ctx, &message.Message{ID: 1, Data: []byte("hello")})
+		_ = ch.Close()
+	}()
+}
+
+func (channelHandler) OnMessage(Context) {}
+
+func (channelHandler) OnClose(*Session) {}
+
+func echo(ch *Channel) {
+	ctx := context.Background()
+	for {
+		msg, err := ch.Recv(ctx)
+		if err != nil {
+			_ = ch.Close()
+			return
+		}
+		_ = ch.SendMessage(ctx, msg)
+		msg.Release()
+	}
+}
+
+func TestChannel_Echo(t *testing.T) {
+	_, conn := serveTest(t, channelHandler{}, ChannelHandler(echoChannel, echo))
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ch, err := c.OpenChannel(ctx, echoChannel)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), ch.ID())
+	assert.Equal(t, uint16(echoChannel), ch.Kind())
+	for i := uint32(1); i <= 3; i++ {
+		require.NoError(t, ch.SendMessage(ctx, &message.Message{ID: i, Data: []byte("ping")}))
+	}
+	for i := uint32(1); i <= 3; i++ {
+		msg, err := ch.Recv(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, i, msg.ID)
+		assert.Equal(t, "ping", string(msg.Data))
+	}
+	require.NoError(t, ch.Close())
+	_, err = ch.Recv(ctx)
+	assert.Equal(t, ErrChannelClosed, err)
+	assert.Equal(t, ErrChannelClosed, ch.SendMessage(ctx, &message.Message{ID: 1}))
+}
+
+func TestChannel_Accept(t *testing.T) {
+	_, conn := serveTest(t, channelHandler{})
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ch, err := c.AcceptChannel(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), ch.ID())
+	assert.Equal(t, uint16(pushChannel), ch.Kind())
+	msg, err := ch.Recv(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(msg.Data))
+	_, err = ch.Recv(ctx)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestChannel_FlowControl(t *testing.T) {
+	held := make(chan *Channel, 1)
+	_, conn := serveTest(t, channelHandler{},
+		ChannelWindow(16),
+		ChannelHandler(echoChannel, echo),
+		ChannelHandler(holdChannel, func(ch *Channel) { held <- ch }),
+	)
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hold, err := c.OpenChannel(ctx, holdChannel)
+	require.NoError(t, err)
+	// a message beyond the window is never sendable.
+	assert.Error(t, hold.SendMessage(ctx, &message.Message{ID: 1, Data: make([]byte, 17)}))
+	// the window of 16 bytes has no room for a message beyond the remaining credits.
+	require.NoError(t, hold.SendMessage(ctx, &message.Message{ID: 1, Data: make([]byte, 8)}))
+	blocked, cancelBlocked := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelBlocked()
+	assert.Equal(t, context.DeadlineExceeded, hold.SendMessage(blocked, &message.Message{ID: 1, Data: make([]byte, 9)}))
+	// it is exhausted by two messages the server does not read.
+	require.NoError(t, hold.SendMessage(ctx, &message.Message{ID: 1, Data: make([]byte, 8)}))
+	blocked, cancelBlocked = context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelBlocked()
+	assert.Equal(t, context.DeadlineExceeded, hold.SendMessage(blocked, &message.Message{ID: 1, Data: make([]byte, 8)}))
+
+	// the other channels of the connection are not blocked.
+	ch, err := c.OpenChannel(ctx, echoChannel)
+	require.NoError(t, err)
+	require.NoError(t, ch.SendMessage(ctx, &message.Message{ID: 2, Data: []byte("ping")}))
+	msg, err := ch.Recv(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(msg.Data))
+
+	// reading on the server grants credits back.
+	srvCh := <-held
+	assert.NotNil(t, srvCh.Session())
+	assert.Nil(t, hold.Session())
+	for i := 0; i < 2; i++ {
+		msg, err := srvCh.Recv(ctx)
+		require.NoError(t, err)
+		assert.Len(t, msg.Data, 8)
+	}
+	require.NoError(t, hold.SendMessage(ctx, &message.Message{ID: 1, Data: make([]byte, 8)}))
+}
+
+func TestChannel_MaxChannels(t *testing.T) {
+	_, conn := serveTest(t, channelHandler{}, ChannelHandler(echoChannel, echo), MaxChannels(2))
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	first, err := c.OpenChannel(ctx, echoChannel)
+	require.NoError(t, err)
+	_, err = c.OpenChannel(ctx, echoChannel)
+	require.NoError(t, err)
+	_, err = c.OpenChannel(ctx, echoChannel)
+	assert.Equal(t, ErrChannelClosed, err)
+
+	// a closed channel frees its slot.
+	require.NoError(t, first.Close())
+	assert.Eventually(t, func() bool {
+		ch, err := c.OpenChannel(ctx, echoChannel)
+		return err == nil && ch != nil
+	}, 3*time.Second, 10*time.Millisecond)
+}
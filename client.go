@@ -0,0 +1,457 @@
+package ktcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/kwstars/ktcp/compress"
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/encoding/proto"
+	"github.com/kwstars/ktcp/fragment"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// ClientOption is a client option.
+type ClientOption func(*Client)
+
+// ClientPacker with client packer, DefaultPacker by default.
+func ClientPacker(p packing.Packer) ClientOption {
+	return func(c *Client) {
+		c.packer = p
+	}
+}
+
+// ClientCodec with client codec, proto by default.
+func ClientCodec(codec encoding.Codec) ClientOption {
+	return func(c *Client) {
+		c.codec = codec
+	}
+}
+
+// ClientHandler handles the inbound messages which are not stream frames,
+// they are dropped if no handler is set. msg is released once h returns.
+func ClientHandler(h func(msg *message.Message)) ClientOption {
+	return func(c *Client) {
+		c.handler = h
+	}
+}
+
+// ClientChannelWindow with the receive window of the channels in bytes, DefaultChannelWindow by default.
+func ClientChannelWindow(n int) ClientOption {
+	return func(c *Client) {
+		c.channelWindow = n
+	}
+}
+
+// ClientChannelHandler handles the channels of kind opened by the server, h is called in a new goroutine.
+// The channels of the kinds without a handler are returned by Client.AcceptChannel.
+func ClientChannelHandler(kind uint16, h func(ch *Channel)) ClientOption {
+	return func(c *Client) {
+		if c.channelHandlers == nil {
+			c.channelHandlers = make(map[uint16]func(ch *Channel))
+		}
+		c.channelHandlers[kind] = h
+	}
+}
+
+// ClientFragmentation with the reassembly limits of the inbound fragments, see Fragmentation.
+// The fragments are reassembled with the default limits of package fragment by default,
+// the messages beyond the limits are dropped.
+func ClientFragmentation(conf fragment.Config) ClientOption {
+	return func(c *Client) {
+		c.fragment = conf
+	}
+}
+
+// ClientMaxDecompressedSize with the max size of the decompressed inbound messages, 4MB by default.
+func ClientMaxDecompressedSize(n int) ClientOption {
+	return func(c *Client) {
+		c.maxDecompressedSize = n
+	}
+}
+
+// ClientStream is a stream opened by a client.
+type ClientStream interface {
+	// Context returns the context of the stream.
+	Context() context.Context
+	// ID returns the stream id.
+	ID() uint32
+	// SendMsg sends v as a message of the request id of the stream.
+	SendMsg(v interface{}) error
+	// RecvMsg receives a message into v, it returns io.EOF once the server finishes the stream,
+	// or the error of the stream method.
+	RecvMsg(v interface{}) error
+	// CloseSend ends the sending of the client.
+	CloseSend() error
+}
+
+// Client is a connection to a Server, it multiplexes the streams of the generated stream clients.
+// The inbound fragments are reassembled and the compressed frames decompressed, see Fragmentation
+// and Compression. The compressors have to be registered, e.g. by importing their packages.
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	packer  packing.Packer
+	codec   encoding.Codec
+	handler func(msg *message.Message)
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	streams map[uint32]*clientStream
+	lastID  uint32
+	err     error // the error ending the inbound reads
+	done    chan struct{}
+	ms      []middleware.Middleware
+
+	channelWindow   int
+	channelHandlers map[uint16]func(ch *Channel)
+	channels        *channelMux
+
+	fragment            fragment.Config
+	reassembler         *fragment.Reassembler // used by readInbound only
+	maxDecompressedSize int
+}
+
+// Dial connects to the server at address and returns a *Client.
+func Dial(network, address string, opts ...ClientOption) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, opts...), nil
+}
+
+// NewClient creates a *Client on conn, it starts reading the inbound messages.
+// The handshakes of the server options, e.g. Encryption, have to be done on conn before,
+// with the packer wrapped by them.
+func NewClient(conn net.Conn, opts ...ClientOption) *Client {
+	c := &Client{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		packer:  packing.NewDefaultPacker(),
+		codec:   proto.New(),
+		streams: make(map[uint32]*clientStream),
+		done:    make(chan struct{}),
+
+		maxDecompressedSize: 4 * MB,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	c.reassembler = fragment.NewReassembler(c.fragment)
+	c.channels = newChannelMux(nil, c.writeMessage, func() encoding.Codec { return c.codec }, c.channelWindow, 0, c.channelHandlers)
+	go c.readInbound()
+	return c
+}
+
+// Send sends v as a message of id.
+func (c *Client) Send(id uint32, v interface{}) error {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(&message.Message{ID: id, Flag: packing.OKType, Data: data})
+}
+
+// SendContext sends v as a message of id through the client middlewares, the kratos client metadata of ctx
+// is sent as the message header.
+func (c *Client) SendContext(ctx context.Context, id uint32, v interface{}) error {
+	_, err := c.invoke(ctx, id, v, func(ctx context.Context, req interface{}, header message.Header) (interface{}, error) {
+		data, err := c.codec.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.writeMessage(&message.Message{ID: id, Flag: packing.OKType, Data: data, Header: header})
+	})
+	return err
+}
+
+// NewStream opens a stream of the method of the request id through the client middlewares,
+// the stream is canceled when ctx is done.
+func (c *Client) NewStream(ctx context.Context, id uint32) (ClientStream, error) {
+	st, err := c.invoke(ctx, id, nil, func(ctx context.Context, _ interface{}, header message.Header) (interface{}, error) {
+		return c.newStream(ctx, id, header)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return st.(ClientStream), nil
+}
+
+// newStream opens a stream whose opening frame carries header.
+func (c *Client) newStream(ctx context.Context, id uint32, header message.Header) (*clientStream, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.lastID++
+	st := &clientStream{
+		client:   c,
+		id:       c.lastID,
+		reqID:    id,
+		recv:     make(chan *message.Message, streamQueueSize),
+		finished: make(chan struct{}),
+		header:   header,
+	}
+	st.ctx, st.cancel = context.WithCancel(ctx)
+	c.streams[st.id] = st
+	c.mu.Unlock()
+
+	go func() {
+		select {
+		case <-st.ctx.Done():
+		case <-st.finished:
+			return
+		}
+		if c.removeStream(st.id) {
+			// canceled before it is finished.
+			if msg, err := streamFrame(nil, st.id, st.reqID, packing.OKType|packing.FlagCancelStream, nil); err == nil {
+				_ = c.writeMessage(msg)
+			}
+		}
+	}()
+	return st, nil
+}
+
+// OpenChannel opens a channel of kind, it returns once the server accepts it or ctx is done.
+func (c *Client) OpenChannel(ctx context.Context, kind uint16) (*Channel, error) {
+	return c.channels.open(ctx, kind)
+}
+
+// AcceptChannel returns the next channel opened by the server without a ClientChannelHandler.
+func (c *Client) AcceptChannel(ctx context.Context) (*Channel, error) {
+	return c.channels.accept(ctx)
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+func (c *Client) writeMessage(msg *message.Message) (err error) {
+	if msg, err = packing.EncodeHeader(msg); err != nil {
+		return err
+	}
+	// the frames are packed in the write order, the packer may number them.
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return packing.PackTo(c.conn, c.packer, msg)
+}
+
+func (c *Client) removeStream(id uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.streams[id]; !ok {
+		return false
+	}
+	delete(c.streams, id)
+	return true
+}
+
+// readInbound dispatches the inbound messages until the connection is closed.
+func (c *Client) readInbound() {
+	defer close(c.done)
+	for {
+		msg, err := c.packer.Unpack(c.reader)
+		if err != nil {
+			c.closeStreams(fmt.Errorf("client read inbound err: %w", err))
+			c.channels.close()
+			return
+		}
+		if msg, err = c.decodeInbound(msg); err != nil {
+			c.closeStreams(fmt.Errorf("client read inbound err: %w", err))
+			c.channels.close()
+			_ = c.conn.Close()
+			return
+		} else if msg == nil {
+			continue
+		}
+		if msg.Flag&packing.FlagChannel != 0 {
+			if err := c.channels.dispatch(msg); err != nil {
+				c.closeStreams(fmt.Errorf("client read inbound err: %w", err))
+				c.channels.close()
+				_ = c.conn.Close()
+				return
+			}
+			continue
+		}
+		if msg.Flag&packing.FlagStream == 0 {
+			if c.handler != nil {
+				c.handler(msg)
+			}
+			msg.Release()
+			continue
+		}
+
+		id, err := parseStreamID(msg)
+		if err != nil {
+			msg.Release()
+			continue
+		}
+		c.mu.Lock()
+		st := c.streams[id]
+		c.mu.Unlock()
+		if st == nil {
+			msg.Release()
+			continue
+		}
+
+		switch {
+		case msg.Flag&packing.FlagCancelStream != 0:
+			msg.Release()
+			c.finishStream(st, ErrStreamCanceled)
+		case msg.Flag&packing.FlagEndStream != 0:
+			err := io.EOF
+			if packing.MessageType(msg.Flag) == packing.ErrType {
+				err = streamError(c.codec, msg)
+			}
+			msg.Release()
+			c.finishStream(st, err)
+		default:
+			select {
+			case st.recv <- msg:
+			case <-st.ctx.Done():
+				msg.Release()
+			default:
+				// the stream is not read in time, it is canceled rather than blocking the other streams.
+				msg.Release()
+				c.finishStream(st, ErrStreamOverflow)
+				if msg, err := streamFrame(nil, st.id, st.reqID, packing.OKType|packing.FlagCancelStream, nil); err == nil {
+					_ = c.writeMessage(msg)
+				}
+			}
+		}
+	}
+}
+
+// decodeInbound reassembles the fragments, decompresses and decodes the header section of the inbound
+// frame msg as the session does. It returns nil if the message is not reassembled yet or it is dropped.
+func (c *Client) decodeInbound(msg *message.Message) (*message.Message, error) {
+	if msg.Flag&packing.FlagFragment != 0 {
+		id := msg.ID
+		full, err := c.reassembler.Add(msg, time.Now())
+		msg.Release()
+		if errors.Is(err, fragment.ErrTooLarge) || errors.Is(err, fragment.ErrTooManyInFlight) || errors.Is(err, fragment.ErrUnknown) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("message %d %s", id, err)
+		}
+		if full == nil {
+			return nil, nil
+		}
+		msg = full
+	}
+	if _, err := compress.DecompressMessage(msg, c.maxDecompressedSize); err != nil {
+		id := msg.ID
+		msg.Release()
+		return nil, fmt.Errorf("message %d %s", id, err)
+	}
+	if err := packing.DecodeHeader(msg); err != nil {
+		msg.Release()
+		return nil, err
+	}
+	return msg, nil
+}
+
+// finishStream ends the inbound frames of st with err.
+func (c *Client) finishStream(st *clientStream, err error) {
+	if !c.removeStream(st.id) {
+		return
+	}
+	st.finish(err)
+}
+
+func (c *Client) closeStreams(err error) {
+	c.mu.Lock()
+	c.err = err
+	streams := c.streams
+	c.streams = make(map[uint32]*clientStream)
+	c.mu.Unlock()
+	for _, st := range streams {
+		st.finish(err)
+	}
+}
+
+// clientStream is a ClientStream.
+type clientStream struct {
+	client   *Client
+	id       uint32
+	reqID    uint32
+	ctx      context.Context
+	cancel   context.CancelFunc
+	recv     chan *message.Message
+	err      error // set before recv is closed
+	finished chan struct{}
+
+	wmu    sync.Mutex     // serializes SendMsg and CloseSend, so the opening frame is written first
+	header message.Header // sent with the opening frame
+}
+
+// finish ends the inbound frames with err, it is called once by the inbound reads.
+func (st *clientStream) finish(err error) {
+	st.err = err
+	close(st.recv)
+	close(st.finished)
+}
+
+var _ ClientStream = (*clientStream)(nil)
+
+func (st *clientStream) Context() context.Context {
+	return st.ctx
+}
+
+func (st *clientStream) ID() uint32 {
+	return st.id
+}
+
+func (st *clientStream) SendMsg(v interface{}) error {
+	if err := st.ctx.Err(); err != nil {
+		return err
+	}
+	msg, err := streamFrame(st.client.codec, st.id, st.reqID, packing.OKType, v)
+	if err != nil {
+		return err
+	}
+	return st.writeMessage(msg)
+}
+
+func (st *clientStream) RecvMsg(v interface{}) error {
+	select {
+	case msg, ok := <-st.recv:
+		if !ok {
+			return st.err
+		}
+		err := st.client.codec.Unmarshal(msg.Data, v)
+		msg.Release()
+		return err
+	case <-st.ctx.Done():
+		return st.ctx.Err()
+	}
+}
+
+func (st *clientStream) CloseSend() error {
+	msg, err := streamFrame(nil, st.id, st.reqID, packing.OKType|packing.FlagEndStream, nil)
+	if err != nil {
+		return err
+	}
+	return st.writeMessage(msg)
+}
+
+// writeMessage writes the frame msg, the opening frame carries the metadata of the stream context.
+func (st *clientStream) writeMessage(msg *message.Message) error {
+	st.wmu.Lock()
+	defer st.wmu.Unlock()
+	msg.Header, st.header = st.header, nil
+	return st.client.writeMessage(msg)
+}
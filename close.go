@@ -0,0 +1,83 @@
+package ktcp
+
+import (
+	"errors"
+
+	"github.com/kwstars/ktcp/integrity"
+)
+
+// CloseReason is the reason why a session is closed.
+type CloseReason int32
+
+const (
+	// CloseNormal is a session closed by the server, e.g. by Session.Close or Server.Stop.
+	CloseNormal CloseReason = iota + 1
+	// CloseError is a session closed by the client, or for a read or protocol error.
+	CloseError
+	// CloseHandshake is a session closed for a failed encryption or integrity handshake.
+	CloseHandshake
+	// CloseRateLimited is a session closed for exceeding the rate limits.
+	CloseRateLimited
+	// CloseChecksum is a session closed for a frame whose integrity trailer mismatches.
+	CloseChecksum
+	// CloseKicked is a session closed by Server.Kick.
+	CloseKicked
+	closeReasonEnd
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNormal:
+		return "normal"
+	case CloseError:
+		return "error"
+	case CloseHandshake:
+		return "handshake"
+	case CloseRateLimited:
+		return "rate_limited"
+	case CloseChecksum:
+		return "checksum"
+	case CloseKicked:
+		return "kicked"
+	default:
+		return "unknown"
+	}
+}
+
+// closeReasonOf returns the close reason of the error ending the inbound reads of sess.
+func closeReasonOf(sess *Session, err error) CloseReason {
+	switch {
+	case errors.Is(err, integrity.ErrMismatch):
+		return CloseChecksum
+	case errors.Is(err, ErrRateLimited):
+		return CloseRateLimited
+	case err == nil || !sess.connected.IsSet():
+		return CloseNormal
+	default:
+		return CloseError
+	}
+}
+
+// CloseReason returns the reason why the session is closed, zero if it is not closed.
+// It is set before Handler.OnClose.
+func (s *Session) CloseReason() CloseReason {
+	return CloseReason(s.closeReason.Get())
+}
+
+// Closed returns the number of the sessions closed for reason.
+func (s *Server) Closed(reason CloseReason) int64 {
+	if reason <= 0 || reason >= closeReasonEnd {
+		return 0
+	}
+	return s.closed[reason].Get()
+}
+
+// setCloseReason records the close reason of sess once.
+func (s *Server) setCloseReason(sess *Session, reason CloseReason) {
+	if sess.closeReason.CompareAndSwap(0, int32(reason)) {
+		s.closed[reason].Add(1)
+		if s.metrics != nil {
+			s.metrics.Closes.With(reason.String()).Inc()
+		}
+	}
+}
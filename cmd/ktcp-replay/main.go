@@ -0,0 +1,98 @@
+// Command ktcp-replay replays the capture files of a ktcp server against a server, see package capture,
+// and diffs its responses with the captured ones:
+//
+//	ktcp-replay -addr 127.0.0.1:9090 -speed 10 ktcp.cap.1 ktcp.cap
+//
+// The inbound frames of each captured session are sent on a new connection at their recorded times,
+// divided by -speed, and the responses are compared with the captured outbound frames of their message id,
+// see package capture/replay for the servers with another packer. The received fragments are reassembled,
+// and the captured messages beyond -chunk-size are sent in fragments for the servers with a max data size
+// below them. It exits with 1 if the responses differ.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kwstars/ktcp/capture/replay"
+	"github.com/kwstars/ktcp/fragment"
+	"github.com/kwstars/ktcp/packing"
+)
+
+func main() {
+	var (
+		network   = flag.String("network", "tcp", "network of the server")
+		addr      = flag.String("addr", "127.0.0.1:9090", "address of the server")
+		speed     = flag.Float64("speed", 1, "replay speed, 1 for the recorded speed, 0 for no delay between the frames")
+		wait      = flag.Duration("wait", time.Second, "wait for the responses until no frame is received for this duration")
+		ignore    = flag.String("ignore", "", "comma separated message ids which are neither sent nor compared")
+		bigEndian = flag.Bool("big-endian", false, "the server packs the frames in big endian")
+		maxSize   = flag.Int("max-data-size", 1<<20, "max data size of the server frames")
+		chunkSize = flag.Int("chunk-size", 0, "fragment the sent messages larger than this size as the server Fragmentation option, 0 for no fragmentation")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: ktcp-replay [flags] capture-file...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ids, err := parseIDs(*ignore)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ktcp-replay: invalid -ignore: %s\n", err)
+		os.Exit(2)
+	}
+	sessions, err := replay.Load(flag.Args(), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ktcp-replay: %s\n", err)
+		os.Exit(2)
+	}
+
+	packer := &packing.DefaultPacker{MaxDataSize: *maxSize}
+	if *bigEndian {
+		packer.ByteOrder = binary.BigEndian
+	}
+	r := replay.New(*network, *addr, packer,
+		replay.Speed(*speed),
+		replay.Wait(*wait),
+		replay.Ignore(ids...),
+		replay.Fragmentation(fragment.Config{ChunkSize: *chunkSize}),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	diffs, err := r.Run(ctx, sessions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ktcp-replay: %s\n", err)
+		os.Exit(2)
+	}
+	if diffs > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseIDs parses the comma separated message ids of s.
+func parseIDs(s string) ([]uint32, error) {
+	var ids []uint32
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIDs(t *testing.T) {
+	ids, err := parseIDs("1, 2,,3")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, ids)
+	_, err = parseIDs("x")
+	assert.Error(t, err)
+}
@@ -9,6 +9,7 @@ import (
 	fmt "fmt"
 	errors "github.com/go-kratos/kratos/v2/errors"
 	ktcp "github.com/kwstars/ktcp"
+	msgtype "github.com/kwstars/ktcp/msgtype"
 	packing "github.com/kwstars/ktcp/packing"
 )
 
@@ -19,6 +20,7 @@ var _ = new(context.Context)
 var _ = new(errors.Error)
 var _ = new(ktcp.Server)
 var _ = new(packing.Packer)
+var _ = new(msgtype.Type)
 
 type handlerFunc func(ctx ktcp.Context, srv UserServiceKTCPServer) error
 
@@ -32,6 +34,15 @@ var handleFunctions = map[uint32]handlerFunc{
 	uint32(ID_ID_CREATE_ROLE_REQUEST): _UserService_CreateRole0_KTCP_Handler,
 }
 
+func init() {
+	msgtype.Register(
+		msgtype.Type{ID: uint32(ID_ID_LOGIN_REQUEST), Direction: msgtype.Request, Service: "ktcp.api.v1.UserService", Method: "Login", MessageType: (*LoginRequest)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_LOGIN_RESPONSE), Direction: msgtype.Response, Service: "ktcp.api.v1.UserService", Method: "Login", MessageType: (*LoginResponse)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_CREATE_ROLE_REQUEST), Direction: msgtype.Request, Service: "ktcp.api.v1.UserService", Method: "CreateRole", MessageType: (*CreateRoleRequest)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_CREATE_ROLE_RESPONSE), Direction: msgtype.Response, Service: "ktcp.api.v1.UserService", Method: "CreateRole", MessageType: (*CreateRoleResponse)(nil).ProtoReflect().Type()},
+	)
+}
+
 func Router(ctx ktcp.Context, srv UserServiceKTCPServer) (err error) {
 	if f, exist := handleFunctions[uint32(ctx.GetReqMsg().ID)]; !exist {
 		return fmt.Errorf("not found handler func for %v", ctx.GetReqMsg().ID)
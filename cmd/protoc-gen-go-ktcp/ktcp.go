@@ -13,6 +13,7 @@ const (
 	transportKTCPPackage = protogen.GoImportPath("github.com/kwstars/ktcp")
 	errorsPackage        = protogen.GoImportPath("github.com/go-kratos/kratos/v2/errors")
 	packingPackage       = protogen.GoImportPath("github.com/kwstars/ktcp/packing")
+	msgtypePackage       = protogen.GoImportPath("github.com/kwstars/ktcp/msgtype")
 	fmtPackage           = protogen.GoImportPath("fmt")
 )
 
@@ -45,6 +46,7 @@ func generateFileContent(gen *protogen.Plugin, file *protogen.File, g *protogen.
 	g.P("var _ = new(", errorsPackage.Ident("Error"), ")")
 	g.P("var _ = new(", transportKTCPPackage.Ident("Server"), ")")
 	g.P("var _ = new(", packingPackage.Ident("Packer"), ")")
+	g.P("var _ = new(", msgtypePackage.Ident("Type"), ")")
 	g.P()
 
 	for _, service := range file.Services {
@@ -64,18 +66,16 @@ func genService(gen *protogen.Plugin, file *protogen.File, g *protogen.Generated
 		Metadata:    file.Desc.Path(),
 	}
 	for _, method := range service.Methods {
-		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
-			continue
-		}
-
 		pReqID := "ID_ID_" + casee.ToUpperCase(string(method.Desc.Name())) + "_REQUEST"
 		pRespID := "ID_ID_" + casee.ToUpperCase(string(method.Desc.Name())) + "_RESPONSE"
 		sd.Methods = append(sd.Methods, &methodDesc{
-			Name:           string(method.Desc.Name()),
-			Request:        g.QualifiedGoIdent(method.Input.GoIdent),
-			Reply:          g.QualifiedGoIdent(method.Output.GoIdent),
-			ProtocolReqID:  pReqID,
-			ProtocolRespID: pRespID,
+			Name:            string(method.Desc.Name()),
+			Request:         g.QualifiedGoIdent(method.Input.GoIdent),
+			Reply:           g.QualifiedGoIdent(method.Output.GoIdent),
+			ProtocolReqID:   pReqID,
+			ProtocolRespID:  pRespID,
+			ClientStreaming: method.Desc.IsStreamingClient(),
+			ServerStreaming: method.Desc.IsStreamingServer(),
 		})
 	}
 
@@ -14,8 +14,14 @@ type handlerFunc func(ctx ktcp.Context, srv {{.ServiceType}}KTCPServer) error
 
 type {{.ServiceType}}KTCPServer interface {
 {{- range .MethodSets}}
+{{- if .ClientStreaming}}
+	{{.Name}}({{$svrType}}_{{.Name}}Server) error
+{{- else if .ServerStreaming}}
+	{{.Name}}(*{{.Request}}, {{$svrType}}_{{.Name}}Server) error
+{{- else}}
 	{{.Name}}(context.Context, *{{.Request}}) (*{{.Reply}}, error)
 {{- end}}
+{{- end}}
 }
 
 var handleFunctions = map[uint32]handlerFunc{
@@ -24,6 +30,15 @@ var handleFunctions = map[uint32]handlerFunc{
 {{- end}}
 }
 
+func init() {
+	msgtype.Register(
+{{- range .Methods}}
+		msgtype.Type{ID: uint32({{.ProtocolReqID}}), Direction: msgtype.Request, Service: "{{$svrName}}", Method: "{{.Name}}", MessageType: (*{{.Request}})(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32({{.ProtocolRespID}}), Direction: msgtype.Response, Service: "{{$svrName}}", Method: "{{.Name}}", MessageType: (*{{.Reply}})(nil).ProtoReflect().Type()},
+{{- end}}
+	)
+}
+
 func Router(ctx ktcp.Context, srv {{.ServiceType}}KTCPServer) (err error) {
 	if f, exist := handleFunctions[uint32(ctx.GetReqMsg().ID)]; !exist {
 		return fmt.Errorf("not found handler func for %v", ctx.GetReqMsg().ID)
@@ -33,6 +48,65 @@ func Router(ctx ktcp.Context, srv {{.ServiceType}}KTCPServer) (err error) {
 }
 
 {{range .Methods}}
+{{- if .Streaming}}
+func _{{$svrType}}_{{.Name}}{{.Num}}_KTCP_Handler(ctx ktcp.Context, srv {{$svrType}}KTCPServer) error {
+	stream := ctx.Stream()
+	if stream == nil {
+		return fmt.Errorf("message %v is not a stream frame", ctx.GetReqMsg().ID)
+	}
+{{- if .ClientStreaming}}
+	h := ctx.Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, srv.{{.Name}}(&{{.StreamImpl}}Server{stream})
+	})
+	_, err := h(ctx, nil)
+	return stream.Finish(err)
+{{- else}}
+	var in {{.Request}}
+	if err := stream.RecvMsg(&in); err != nil {
+		return stream.Finish(err)
+	}
+	h := ctx.Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, srv.{{.Name}}(req.(*{{.Request}}), &{{.StreamImpl}}Server{stream})
+	})
+	_, err := h(ctx, &in)
+	return stream.Finish(err)
+{{- end}}
+}
+
+type {{$svrType}}_{{.Name}}Server interface {
+{{- if .ServerStreaming}}
+	Send(*{{.Reply}}) error
+{{- else}}
+	SendAndClose(*{{.Reply}}) error
+{{- end}}
+{{- if .ClientStreaming}}
+	Recv() (*{{.Request}}, error)
+{{- end}}
+	Context() context.Context
+}
+
+type {{.StreamImpl}}Server struct {
+	ktcp.Stream
+}
+
+{{if .ServerStreaming -}}
+func (x *{{.StreamImpl}}Server) Send(m *{{.Reply}}) error {
+{{- else -}}
+func (x *{{.StreamImpl}}Server) SendAndClose(m *{{.Reply}}) error {
+{{- end}}
+	return x.Stream.SendMsg(uint32({{.ProtocolRespID}}), m)
+}
+{{- if .ClientStreaming}}
+
+func (x *{{.StreamImpl}}Server) Recv() (*{{.Request}}, error) {
+	m := new({{.Request}})
+	if err := x.Stream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+{{- end}}
+{{else}}
 func _{{$svrType}}_{{.Name}}{{.Num}}_KTCP_Handler(ctx ktcp.Context, srv {{$svrType}}KTCPServer) error {
 	var in {{.Request}}
 	if err := ctx.Bind(&in); err != nil {
@@ -56,30 +130,138 @@ func _{{$svrType}}_{{.Name}}{{.Num}}_KTCP_Handler(ctx ktcp.Context, srv {{$svrTy
 	return ctx.Send(uint32({{.ProtocolRespID}}), reply)
 }
 {{end}}
+{{- end}}
+{{- if .StreamMethods}}
+// {{.ServiceType}}KTCPClient is the client of the stream methods of {{.ServiceType}},
+// the other methods are called by sending their request messages with ktcp.Client.Send.
+type {{.ServiceType}}KTCPClient interface {
+{{- range .StreamMethods}}
+{{- if .ClientStreaming}}
+	{{.Name}}(ctx context.Context) ({{$svrType}}_{{.Name}}Client, error)
+{{- else}}
+	{{.Name}}(ctx context.Context, in *{{.Request}}) ({{$svrType}}_{{.Name}}Client, error)
+{{- end}}
+{{- end}}
+}
+
+type {{.ClientImpl}} struct {
+	cc *ktcp.Client
+}
+
+func New{{.ServiceType}}KTCPClient(cc *ktcp.Client) {{.ServiceType}}KTCPClient {
+	return &{{.ClientImpl}}{cc}
+}
+{{range .StreamMethods}}
+{{- if .ClientStreaming}}
+func (c *{{$.ClientImpl}}) {{.Name}}(ctx context.Context) ({{$svrType}}_{{.Name}}Client, error) {
+	stream, err := c.cc.NewStream(ctx, uint32({{.ProtocolReqID}}))
+	if err != nil {
+		return nil, err
+	}
+	return &{{.StreamImpl}}Client{stream}, nil
+}
+{{- else}}
+func (c *{{$.ClientImpl}}) {{.Name}}(ctx context.Context, in *{{.Request}}) ({{$svrType}}_{{.Name}}Client, error) {
+	stream, err := c.cc.NewStream(ctx, uint32({{.ProtocolReqID}}))
+	if err != nil {
+		return nil, err
+	}
+	x := &{{.StreamImpl}}Client{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+{{- end}}
+
+type {{$svrType}}_{{.Name}}Client interface {
+{{- if .ClientStreaming}}
+	Send(*{{.Request}}) error
+{{- end}}
+{{- if .ServerStreaming}}
+	Recv() (*{{.Reply}}, error)
+{{- else}}
+	CloseAndRecv() (*{{.Reply}}, error)
+{{- end}}
+{{- if and .ClientStreaming .ServerStreaming}}
+	CloseSend() error
+{{- end}}
+	Context() context.Context
+}
+
+type {{.StreamImpl}}Client struct {
+	ktcp.ClientStream
+}
+{{- if .ClientStreaming}}
+
+func (x *{{.StreamImpl}}Client) Send(m *{{.Request}}) error {
+	return x.ClientStream.SendMsg(m)
+}
+{{- end}}
+{{- if .ServerStreaming}}
+
+func (x *{{.StreamImpl}}Client) Recv() (*{{.Reply}}, error) {
+	m := new({{.Reply}})
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+{{- else}}
+
+func (x *{{.StreamImpl}}Client) CloseAndRecv() (*{{.Reply}}, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new({{.Reply}})
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+{{- end}}
+{{end}}
+{{- end}}
 
 `
 
 type serviceDesc struct {
-	ServiceType string // Greeter
-	ServiceName string // helloworld.Greeter
-	Metadata    string // api/helloworld/helloworld.proto
-	Methods     []*methodDesc
-	MethodSets  map[string]*methodDesc
+	ServiceType   string // Greeter
+	ServiceName   string // helloworld.Greeter
+	Metadata      string // api/helloworld/helloworld.proto
+	ClientImpl    string // greeterKTCPClient
+	Methods       []*methodDesc
+	MethodSets    map[string]*methodDesc
+	StreamMethods []*methodDesc
 }
 
 type methodDesc struct {
-	Name           string
-	Num            int
-	Request        string
-	Reply          string
-	ProtocolReqID  string
-	ProtocolRespID string
+	Name            string
+	Num             int
+	Request         string
+	Reply           string
+	ProtocolReqID   string
+	ProtocolRespID  string
+	ClientStreaming bool
+	ServerStreaming bool
+	Streaming       bool
+	StreamImpl      string // greeterSayHello
 }
 
 func (s *serviceDesc) execute() string {
 	s.MethodSets = make(map[string]*methodDesc)
+	s.StreamMethods = nil
+	s.ClientImpl = unexport(s.ServiceType) + "KTCPClient"
 	for _, m := range s.Methods {
 		s.MethodSets[m.Name] = m
+		m.Streaming = m.ClientStreaming || m.ServerStreaming
+		m.StreamImpl = unexport(s.ServiceType) + m.Name
+		if m.Streaming {
+			s.StreamMethods = append(s.StreamMethods, m)
+		}
 	}
 	buf := new(bytes.Buffer)
 	tmpl, err := template.New("ktcp").Parse(strings.TrimSpace(ktcpTemplate))
@@ -91,3 +273,7 @@ func (s *serviceDesc) execute() string {
 	}
 	return strings.Trim(buf.String(), "\r\n")
 }
+
+func unexport(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,57 @@
+package ktcp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/message"
+)
+
+// HeaderContentType is the message header selecting the codec of a message by name, e.g. "json".
+// The "application/" prefix is accepted, e.g. "application/json".
+const HeaderContentType = "content-type"
+
+// MessageCodec uses the codec of name for the messages of ids instead of the session codec,
+// it has to be registered, see encoding.GetCodec.
+func MessageCodec(name string, ids ...uint32) ServerOption {
+	return func(s *Server) {
+		if s.messageCodecs == nil {
+			s.messageCodecs = make(map[uint32]string)
+		}
+		for _, id := range ids {
+			s.messageCodecs[id] = name
+		}
+	}
+}
+
+// Codec returns the codec of the session.
+func (s *Session) Codec() encoding.Codec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.codec
+}
+
+// SetCodec sets the codec of the session by name, it has to be registered, see encoding.GetCodec.
+func (s *Session) SetCodec(name string) error {
+	c := encoding.GetCodec(name)
+	if c == nil {
+		return fmt.Errorf("session %s codec %s is not registered", s.id, name)
+	}
+	s.mu.Lock()
+	s.codec = c
+	s.mu.Unlock()
+	return nil
+}
+
+// codecOf returns the codec of the request msg: the codec of its content-type header, the codec of
+// its id set by MessageCodec, or the codec of the session. It returns nil if the codec is not registered.
+func (s *Session) codecOf(msg *message.Message) encoding.Codec {
+	if ct := msg.Header.Get(HeaderContentType); ct != "" {
+		return encoding.GetCodec(strings.ToLower(strings.TrimPrefix(ct, "application/")))
+	}
+	if name, ok := s.messageCodecs[msg.ID]; ok {
+		return encoding.GetCodec(name)
+	}
+	return s.Codec()
+}
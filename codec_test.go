@@ -0,0 +1,58 @@
+package ktcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/encoding/json"
+	"github.com/kwstars/ktcp/encoding/proto"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_Codec(t *testing.T) {
+	h := &echoHandler{connected: make(chan *Session, 1)}
+	_, conn := serveTest(t, h, MessageCodec(json.Name, 5))
+
+	replies := make(chan *message.Message, 1)
+	c := NewClient(conn, ClientHandler(func(msg *message.Message) {
+		replies <- &message.Message{ID: msg.ID, Data: append([]byte(nil), msg.Data...), Header: msg.Header}
+	}))
+	sess := <-h.connected
+
+	jsonCodec := encoding.GetCodec(json.Name)
+	roundTrip := func(codec encoding.Codec, msg *message.Message) *message.Message {
+		t.Helper()
+		data, err := codec.Marshal(&testData.TestModel{Name: "ktcp"})
+		require.NoError(t, err)
+		msg.Flag, msg.Data = packing.OKType, data
+		require.NoError(t, c.writeMessage(msg))
+		select {
+		case reply := <-replies:
+			var out testData.TestModel
+			require.NoError(t, codec.Unmarshal(reply.Data, &out))
+			assert.Equal(t, "ktcp", out.Name)
+			return reply
+		case <-time.After(3 * time.Second):
+			t.Fatal("no reply")
+			return nil
+		}
+	}
+
+	// the session codec.
+	assert.Nil(t, roundTrip(encoding.GetCodec(proto.Name), &message.Message{ID: 1}).Header)
+	// the codec of the content-type header, the reply has the same content-type.
+	reply := roundTrip(jsonCodec, &message.Message{ID: 1, Header: message.Header{HeaderContentType: {"application/json"}}})
+	assert.Equal(t, json.Name, reply.Header.Get(HeaderContentType))
+	// the codec of the message id.
+	roundTrip(jsonCodec, &message.Message{ID: 5})
+
+	assert.Error(t, sess.SetCodec("xml"))
+	require.NoError(t, sess.SetCodec(json.Name))
+	assert.Equal(t, json.Name, sess.Codec().Name())
+	roundTrip(jsonCodec, &message.Message{ID: 1})
+}
@@ -0,0 +1,116 @@
+package ktcp
+
+import (
+	"fmt"
+
+	"github.com/kwstars/ktcp/compress"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// Compression enables the payload compression by the compressors of names in preference order,
+// they have to be registered, e.g. by importing github.com/kwstars/ktcp/compress/gzip.
+//
+// The inbound frames compressed by these compressors are decompressed. The outbound frames of at
+// least threshold bytes are compressed by the compressor negotiated with the client: the one selected
+// by the handshake of Negotiation, the one the client compresses its frames with, or the one set by
+// Session.SetCompressor.
+func Compression(threshold int, names ...string) ServerOption {
+	return func(s *Server) {
+		s.compression = &compression{
+			threshold: threshold,
+			limit:     s.maxDecompressedSize,
+			names:     names,
+		}
+	}
+}
+
+// MaxDecompressedSize with the max size of a decompressed inbound payload, zero means no limit.
+func MaxDecompressedSize(n int) ServerOption {
+	return func(s *Server) {
+		s.maxDecompressedSize = n
+		if s.compression != nil {
+			s.compression.limit = n
+		}
+	}
+}
+
+// compression is the payload compression config of a server.
+type compression struct {
+	threshold int
+	limit     int
+	names     []string
+}
+
+// allowed reports whether the compressor c is enabled.
+func (c *compression) allowed(comp compress.Compressor) bool {
+	for _, name := range c.names {
+		if name == comp.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCompressor sets the compressor of the outbound frames of the session, empty name disables compression.
+func (s *Session) SetCompressor(name string) error {
+	var c compress.Compressor
+	if name != "" {
+		if s.compression == nil {
+			return fmt.Errorf("session %s compression is disabled", s.id)
+		}
+		if c = compress.GetCompressor(name); c == nil || !s.compression.allowed(c) {
+			return fmt.Errorf("session %s compressor %s is not enabled", s.id, name)
+		}
+	}
+	s.mu.Lock()
+	s.compressor = c
+	s.mu.Unlock()
+	return nil
+}
+
+// Compressor returns the name of the compressor of the outbound frames, empty if none.
+func (s *Session) Compressor() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.compressor == nil {
+		return ""
+	}
+	return s.compressor.Name()
+}
+
+// decompressMessage decompresses the inbound message if it is compressed,
+// the client compressor is used for the outbound frames if none is negotiated yet.
+func (s *Session) decompressMessage(msg *message.Message) error {
+	code := packing.CompressorCode(msg.Flag)
+	if code == 0 {
+		return nil
+	}
+	if s.compression == nil {
+		return fmt.Errorf("session %s message %d is compressed but compression is disabled", s.id, msg.ID)
+	}
+	c := compress.GetCompressorByCode(code)
+	if c == nil || !s.compression.allowed(c) {
+		return fmt.Errorf("session %s message %d compressor code %d is not enabled", s.id, msg.ID, code)
+	}
+	if _, err := compress.DecompressMessage(msg, s.compression.limit); err != nil {
+		return fmt.Errorf("session %s message %d %s", s.id, msg.ID, err)
+	}
+
+	s.mu.Lock()
+	if s.compressor == nil {
+		s.compressor = c
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// outboundCompressor returns the compressor of the outbound frames, nil if none.
+func (s *Session) outboundCompressor() compress.Compressor {
+	if s.compression == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.compressor
+}
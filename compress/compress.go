@@ -0,0 +1,139 @@
+// Package compress defines the payload compressors negotiated per message.
+// A compressed payload is marked in the message flag, see packing.FlagCompressed.
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// ErrTooLarge is returned when the decompressed data is beyond the limit.
+var ErrTooLarge = errors.New("compress: decompressed data too large")
+
+// Compressor compresses the message data. Note that implementations of this
+// interface must be thread safe; a Compressor's methods can be called from
+// concurrent goroutines.
+type Compressor interface {
+	// Name returns the name of the Compressor implementation.
+	// The result must be static; the result cannot change between calls.
+	Name() string
+	// Code identifies the Compressor in the message flag, 1 to 7.
+	// The result must be static; the result cannot change between calls.
+	Code() uint8
+	// Compress appends the compressed src to dst and returns the extended buffer.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends the decompressed src to dst and returns the extended buffer.
+	// It returns ErrTooLarge if the decompressed data is beyond limit bytes, zero means no limit.
+	Decompress(dst, src []byte, limit int) ([]byte, error)
+}
+
+var (
+	mu          sync.RWMutex
+	compressors = make(map[string]Compressor)
+	codes       [8]Compressor
+)
+
+// RegisterCompressor registers the provided Compressor for use with all Transport clients and servers.
+func RegisterCompressor(c Compressor) {
+	if c == nil {
+		panic("cannot register a nil Compressor")
+	}
+	if c.Name() == "" {
+		panic("cannot register Compressor with empty string result for Name()")
+	}
+	if c.Code() == 0 || c.Code() > 7 {
+		panic(fmt.Sprintf("cannot register Compressor %s with code %d out of [1, 7]", c.Name(), c.Code()))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if exist := codes[c.Code()]; exist != nil && exist.Name() != strings.ToLower(c.Name()) {
+		panic(fmt.Sprintf("cannot register Compressor %s with the code %d of %s", c.Name(), c.Code(), exist.Name()))
+	}
+	compressors[strings.ToLower(c.Name())] = c
+	codes[c.Code()] = c
+}
+
+// GetCompressor gets a registered Compressor by name, or nil if no Compressor is registered for the name.
+//
+// The name is expected to be lowercase.
+func GetCompressor(name string) Compressor {
+	mu.RLock()
+	defer mu.RUnlock()
+	return compressors[name]
+}
+
+// GetCompressorByCode gets a registered Compressor by code, or nil if no Compressor is registered for the code.
+func GetCompressorByCode(code uint8) Compressor {
+	if code > 7 {
+		return nil
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return codes[code]
+}
+
+// CompressMessage compresses the data of msg with c if it is at least threshold bytes and gets smaller.
+// It returns a new message whose data is appended to dst, or msg itself if it is not compressed.
+func CompressMessage(dst []byte, msg *message.Message, c Compressor, threshold int) (*message.Message, error) {
+	if c == nil || len(msg.Data) < threshold || packing.CompressorCode(msg.Flag) != 0 {
+		return msg, nil
+	}
+	data, err := c.Compress(dst, msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("compress %s err: %s", c.Name(), err)
+	}
+	if len(data) >= len(msg.Data) {
+		return msg, nil
+	}
+	return &message.Message{
+		ID:   msg.ID,
+		Flag: packing.SetCompressor(msg.Flag, c.Code()),
+		Data: data,
+	}, nil
+}
+
+// DecompressMessage decompresses the data of msg in place if it is marked as compressed,
+// and returns the Compressor of the data, or nil if it is not compressed.
+// The decompressed data is at most limit bytes, zero means no limit.
+func DecompressMessage(msg *message.Message, limit int) (Compressor, error) {
+	code := packing.CompressorCode(msg.Flag)
+	if code == 0 {
+		return nil, nil
+	}
+	c := GetCompressorByCode(code)
+	if c == nil {
+		return nil, fmt.Errorf("compressor of code %d is not registered", code)
+	}
+	data, err := c.Decompress(nil, msg.Data, limit)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s err: %w", c.Name(), err)
+	}
+	msg.Data = data
+	msg.Flag = packing.SetCompressor(msg.Flag, 0)
+	return c, nil
+}
+
+// ReadAll appends the data of r to dst until EOF, it returns ErrTooLarge if the data is beyond limit bytes.
+// Zero limit means no limit.
+func ReadAll(dst []byte, r io.Reader, limit int) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if limit <= 0 {
+		_, err := buf.ReadFrom(r)
+		return buf.Bytes(), err
+	}
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return buf.Bytes(), err
+	}
+	if n > int64(limit) {
+		return buf.Bytes(), ErrTooLarge
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,69 @@
+package compress_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/kwstars/ktcp/compress"
+	"github.com/kwstars/ktcp/compress/flate"
+	"github.com/kwstars/ktcp/compress/gzip"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	assert.Equal(t, gzip.Name, compress.GetCompressor(gzip.Name).Name())
+	assert.Equal(t, flate.Name, compress.GetCompressorByCode(flate.Code).Name())
+	assert.Nil(t, compress.GetCompressor("unknown"))
+	assert.Nil(t, compress.GetCompressorByCode(0))
+	assert.Nil(t, compress.GetCompressorByCode(8))
+}
+
+func TestCompressMessage(t *testing.T) {
+	for _, name := range []string{gzip.Name, flate.Name} {
+		t.Run(name, func(t *testing.T) {
+			c := compress.GetCompressor(name)
+			data := bytes.Repeat([]byte("inventory"), 100)
+			msg := &message.Message{ID: 1, Flag: packing.ErrType, Data: data}
+
+			out, err := compress.CompressMessage(nil, msg, c, 64)
+			require.NoError(t, err)
+			assert.Less(t, len(out.Data), len(data))
+			assert.Equal(t, c.Code(), packing.CompressorCode(out.Flag))
+			assert.Equal(t, uint16(packing.ErrType), packing.MessageType(out.Flag))
+
+			got, err := compress.DecompressMessage(out, 0)
+			require.NoError(t, err)
+			assert.Equal(t, name, got.Name())
+			assert.Equal(t, data, out.Data)
+			assert.Equal(t, uint16(packing.ErrType), out.Flag)
+		})
+	}
+}
+
+func TestCompressMessage_Threshold(t *testing.T) {
+	msg := &message.Message{ID: 1, Data: []byte("small")}
+	out, err := compress.CompressMessage(nil, msg, compress.GetCompressor(gzip.Name), 64)
+	require.NoError(t, err)
+	assert.Same(t, msg, out)
+}
+
+func TestDecompressMessage_Limit(t *testing.T) {
+	msg := &message.Message{Data: bytes.Repeat([]byte{'a'}, 1024)}
+	out, err := compress.CompressMessage(nil, msg, compress.GetCompressor(flate.Name), 0)
+	require.NoError(t, err)
+
+	_, err = compress.DecompressMessage(out, 512)
+	assert.True(t, errors.Is(err, compress.ErrTooLarge))
+}
+
+func TestFlag(t *testing.T) {
+	flag := packing.SetCompressor(packing.OKType, 7)
+	assert.NotZero(t, flag&packing.FlagCompressed)
+	assert.Equal(t, uint8(7), packing.CompressorCode(flag))
+	assert.Equal(t, uint16(packing.OKType), packing.MessageType(flag))
+	assert.Equal(t, uint16(packing.OKType), packing.SetCompressor(flag, 0))
+}
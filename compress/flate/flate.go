@@ -0,0 +1,64 @@
+// Package flate defines the deflate compressor. Importing this package will
+// register the compressor.
+package flate
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+
+	"github.com/kwstars/ktcp/compress"
+)
+
+// Name is the name registered for the deflate compressor.
+const Name = "deflate"
+
+// Code is the code of the deflate compressor in the message flag.
+const Code = 1
+
+func init() {
+	compress.RegisterCompressor(compressor{})
+}
+
+var (
+	writers = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	readers = sync.Pool{New: func() interface{} { return flate.NewReader(nil) }}
+)
+
+// compressor is a Compressor implementation with deflate.
+type compressor struct{}
+
+func (compressor) Name() string {
+	return Name
+}
+
+func (compressor) Code() uint8 {
+	return Code
+}
+
+func (compressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := writers.Get().(*flate.Writer)
+	defer writers.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (compressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	r := readers.Get().(io.ReadCloser)
+	defer readers.Put(r)
+	if err := r.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+		return dst, err
+	}
+	return compress.ReadAll(dst, r, limit)
+}
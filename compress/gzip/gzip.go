@@ -0,0 +1,67 @@
+// Package gzip defines the gzip compressor. Importing this package will
+// register the compressor.
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+
+	"github.com/kwstars/ktcp/compress"
+)
+
+// Name is the name registered for the gzip compressor.
+const Name = "gzip"
+
+// Code is the code of the gzip compressor in the message flag.
+const Code = 2
+
+func init() {
+	compress.RegisterCompressor(compressor{})
+}
+
+var (
+	writers = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	readers sync.Pool
+)
+
+// compressor is a Compressor implementation with gzip.
+type compressor struct{}
+
+func (compressor) Name() string {
+	return Name
+}
+
+func (compressor) Code() uint8 {
+	return Code
+}
+
+func (compressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := writers.Get().(*gzip.Writer)
+	defer writers.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (compressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	r, ok := readers.Get().(*gzip.Reader)
+	if ok {
+		if err := r.Reset(bytes.NewReader(src)); err != nil {
+			return dst, err
+		}
+	} else {
+		var err error
+		if r, err = gzip.NewReader(bytes.NewReader(src)); err != nil {
+			return dst, err
+		}
+	}
+	defer readers.Put(r)
+	return compress.ReadAll(dst, r, limit)
+}
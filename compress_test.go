@@ -0,0 +1,74 @@
+package ktcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/compress"
+	"github.com/kwstars/ktcp/compress/gzip"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawEchoHandler replies to every request with the same data and id+1.
+type rawEchoHandler struct{}
+
+func (rawEchoHandler) OnConnect(*Session) {}
+
+func (rawEchoHandler) OnMessage(c Context) {
+	_ = c.Send(c.GetReqMsg().ID+1, &message.Message{Data: c.GetReqMsg().Data})
+}
+
+func (rawEchoHandler) OnClose(*Session) {}
+
+func TestSession_Compression(t *testing.T) {
+	_, conn := serveTest(t, rawEchoHandler{}, withRawCodec, Compression(64, gzip.Name))
+	packer := packing.NewDefaultPacker()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	data := bytes.Repeat([]byte("map state"), 100)
+	req, err := compress.CompressMessage(nil, &message.Message{ID: 10, Data: data}, compress.GetCompressor(gzip.Name), 0)
+	require.NoError(t, err)
+	frame, err := packer.Pack(req)
+	require.NoError(t, err)
+	_, err = conn.Write(frame)
+	require.NoError(t, err)
+
+	resp, err := packer.Unpack(conn)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(11), resp.ID)
+	assert.Equal(t, uint8(gzip.Code), packing.CompressorCode(resp.Flag))
+	_, err = compress.DecompressMessage(resp, 0)
+	require.NoError(t, err)
+	assert.Equal(t, data, resp.Data)
+
+	// below the threshold.
+	frame, err = packer.Pack(&message.Message{ID: 20, Data: []byte("small")})
+	require.NoError(t, err)
+	_, err = conn.Write(frame)
+	require.NoError(t, err)
+	resp, err = packer.Unpack(conn)
+	require.NoError(t, err)
+	assert.Zero(t, packing.CompressorCode(resp.Flag))
+	assert.Equal(t, []byte("small"), resp.Data)
+}
+
+func TestSession_CompressionDisabled(t *testing.T) {
+	_, conn := serveTest(t, rawEchoHandler{}, withRawCodec)
+	packer := packing.NewDefaultPacker()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	req, err := compress.CompressMessage(nil, &message.Message{ID: 10, Data: bytes.Repeat([]byte{'a'}, 256)}, compress.GetCompressor(gzip.Name), 0)
+	require.NoError(t, err)
+	frame, err := packer.Pack(req)
+	require.NoError(t, err)
+	_, err = conn.Write(frame)
+	require.NoError(t, err)
+
+	// the session is closed.
+	_, err = packer.Unpack(conn)
+	assert.Error(t, err)
+}
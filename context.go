@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/kwstars/ktcp/encoding"
 	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/msgtype"
 	"github.com/kwstars/ktcp/packing"
 	"github.com/kwstars/ktcp/storage"
 	"github.com/kwstars/ktcp/sync/errgroup"
@@ -14,8 +16,17 @@ import (
 
 var _ Context = (*routerCtx)(nil)
 
+type contextKey struct{}
+
+// FromContext returns the Context of the message handled with ctx, e.g. in a middleware.
+func FromContext(ctx context.Context) (Context, bool) {
+	c, ok := ctx.Value(contextKey{}).(Context)
+	return c, ok
+}
+
 // Context is a generic context in a message routing.
 // It allows us to pass variables between handler and middlewares.
+// The context and its request message are reused once OnMessage returns.
 type Context interface {
 	context.Context
 	GetSession() *Session
@@ -26,36 +37,52 @@ type Context interface {
 	Send(id uint32, resp interface{}) error
 	SendError(id uint32, resp interface{}) error
 	Middleware(middleware.Handler) middleware.Handler
+	// Stream returns the stream opened by the request message, nil if it is not a stream frame.
+	Stream() Stream
+	// RequestHeader returns the header of the request message, nil if it has none.
+	// It is also the kratos server metadata of the context, see metadata.FromServerContext.
+	RequestHeader() message.Header
+	// ReplyHeader returns the header sent with the response of Send and SendError.
+	ReplyHeader() message.Header
+	// Codec returns the codec of the request message and its response, see HeaderContentType and MessageCodec.
+	Codec() encoding.Codec
 	Reset(sess *Session, reqMsg *message.Message)
 	AppendToStorage(saver storage.Saver)
 	Save() (err error)
 }
 
 type routerCtx struct {
+	ctx     context.Context
 	session *Session
 	storage []storage.Saver
 	reqMsg  *message.Message
 	respMsg *message.Message
+	stream  *serverStream
+	header  message.Header // reply header
+	codec   encoding.Codec
 }
 
 func NewContext() *routerCtx {
-	return &routerCtx{}
+	return &routerCtx{ctx: context.Background()}
 }
 
 func (c *routerCtx) Deadline() (time.Time, bool) {
-	return c.Deadline()
+	return c.ctx.Deadline()
 }
 
 func (c *routerCtx) Done() <-chan struct{} {
-	return c.Done()
+	return c.ctx.Done()
 }
 
 func (c *routerCtx) Err() error {
-	return c.Err()
+	return c.ctx.Err()
 }
 
 func (c *routerCtx) Value(key interface{}) interface{} {
-	return c.Value(key)
+	if _, ok := key.(contextKey); ok {
+		return c
+	}
+	return c.ctx.Value(key)
 }
 
 func (c *routerCtx) Save() (err error) {
@@ -81,27 +108,57 @@ func (c *routerCtx) ForwardHandler(callback CallBack) {
 }
 
 func (c *routerCtx) Reset(sess *Session, reqMsg *message.Message) {
+	c.ctx = context.Background()
 	c.session = sess
 	c.storage = c.storage[:0]
 	c.reqMsg = reqMsg
 	c.respMsg = nil
+	c.stream = nil
+	c.header = nil
+	c.codec = nil
+	if sess != nil {
+		c.codec = sess.Codec()
+	}
 }
 
 func (c *routerCtx) Middleware(h middleware.Handler) middleware.Handler {
-	//TODO middleware
+	if c.session == nil || len(c.session.ms) == 0 {
+		return h
+	}
+	return middleware.Chain(c.session.ms...)(h)
+}
+
+func (c *routerCtx) Stream() Stream {
+	if c.stream == nil {
+		return nil
+	}
+	return c.stream
+}
 
-	return middleware.Chain()(h)
+func (c *routerCtx) RequestHeader() message.Header {
+	return c.reqMsg.Header
+}
+
+func (c *routerCtx) ReplyHeader() message.Header {
+	if c.header == nil {
+		c.header = make(message.Header)
+	}
+	return c.header
 }
 
 func (c *routerCtx) GetSession() *Session {
 	return c.session
 }
 
+func (c *routerCtx) Codec() encoding.Codec {
+	return c.codec
+}
+
 func (c *routerCtx) Bind(v interface{}) error {
-	if c.session.Codec() == nil {
+	if c.codec == nil {
 		return fmt.Errorf("message codec is nil")
 	}
-	return c.session.Codec().Unmarshal(c.reqMsg.Data, v)
+	return c.codec.Unmarshal(c.reqMsg.Data, v)
 }
 
 func (c *routerCtx) Response() *message.Message {
@@ -109,19 +166,19 @@ func (c *routerCtx) Response() *message.Message {
 }
 
 func (c *routerCtx) Send(id uint32, data interface{}) error {
-	codec := c.session.Codec()
-	if codec == nil {
-		return fmt.Errorf("message codec is nil")
+	if err := msgtype.Validate(id, data); err != nil {
+		return err
 	}
-	dataRaw, err := codec.Marshal(data)
+	dataRaw, err := c.marshal(data)
 	if err != nil {
 		return err
 	}
 
 	c.respMsg = &message.Message{
-		ID:   id,
-		Flag: packing.OKType,
-		Data: dataRaw,
+		ID:     id,
+		Flag:   packing.OKType,
+		Data:   dataRaw,
+		Header: c.header,
 	}
 
 	return c.session.Send(c)
@@ -129,24 +186,32 @@ func (c *routerCtx) Send(id uint32, data interface{}) error {
 
 func (c *routerCtx) SendError(id uint32, data interface{}) error {
 
-	codec := c.session.Codec()
-	if codec == nil {
-		return fmt.Errorf("message codec is nil")
-	}
-	dataRaw, err := codec.Marshal(data)
+	dataRaw, err := c.marshal(data)
 	if err != nil {
 		return err
 	}
 
 	c.respMsg = &message.Message{
-		ID:   id,
-		Flag: packing.ErrType,
-		Data: dataRaw,
+		ID:     id,
+		Flag:   packing.ErrType,
+		Data:   dataRaw,
+		Header: c.header,
 	}
 
 	return c.session.Send(c)
 }
 
+// marshal marshals the response data, the response has the content-type of the request if it has one.
+func (c *routerCtx) marshal(data interface{}) ([]byte, error) {
+	if c.codec == nil {
+		return nil, fmt.Errorf("message codec is nil")
+	}
+	if c.reqMsg != nil && c.reqMsg.Header.Get(HeaderContentType) != "" {
+		c.ReplyHeader().Set(HeaderContentType, c.codec.Name())
+	}
+	return c.codec.Marshal(data)
+}
+
 func (c *routerCtx) AppendToStorage(saver storage.Saver) {
 	c.storage = append(c.storage, saver)
 }
@@ -0,0 +1,52 @@
+package ktcp
+
+import (
+	"testing"
+	"time"
+
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const mistypedResponseID = 1000
+
+// sendHandler replies the request message as a response of id.
+type sendHandler struct {
+	id   uint32
+	errs chan error
+}
+
+func (h *sendHandler) OnConnect(*Session) {}
+
+func (h *sendHandler) OnMessage(c Context) {
+	var in testData.TestModel
+	if err := c.Bind(&in); err != nil {
+		h.errs <- err
+		return
+	}
+	h.errs <- c.Send(h.id, &in)
+}
+
+func (h *sendHandler) OnClose(*Session) {}
+
+func TestContext_SendValidatesType(t *testing.T) {
+	msgtype.Register(msgtype.Type{
+		ID:          mistypedResponseID,
+		Direction:   msgtype.Response,
+		MessageType: (*descriptorpb.DescriptorProto)(nil).ProtoReflect().Type(),
+	})
+	h := &sendHandler{id: mistypedResponseID, errs: make(chan error, 1)}
+	_, conn := serveTest(t, h)
+	c := NewClient(conn)
+	require.NoError(t, c.Send(1, &testData.TestModel{Name: "ktcp"}))
+
+	select {
+	case err := <-h.errs:
+		assert.Error(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reply")
+	}
+}
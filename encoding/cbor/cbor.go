@@ -0,0 +1,57 @@
+// Package cbor defines the CBOR codec. Importing this package will register the codec.
+//
+// The package is a separate module, so the CBOR dependency is optional.
+package cbor
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/encoding/protomap"
+	"google.golang.org/protobuf/proto"
+)
+
+// Name is the name registered for the cbor codec.
+const Name = "cbor"
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// codec is a Codec implementation with CBOR.
+// The protobuf messages are encoded as the maps of their fields, see package protomap.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return cbor.Marshal(protomap.ToMap(m))
+	}
+	return cbor.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		rv := reflect.ValueOf(v)
+		for rv := rv; rv.Kind() == reflect.Ptr; {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		m, ok = reflect.Indirect(rv).Interface().(proto.Message)
+	}
+	if !ok {
+		return cbor.Unmarshal(data, v)
+	}
+	var generic interface{}
+	if err := cbor.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	return protomap.FromMap(m, generic)
+}
+
+func (codec) Name() string {
+	return Name
+}
@@ -0,0 +1,49 @@
+package cbor
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type testMessage struct {
+	A string         `cbor:"a"`
+	B []int          `cbor:"b"`
+	C map[string]int `cbor:"c"`
+}
+
+func TestCBOR_MarshalUnmarshal(t *testing.T) {
+	in := &testMessage{A: "a", B: []int{1, 2}, C: map[string]int{"c": 3}}
+	data, err := codec{}.Marshal(in)
+	require.NoError(t, err)
+	var out testMessage
+	require.NoError(t, codec{}.Unmarshal(data, &out))
+	assert.Equal(t, in, &out)
+}
+
+func TestCBOR_Proto(t *testing.T) {
+	in := &testData.TestModel{Id: 1, Name: "go-kratos", Hobby: []string{"1", "2"}, Attrs: map[string]string{"k": "v"}}
+	data, err := codec{}.Marshal(in)
+	require.NoError(t, err)
+
+	// the peers decode a plain map.
+	var m map[string]interface{}
+	require.NoError(t, cbor.Unmarshal(data, &m))
+	assert.Equal(t, "go-kratos", m["name"])
+
+	out := &testData.TestModel{}
+	require.NoError(t, codec{}.Unmarshal(data, out))
+	assert.True(t, proto.Equal(in, out))
+
+	var p *testData.TestModel
+	require.NoError(t, codec{}.Unmarshal(data, &p))
+	assert.True(t, proto.Equal(in, p))
+}
+
+func TestCBOR_Name(t *testing.T) {
+	assert.Equal(t, Name, codec{}.Name())
+}
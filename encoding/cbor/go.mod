@@ -0,0 +1,20 @@
+module github.com/kwstars/ktcp/encoding/cbor
+
+go 1.20
+
+replace github.com/kwstars/ktcp v0.0.1 => ../../
+
+require (
+	github.com/fxamacker/cbor/v2 v2.5.0
+	github.com/kwstars/ktcp v0.0.1
+	github.com/stretchr/testify v1.8.2
+	google.golang.org/protobuf v1.29.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
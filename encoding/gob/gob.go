@@ -0,0 +1,65 @@
+// Package gob defines the gob codec for the links between Go peers. Importing this package will
+// register the codec.
+package gob
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+
+	"github.com/kwstars/ktcp/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+// Name is the name registered for the gob codec.
+const Name = "gob"
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// codec is a Codec implementation with gob.
+// The protobuf messages are encoded as the gob of their protobuf wire format.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		v = data
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		rv := reflect.ValueOf(v)
+		for rv := rv; rv.Kind() == reflect.Ptr; {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		m, ok = reflect.Indirect(rv).Interface().(proto.Message)
+	}
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	if !ok {
+		return dec.Decode(v)
+	}
+	var wire []byte
+	if err := dec.Decode(&wire); err != nil {
+		return err
+	}
+	return proto.Unmarshal(wire, m)
+}
+
+func (codec) Name() string {
+	return Name
+}
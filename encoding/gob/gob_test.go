@@ -0,0 +1,45 @@
+package gob
+
+import (
+	"testing"
+
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type testMessage struct {
+	A string
+	B []int
+	C map[string]int
+}
+
+func TestGob_MarshalUnmarshal(t *testing.T) {
+	in := &testMessage{A: "a", B: []int{1, 2}, C: map[string]int{"c": 3}}
+	data, err := codec{}.Marshal(in)
+	require.NoError(t, err)
+	var out testMessage
+	require.NoError(t, codec{}.Unmarshal(data, &out))
+	assert.Equal(t, in, &out)
+}
+
+func TestGob_Proto(t *testing.T) {
+	in := &testData.TestModel{Id: 1, Name: "go-kratos", Hobby: []string{"1", "2"}, Attrs: map[string]string{"k": "v"}}
+	data, err := codec{}.Marshal(in)
+	require.NoError(t, err)
+
+	out := &testData.TestModel{}
+	require.NoError(t, codec{}.Unmarshal(data, out))
+	assert.True(t, proto.Equal(in, out))
+
+	var p *testData.TestModel
+	require.NoError(t, codec{}.Unmarshal(data, &p))
+	assert.True(t, proto.Equal(in, p))
+
+	assert.Error(t, codec{}.Unmarshal([]byte("invalid"), out))
+}
+
+func TestGob_Name(t *testing.T) {
+	assert.Equal(t, Name, codec{}.Name())
+}
@@ -0,0 +1,57 @@
+// Package msgpack defines the MessagePack codec. Importing this package will register the codec.
+//
+// The package is a separate module, so the MessagePack dependency is optional.
+package msgpack
+
+import (
+	"reflect"
+
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/encoding/protomap"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Name is the name registered for the msgpack codec.
+const Name = "msgpack"
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// codec is a Codec implementation with MessagePack.
+// The protobuf messages are encoded as the maps of their fields, see package protomap.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return msgpack.Marshal(protomap.ToMap(m))
+	}
+	return msgpack.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		rv := reflect.ValueOf(v)
+		for rv := rv; rv.Kind() == reflect.Ptr; {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		m, ok = reflect.Indirect(rv).Interface().(proto.Message)
+	}
+	if !ok {
+		return msgpack.Unmarshal(data, v)
+	}
+	var generic interface{}
+	if err := msgpack.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	return protomap.FromMap(m, generic)
+}
+
+func (codec) Name() string {
+	return Name
+}
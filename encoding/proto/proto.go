@@ -3,15 +3,16 @@
 package proto
 
 import (
+	"github.com/kwstars/ktcp/encoding"
 	"google.golang.org/protobuf/proto"
 )
 
 // Name is the name registered for the proto compressor.
 const Name = "proto"
 
-//func init() {
-//	encoding.RegisterCodec(codec{})
-//}
+func init() {
+	encoding.RegisterCodec(codec{})
+}
 
 // codec is a Codec implementation with protobuf. It is the default codec for Transport.
 type codec struct{}
@@ -0,0 +1,264 @@
+// Package protomap converts the protobuf messages from and to the generic values of the schemaless
+// codecs, e.g. MessagePack and CBOR, so the peers decode them as plain maps.
+//
+// A message is a map of its populated fields by their JSON names, the enums are their numbers,
+// the repeated fields are slices and the map fields are maps.
+package protomap
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ToMap returns the generic value of m.
+func ToMap(m proto.Message) map[string]interface{} {
+	return messageToMap(m.ProtoReflect())
+}
+
+func messageToMap(m protoreflect.Message) map[string]interface{} {
+	out := make(map[string]interface{})
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		out[fd.JSONName()] = fieldToValue(fd, v)
+		return true
+	})
+	return out
+}
+
+func fieldToValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		out := make([]interface{}, list.Len())
+		for i := range out {
+			out[i] = singularToValue(fd, list.Get(i))
+		}
+		return out
+	case fd.IsMap():
+		m := v.Map()
+		if fd.MapKey().Kind() == protoreflect.StringKind {
+			out := make(map[string]interface{}, m.Len())
+			m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				out[k.String()] = singularToValue(fd.MapValue(), v)
+				return true
+			})
+			return out
+		}
+		out := make(map[interface{}]interface{}, m.Len())
+		m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			out[k.Interface()] = singularToValue(fd.MapValue(), v)
+			return true
+		})
+		return out
+	default:
+		return singularToValue(fd, v)
+	}
+}
+
+func singularToValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageToMap(v.Message())
+	case protoreflect.EnumKind:
+		return int32(v.Enum())
+	default:
+		return v.Interface()
+	}
+}
+
+// FromMap sets the fields of m from the generic value v decoded by a schemaless codec,
+// the unknown fields are discarded.
+func FromMap(m proto.Message, v interface{}) error {
+	proto.Reset(m)
+	return mapToMessage(m.ProtoReflect(), v)
+}
+
+func mapToMessage(m protoreflect.Message, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map {
+		return fmt.Errorf("protomap: %s is %T, want a map", m.Descriptor().FullName(), v)
+	}
+	fields := m.Descriptor().Fields()
+	iter := rv.MapRange()
+	for iter.Next() {
+		name, ok := iter.Key().Interface().(string)
+		if !ok {
+			continue
+		}
+		fd := fields.ByJSONName(name)
+		if fd == nil {
+			fd = fields.ByTextName(name)
+		}
+		if fd == nil {
+			continue
+		}
+		if err := setField(m, fd, iter.Value().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case fd.IsList():
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fmt.Errorf("protomap: field %s is %T, want a list", fd.FullName(), v)
+		}
+		list := m.Mutable(fd).List()
+		for i := 0; i < rv.Len(); i++ {
+			e, err := valueOf(fd, list.NewElement, rv.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+			list.Append(e)
+		}
+	case fd.IsMap():
+		if rv.Kind() != reflect.Map {
+			return fmt.Errorf("protomap: field %s is %T, want a map", fd.FullName(), v)
+		}
+		mp := m.Mutable(fd).Map()
+		iter := rv.MapRange()
+		for iter.Next() {
+			k, err := scalarOf(fd.MapKey(), iter.Key().Interface())
+			if err != nil {
+				return err
+			}
+			e, err := valueOf(fd.MapValue(), mp.NewValue, iter.Value().Interface())
+			if err != nil {
+				return err
+			}
+			mp.Set(k.MapKey(), e)
+		}
+	default:
+		e, err := valueOf(fd, func() protoreflect.Value { return m.NewField(fd) }, v)
+		if err != nil {
+			return err
+		}
+		m.Set(fd, e)
+	}
+	return nil
+}
+
+// valueOf converts v to a singular value of fd, newValue returns an empty message value.
+func valueOf(fd protoreflect.FieldDescriptor, newValue func() protoreflect.Value, v interface{}) (protoreflect.Value, error) {
+	if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		e := newValue()
+		return e, mapToMessage(e.Message(), v)
+	}
+	return scalarOf(fd, v)
+}
+
+func scalarOf(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if b, ok := v.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.StringKind:
+		switch s := v.(type) {
+		case string:
+			return protoreflect.ValueOfString(s), nil
+		case []byte:
+			return protoreflect.ValueOfString(string(s)), nil
+		}
+	case protoreflect.BytesKind:
+		switch b := v.(type) {
+		case []byte:
+			return protoreflect.ValueOfBytes(b), nil
+		case string:
+			return protoreflect.ValueOfBytes([]byte(b)), nil
+		}
+	case protoreflect.EnumKind:
+		if s, ok := v.(string); ok {
+			if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+				return protoreflect.ValueOfEnum(ev.Number()), nil
+			}
+			break
+		}
+		if n, ok := toInt(rv, math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if n, ok := toInt(rv, math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfInt32(int32(n)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if n, ok := toInt(rv, math.MinInt64, math.MaxInt64); ok {
+			return protoreflect.ValueOfInt64(n), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if n, ok := toUint(rv, math.MaxUint32); ok {
+			return protoreflect.ValueOfUint32(uint32(n)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if n, ok := toUint(rv, math.MaxUint64); ok {
+			return protoreflect.ValueOfUint64(n), nil
+		}
+	case protoreflect.FloatKind:
+		if f, ok := toFloat(rv); ok {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+	case protoreflect.DoubleKind:
+		if f, ok := toFloat(rv); ok {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("protomap: field %s is %T, want %s", fd.FullName(), v, fd.Kind())
+}
+
+func toInt(rv reflect.Value, min, max int64) (int64, bool) {
+	var n int64
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		n = int64(rv.Uint())
+	default:
+		return 0, false
+	}
+	return n, n >= min && n <= max
+}
+
+func toUint(rv reflect.Value, max uint64) (uint64, bool) {
+	var n uint64
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() < 0 {
+			return 0, false
+		}
+		n = uint64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = rv.Uint()
+	default:
+		return 0, false
+	}
+	return n, n <= max
+}
+
+func toFloat(rv reflect.Value) (float64, bool) {
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	default:
+		return 0, false
+	}
+}
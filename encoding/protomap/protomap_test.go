@@ -0,0 +1,55 @@
+package protomap
+
+import (
+	"testing"
+
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestToMapFromMap(t *testing.T) {
+	for _, in := range []proto.Message{
+		&testData.TestModel{Id: 1, Name: "ktcp", Hobby: []string{"a", "b"}, Attrs: map[string]string{"k": "v"}},
+		&descriptorpb.DescriptorProto{
+			Name: proto.String("M"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:   proto.String("id"),
+				Number: proto.Int32(1),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+				Type:   descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+			}},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		},
+	} {
+		m := ToMap(in)
+		out := in.ProtoReflect().New().Interface()
+		require.NoError(t, FromMap(out, m))
+		assert.True(t, proto.Equal(in, out), "%v != %v", in, out)
+	}
+}
+
+func TestFromMap_GenericValues(t *testing.T) {
+	// the values as decoded by a schemaless codec.
+	var out descriptorpb.DescriptorProto
+	require.NoError(t, FromMap(&out, map[interface{}]interface{}{
+		"name": []byte("M"),
+		"field": []interface{}{
+			map[string]interface{}{"name": "id", "number": uint8(1), "label": "LABEL_OPTIONAL", "type": int64(3)},
+		},
+		"reservedName": []interface{}{"x"},
+		"unknown":      1,
+	}))
+	assert.Equal(t, "M", out.GetName())
+	assert.Equal(t, int32(1), out.Field[0].GetNumber())
+	assert.Equal(t, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, out.Field[0].GetLabel())
+	assert.Equal(t, descriptorpb.FieldDescriptorProto_TYPE_INT64, out.Field[0].GetType())
+	assert.Equal(t, []string{"x"}, out.ReservedName)
+
+	assert.Error(t, FromMap(&out, "x"))
+	assert.Error(t, FromMap(&out, map[string]interface{}{"name": 1}))
+	assert.Error(t, FromMap(&testData.TestModel{}, map[string]interface{}{"id": 1.5}))
+	assert.Error(t, FromMap(&descriptorpb.FieldDescriptorProto{}, map[string]interface{}{"number": int64(1) << 40}))
+}
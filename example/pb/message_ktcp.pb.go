@@ -6,28 +6,49 @@ package pb
 
 import (
 	context "context"
-	"fmt"
-
+	fmt "fmt"
 	errors "github.com/go-kratos/kratos/v2/errors"
 	ktcp "github.com/kwstars/ktcp"
+	msgtype "github.com/kwstars/ktcp/msgtype"
 	packing "github.com/kwstars/ktcp/packing"
 )
 
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the kratos package it is being compiled against.
+var _ = new(fmt.Stringer)
 var _ = new(context.Context)
 var _ = new(errors.Error)
 var _ = new(ktcp.Server)
 var _ = new(packing.Packer)
+var _ = new(msgtype.Type)
 
 type handlerFunc func(ctx ktcp.Context, srv UserServiceKTCPServer) error
 
 type UserServiceKTCPServer interface {
 	CreateRole(context.Context, *CreateRoleRequest) (*CreateRoleResponse, error)
 	Login(context.Context, *LoginRequest) (*LoginResponse, error)
+	Subscribe(*SubscribeRequest, UserService_SubscribeServer) error
+	UploadReplay(UserService_UploadReplayServer) error
 }
 
 var handleFunctions = map[uint32]handlerFunc{
-	uint32(ID_ID_LOGIN_REQUEST):       _UserService_Login0_KTCP_Handler,
-	uint32(ID_ID_CREATE_ROLE_REQUEST): _UserService_CreateRole0_KTCP_Handler,
+	uint32(ID_ID_LOGIN_REQUEST):         _UserService_Login0_KTCP_Handler,
+	uint32(ID_ID_CREATE_ROLE_REQUEST):   _UserService_CreateRole0_KTCP_Handler,
+	uint32(ID_ID_SUBSCRIBE_REQUEST):     _UserService_Subscribe0_KTCP_Handler,
+	uint32(ID_ID_UPLOAD_REPLAY_REQUEST): _UserService_UploadReplay0_KTCP_Handler,
+}
+
+func init() {
+	msgtype.Register(
+		msgtype.Type{ID: uint32(ID_ID_LOGIN_REQUEST), Direction: msgtype.Request, Service: "pb.UserService", Method: "Login", MessageType: (*LoginRequest)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_LOGIN_RESPONSE), Direction: msgtype.Response, Service: "pb.UserService", Method: "Login", MessageType: (*LoginResponse)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_CREATE_ROLE_REQUEST), Direction: msgtype.Request, Service: "pb.UserService", Method: "CreateRole", MessageType: (*CreateRoleRequest)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_CREATE_ROLE_RESPONSE), Direction: msgtype.Response, Service: "pb.UserService", Method: "CreateRole", MessageType: (*CreateRoleResponse)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_SUBSCRIBE_REQUEST), Direction: msgtype.Request, Service: "pb.UserService", Method: "Subscribe", MessageType: (*SubscribeRequest)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_SUBSCRIBE_RESPONSE), Direction: msgtype.Response, Service: "pb.UserService", Method: "Subscribe", MessageType: (*SubscribeResponse)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_UPLOAD_REPLAY_REQUEST), Direction: msgtype.Request, Service: "pb.UserService", Method: "UploadReplay", MessageType: (*UploadReplayRequest)(nil).ProtoReflect().Type()},
+		msgtype.Type{ID: uint32(ID_ID_UPLOAD_REPLAY_RESPONSE), Direction: msgtype.Response, Service: "pb.UserService", Method: "UploadReplay", MessageType: (*UploadReplayResponse)(nil).ProtoReflect().Type()},
+	)
 }
 
 func Router(ctx ktcp.Context, srv UserServiceKTCPServer) (err error) {
@@ -53,7 +74,7 @@ func _UserService_Login0_KTCP_Handler(ctx ktcp.Context, srv UserServiceKTCPServe
 	}
 	if SaveErr := ctx.Save(); SaveErr != nil {
 		if SendErr := ctx.SendError(uint32(ID_ID_LOGIN_RESPONSE), errors.InternalServer("database", "数据库错误")); err != nil {
-			return fmt.Errorf("Save: %v, SendError: %v", SaveErr, SendErr)
+			return fmt.Errorf("SaveErr: %v, SendErr: %v", SaveErr, SendErr)
 		}
 		return fmt.Errorf("%v", SaveErr)
 	}
@@ -74,6 +95,155 @@ func _UserService_CreateRole0_KTCP_Handler(ctx ktcp.Context, srv UserServiceKTCP
 		se := errors.FromError(err)
 		return ctx.SendError(uint32(ID_ID_CREATE_ROLE_RESPONSE), se)
 	}
+	if SaveErr := ctx.Save(); SaveErr != nil {
+		if SendErr := ctx.SendError(uint32(ID_ID_CREATE_ROLE_RESPONSE), errors.InternalServer("database", "数据库错误")); err != nil {
+			return fmt.Errorf("SaveErr: %v, SendErr: %v", SaveErr, SendErr)
+		}
+		return fmt.Errorf("%v", SaveErr)
+	}
 	reply := out.(*CreateRoleResponse)
 	return ctx.Send(uint32(ID_ID_CREATE_ROLE_RESPONSE), reply)
 }
+
+func _UserService_Subscribe0_KTCP_Handler(ctx ktcp.Context, srv UserServiceKTCPServer) error {
+	stream := ctx.Stream()
+	if stream == nil {
+		return fmt.Errorf("message %v is not a stream frame", ctx.GetReqMsg().ID)
+	}
+	var in SubscribeRequest
+	if err := stream.RecvMsg(&in); err != nil {
+		return stream.Finish(err)
+	}
+	h := ctx.Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, srv.Subscribe(req.(*SubscribeRequest), &userServiceSubscribeServer{stream})
+	})
+	_, err := h(ctx, &in)
+	return stream.Finish(err)
+}
+
+type UserService_SubscribeServer interface {
+	Send(*SubscribeResponse) error
+	Context() context.Context
+}
+
+type userServiceSubscribeServer struct {
+	ktcp.Stream
+}
+
+func (x *userServiceSubscribeServer) Send(m *SubscribeResponse) error {
+	return x.Stream.SendMsg(uint32(ID_ID_SUBSCRIBE_RESPONSE), m)
+}
+
+func _UserService_UploadReplay0_KTCP_Handler(ctx ktcp.Context, srv UserServiceKTCPServer) error {
+	stream := ctx.Stream()
+	if stream == nil {
+		return fmt.Errorf("message %v is not a stream frame", ctx.GetReqMsg().ID)
+	}
+	h := ctx.Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, srv.UploadReplay(&userServiceUploadReplayServer{stream})
+	})
+	_, err := h(ctx, nil)
+	return stream.Finish(err)
+}
+
+type UserService_UploadReplayServer interface {
+	SendAndClose(*UploadReplayResponse) error
+	Recv() (*UploadReplayRequest, error)
+	Context() context.Context
+}
+
+type userServiceUploadReplayServer struct {
+	ktcp.Stream
+}
+
+func (x *userServiceUploadReplayServer) SendAndClose(m *UploadReplayResponse) error {
+	return x.Stream.SendMsg(uint32(ID_ID_UPLOAD_REPLAY_RESPONSE), m)
+}
+
+func (x *userServiceUploadReplayServer) Recv() (*UploadReplayRequest, error) {
+	m := new(UploadReplayRequest)
+	if err := x.Stream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// UserServiceKTCPClient is the client of the stream methods of UserService,
+// the other methods are called by sending their request messages with ktcp.Client.Send.
+type UserServiceKTCPClient interface {
+	Subscribe(ctx context.Context, in *SubscribeRequest) (UserService_SubscribeClient, error)
+	UploadReplay(ctx context.Context) (UserService_UploadReplayClient, error)
+}
+
+type userServiceKTCPClient struct {
+	cc *ktcp.Client
+}
+
+func NewUserServiceKTCPClient(cc *ktcp.Client) UserServiceKTCPClient {
+	return &userServiceKTCPClient{cc}
+}
+
+func (c *userServiceKTCPClient) Subscribe(ctx context.Context, in *SubscribeRequest) (UserService_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, uint32(ID_ID_SUBSCRIBE_REQUEST))
+	if err != nil {
+		return nil, err
+	}
+	x := &userServiceSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserService_SubscribeClient interface {
+	Recv() (*SubscribeResponse, error)
+	Context() context.Context
+}
+
+type userServiceSubscribeClient struct {
+	ktcp.ClientStream
+}
+
+func (x *userServiceSubscribeClient) Recv() (*SubscribeResponse, error) {
+	m := new(SubscribeResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userServiceKTCPClient) UploadReplay(ctx context.Context) (UserService_UploadReplayClient, error) {
+	stream, err := c.cc.NewStream(ctx, uint32(ID_ID_UPLOAD_REPLAY_REQUEST))
+	if err != nil {
+		return nil, err
+	}
+	return &userServiceUploadReplayClient{stream}, nil
+}
+
+type UserService_UploadReplayClient interface {
+	Send(*UploadReplayRequest) error
+	CloseAndRecv() (*UploadReplayResponse, error)
+	Context() context.Context
+}
+
+type userServiceUploadReplayClient struct {
+	ktcp.ClientStream
+}
+
+func (x *userServiceUploadReplayClient) Send(m *UploadReplayRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *userServiceUploadReplayClient) CloseAndRecv() (*UploadReplayResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadReplayResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -3,12 +3,17 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 
 	"github.com/kwstars/ktcp/sync/atomic"
 
+	"github.com/go-kratos/kratos/v2/log"
 	"github.com/go-kratos/kratos/v2/middleware/recovery"
 	"github.com/kwstars/ktcp"
+	"github.com/kwstars/ktcp/admin"
 	"github.com/kwstars/ktcp/example/pb"
+	"github.com/kwstars/ktcp/middleware/logging"
 )
 
 type UserService struct {
@@ -39,6 +44,29 @@ func (s *UserService) Login(ctx context.Context, request *pb.LoginRequest) (*pb.
 	//return nil, pb.ErrorUserNotFound("not found user %v", "123123123")
 }
 
+func (s *UserService) Subscribe(request *pb.SubscribeRequest, stream pb.UserService_SubscribeServer) error {
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&pb.SubscribeResponse{Topic: request.Topic, Data: []byte(fmt.Sprint(i))}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UserService) UploadReplay(stream pb.UserService_UploadReplayServer) error {
+	var size int
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.UploadReplayResponse{Size: uint32(size)})
+		}
+		if err != nil {
+			return err
+		}
+		size += len(req.Chunk)
+	}
+}
+
 func (s *UserService) OnClose(c *ktcp.Session) {
 }
 
@@ -90,11 +118,19 @@ func main() {
 	opts := []ktcp.ServerOption{
 		ktcp.Middleware(
 			recovery.Recovery(),
+			logging.Server(log.DefaultLogger, logging.Redact("password")),
 		),
 	}
 	s := ktcp.NewServer(gate, opts...)
 	gate.Server = s
 
+	// inspect and control the server at http://127.0.0.1:6060/sessions
+	go func() {
+		if err := http.ListenAndServe("127.0.0.1:6060", admin.Handler(s)); err != nil {
+			fmt.Printf("admin server error: %v\n", err)
+		}
+	}()
+
 	// start the server
 	_ = s.Serve()
 }
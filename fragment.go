@@ -0,0 +1,50 @@
+package ktcp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kwstars/ktcp/fragment"
+	"github.com/kwstars/ktcp/message"
+)
+
+// Fragmentation splits the outbound messages larger than conf.ChunkSize into fragments and
+// reassembles the inbound fragments, see package fragment. The zero reassembly limits of conf
+// are the default ones, ServeListener fails for an invalid conf.
+// The fragments are written one by one, so the other messages of the session are not blocked
+// by a large transfer.
+//
+// The messages beyond the reassembly limits or timed out are dropped,
+// the session is closed for the malformed or out of order fragments.
+func Fragmentation(conf fragment.Config) ServerOption {
+	return func(s *Server) {
+		s.fragment = &conf
+	}
+}
+
+// writeFragments writes the fragments of msg.
+func (s *Session) writeFragments(msg *message.Message) error {
+	id := uint32(s.fragmentSeq.Add(1))
+	return fragment.Split(msg, id, s.fragment.ChunkSize, s.writeFrame)
+}
+
+// reassemble adds the inbound fragment msg, it returns the reassembled message after the last fragment.
+// It returns nil if the message is not reassembled yet or it is dropped for the limits.
+func (s *Session) reassemble(msg *message.Message) (*message.Message, error) {
+	if s.reassembler == nil {
+		msg.Release()
+		return nil, fmt.Errorf("session %s message %d is fragmented but fragmentation is disabled", s.id, msg.ID)
+	}
+	id := msg.ID
+	full, err := s.reassembler.Add(msg, time.Now())
+	msg.Release()
+	if errors.Is(err, fragment.ErrTooLarge) || errors.Is(err, fragment.ErrTooManyInFlight) || errors.Is(err, fragment.ErrUnknown) {
+		s.log.Warnf("session %s drop message %d: %s", s.id, id, err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("session %s message %d %s", s.id, id, err)
+	}
+	return full, nil
+}
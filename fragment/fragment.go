@@ -0,0 +1,212 @@
+// Package fragment splits the large messages into fragments and reassembles them.
+//
+// A fragment is a message of the same id whose flag is marked with packing.FlagFragment,
+// its data starts with the fragment header:
+//
+//	id(4)|index(2)|count(2)|chunk(n)
+//
+// The id identifies the fragmented message among the ones in flight of a connection,
+// the fragments of a message are sent in order.
+package fragment
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// HeaderSize is the size of the fragment header.
+const HeaderSize = 4 + 2 + 2
+
+// MaxCount is the max number of fragments of a message.
+const MaxCount = 1<<16 - 1
+
+var (
+	// ErrTooLarge is returned when a reassembled message is beyond Config.MaxSize.
+	ErrTooLarge = errors.New("fragment: message too large")
+	// ErrTooManyInFlight is returned when a message starts beyond Config.MaxInFlight.
+	ErrTooManyInFlight = errors.New("fragment: too many messages in flight")
+	// ErrUnknown is returned for the fragments of a message which is dropped or not started.
+	ErrUnknown = errors.New("fragment: unknown message")
+)
+
+// The reassembly limits used for the zero values of Config.
+const (
+	DefaultMaxSize     = 16 << 20
+	DefaultMaxInFlight = 16
+	DefaultTimeout     = 30 * time.Second
+)
+
+// Config is the fragmentation config.
+type Config struct {
+	// ChunkSize is the max size of a chunk, the larger messages are fragmented.
+	// ChunkSize + HeaderSize must be within the max data size of the packer.
+	ChunkSize int
+	// MaxSize is the max size of a reassembled message, DefaultMaxSize if zero.
+	MaxSize int
+	// MaxInFlight is the max number of the messages being reassembled, DefaultMaxInFlight if zero.
+	MaxInFlight int
+	// Timeout drops the messages not reassembled in time, DefaultTimeout if zero.
+	Timeout time.Duration
+}
+
+// Validate returns an error if the chunk size or a limit of c is invalid.
+func (c *Config) Validate() error {
+	if c.ChunkSize <= 0 {
+		return fmt.Errorf("fragment: invalid chunk size %d", c.ChunkSize)
+	}
+	if c.MaxSize < 0 || c.MaxInFlight < 0 || c.Timeout < 0 {
+		return errors.New("fragment: negative reassembly limit")
+	}
+	return nil
+}
+
+// complete returns c with the default limits for its zero ones.
+func (c Config) complete() Config {
+	if c.MaxSize == 0 {
+		c.MaxSize = DefaultMaxSize
+	}
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = DefaultMaxInFlight
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+	return c
+}
+
+// Header is the fragment header.
+type Header struct {
+	ID    uint32
+	Index uint16
+	Count uint16
+}
+
+// Split calls fn with the fragments of msg whose data are at most chunkSize bytes, id identifies msg.
+// The fragment data are reused once fn returns.
+func Split(msg *message.Message, id uint32, chunkSize int, fn func(*message.Message) error) error {
+	if chunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	count := (len(msg.Data) + chunkSize - 1) / chunkSize
+	if count > MaxCount {
+		return fmt.Errorf("the dataSize %d is beyond %d fragments", len(msg.Data), MaxCount)
+	}
+
+	buf := packing.GetBuffer()
+	defer packing.PutBuffer(buf)
+	for i := 0; i < count; i++ {
+		chunk := msg.Data[i*chunkSize:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		data := AppendHeader((*buf)[:0], Header{ID: id, Index: uint16(i), Count: uint16(count)})
+		data = append(data, chunk...)
+		*buf = data
+		if err := fn(&message.Message{ID: msg.ID, Flag: msg.Flag | packing.FlagFragment, Data: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendHeader appends the fragment header h to dst.
+func AppendHeader(dst []byte, h Header) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, h.ID)
+	dst = binary.BigEndian.AppendUint16(dst, h.Index)
+	return binary.BigEndian.AppendUint16(dst, h.Count)
+}
+
+// ParseHeader parses the fragment header of data and returns the chunk.
+func ParseHeader(data []byte) (Header, []byte, error) {
+	if len(data) < HeaderSize {
+		return Header{}, nil, fmt.Errorf("the fragment size %d is less than the header", len(data))
+	}
+	h := Header{
+		ID:    binary.BigEndian.Uint32(data),
+		Index: binary.BigEndian.Uint16(data[4:]),
+		Count: binary.BigEndian.Uint16(data[6:]),
+	}
+	if h.Count == 0 || h.Index >= h.Count {
+		return Header{}, nil, fmt.Errorf("invalid fragment %d of %d", h.Index, h.Count)
+	}
+	return h, data[HeaderSize:], nil
+}
+
+// partial is a message being reassembled.
+type partial struct {
+	id    uint32
+	flag  uint16
+	next  uint16
+	count uint16
+	data  []byte
+	start time.Time
+}
+
+// Reassembler reassembles the fragmented messages of a connection, it is not thread safe.
+type Reassembler struct {
+	conf     Config
+	partials map[uint32]*partial
+}
+
+// NewReassembler creates a *Reassembler, the zero limits of conf are the default ones.
+func NewReassembler(conf Config) *Reassembler {
+	return &Reassembler{conf: conf.complete(), partials: make(map[uint32]*partial)}
+}
+
+// InFlight returns the number of the messages being reassembled.
+func (r *Reassembler) InFlight() int {
+	return len(r.partials)
+}
+
+// Add adds the fragment msg received at now, it returns the reassembled message after the last fragment,
+// nil otherwise. The message being reassembled is dropped when an error is returned.
+// msg can be released once Add returns.
+func (r *Reassembler) Add(msg *message.Message, now time.Time) (*message.Message, error) {
+	h, chunk, err := ParseHeader(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+	r.expire(now)
+
+	p := r.partials[h.ID]
+	if p == nil {
+		if h.Index != 0 {
+			return nil, fmt.Errorf("%w: fragment %d of %d of message %d", ErrUnknown, h.Index, h.Count, h.ID)
+		}
+		if len(r.partials) >= r.conf.MaxInFlight {
+			return nil, fmt.Errorf("%w: %d", ErrTooManyInFlight, len(r.partials))
+		}
+		p = &partial{id: msg.ID, flag: msg.Flag &^ packing.FlagFragment, count: h.Count, start: now}
+		r.partials[h.ID] = p
+	}
+	if h.Index != p.next || h.Count != p.count || msg.ID != p.id {
+		delete(r.partials, h.ID)
+		return nil, fmt.Errorf("unexpected fragment %d of message %d, expected %d", h.Index, h.ID, p.next)
+	}
+	if len(p.data)+len(chunk) > r.conf.MaxSize {
+		delete(r.partials, h.ID)
+		return nil, fmt.Errorf("%w: beyond the max %d", ErrTooLarge, r.conf.MaxSize)
+	}
+	p.data = append(p.data, chunk...)
+	p.next++
+	if p.next < p.count {
+		return nil, nil
+	}
+
+	delete(r.partials, h.ID)
+	return &message.Message{ID: p.id, Flag: p.flag, Data: p.data}, nil
+}
+
+// expire drops the messages not reassembled in time.
+func (r *Reassembler) expire(now time.Time) {
+	for id, p := range r.partials {
+		if now.Sub(p.start) > r.conf.Timeout {
+			delete(r.partials, id)
+		}
+	}
+}
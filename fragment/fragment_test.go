@@ -0,0 +1,130 @@
+package fragment
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// split returns the copies of the fragments of msg.
+func split(t *testing.T, msg *message.Message, id uint32, chunkSize int) []*message.Message {
+	t.Helper()
+	var frags []*message.Message
+	require.NoError(t, Split(msg, id, chunkSize, func(m *message.Message) error {
+		frags = append(frags, &message.Message{ID: m.ID, Flag: m.Flag, Data: append([]byte{}, m.Data...)})
+		return nil
+	}))
+	return frags
+}
+
+func TestSplit(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	frags := split(t, &message.Message{ID: 1, Flag: packing.OKType, Data: data}, 7, 30)
+	require.Len(t, frags, 4)
+	for i, f := range frags {
+		assert.Equal(t, uint32(1), f.ID)
+		assert.Equal(t, packing.OKType|packing.FlagFragment, f.Flag)
+		h, _, err := ParseHeader(f.Data)
+		require.NoError(t, err)
+		assert.Equal(t, Header{ID: 7, Index: uint16(i), Count: 4}, h)
+	}
+	assert.Len(t, frags[3].Data, HeaderSize+10)
+}
+
+func TestReassembler(t *testing.T) {
+	a := bytes.Repeat([]byte{'a'}, 100)
+	b := bytes.Repeat([]byte{'b'}, 50)
+	fa := split(t, &message.Message{ID: 1, Flag: packing.ErrType, Data: a}, 1, 30)
+	fb := split(t, &message.Message{ID: 2, Data: b}, 2, 30)
+
+	r := NewReassembler(Config{})
+	now := time.Now()
+	// interleaved.
+	for _, f := range []*message.Message{fa[0], fb[0], fa[1], fa[2], fb[1]} {
+		msg, err := r.Add(f, now)
+		require.NoError(t, err)
+		if f == fb[1] {
+			require.NotNil(t, msg)
+			assert.Equal(t, uint32(2), msg.ID)
+			assert.Equal(t, b, msg.Data)
+		} else {
+			assert.Nil(t, msg)
+		}
+	}
+	assert.Equal(t, 1, r.InFlight())
+	msg, err := r.Add(fa[3], now)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), msg.ID)
+	assert.Equal(t, uint16(packing.ErrType), msg.Flag)
+	assert.Equal(t, a, msg.Data)
+	assert.Equal(t, 0, r.InFlight())
+}
+
+func TestReassembler_Limits(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, 100)
+	now := time.Now()
+
+	r := NewReassembler(Config{MaxSize: 50})
+	frags := split(t, &message.Message{Data: data}, 1, 30)
+	_, err := r.Add(frags[0], now)
+	require.NoError(t, err)
+	_, err = r.Add(frags[1], now)
+	assert.True(t, errors.Is(err, ErrTooLarge))
+	_, err = r.Add(frags[2], now)
+	assert.True(t, errors.Is(err, ErrUnknown))
+
+	r = NewReassembler(Config{MaxInFlight: 1})
+	_, err = r.Add(split(t, &message.Message{Data: data}, 1, 30)[0], now)
+	require.NoError(t, err)
+	_, err = r.Add(split(t, &message.Message{Data: data}, 2, 30)[0], now)
+	assert.True(t, errors.Is(err, ErrTooManyInFlight))
+
+	r = NewReassembler(Config{Timeout: time.Second})
+	frags = split(t, &message.Message{Data: data}, 1, 30)
+	_, err = r.Add(frags[0], now)
+	require.NoError(t, err)
+	_, err = r.Add(frags[1], now.Add(2*time.Second))
+	assert.True(t, errors.Is(err, ErrUnknown))
+}
+
+func TestReassembler_OutOfOrder(t *testing.T) {
+	frags := split(t, &message.Message{Data: bytes.Repeat([]byte{'a'}, 100)}, 1, 30)
+	r := NewReassembler(Config{})
+	_, err := r.Add(frags[0], time.Now())
+	require.NoError(t, err)
+	_, err = r.Add(frags[2], time.Now())
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUnknown))
+
+	_, _, err = ParseHeader([]byte{0, 0, 0, 1, 0, 2, 0, 2})
+	assert.Error(t, err)
+}
+
+func TestReassembler_DefaultLimits(t *testing.T) {
+	r := NewReassembler(Config{})
+	now := time.Now()
+	for i := 0; i < DefaultMaxInFlight; i++ {
+		_, err := r.Add(split(t, &message.Message{Data: make([]byte, 100)}, uint32(i), 30)[0], now)
+		require.NoError(t, err)
+	}
+	_, err := r.Add(split(t, &message.Message{Data: make([]byte, 100)}, DefaultMaxInFlight, 30)[0], now)
+	assert.True(t, errors.Is(err, ErrTooManyInFlight))
+
+	// the messages in flight time out.
+	_, err = r.Add(split(t, &message.Message{Data: make([]byte, 100)}, DefaultMaxInFlight, 30)[0], now.Add(DefaultTimeout+time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.InFlight())
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.Error(t, (&Config{}).Validate())
+	assert.Error(t, (&Config{ChunkSize: -1}).Validate())
+	assert.Error(t, (&Config{ChunkSize: 1024, MaxSize: -1}).Validate())
+	assert.NoError(t, (&Config{ChunkSize: 1024}).Validate())
+}
@@ -0,0 +1,64 @@
+package ktcp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/fragment"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_Fragmentation(t *testing.T) {
+	conf := fragment.Config{ChunkSize: 64 * KB, MaxSize: 4 * MB}
+	_, conn := serveTest(t, rawEchoHandler{}, withRawCodec, Fragmentation(conf))
+	packer := packing.NewDefaultPacker()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	// beyond the 1MB max data size of the packer.
+	data := bytes.Repeat([]byte("snapshot"), 3*MB/8)
+	require.NoError(t, fragment.Split(&message.Message{ID: 10, Data: data}, 1, conf.ChunkSize, func(m *message.Message) error {
+		return packing.PackTo(conn, packer, m)
+	}))
+	// a small message after the large one.
+	require.NoError(t, packing.PackTo(conn, packer, &message.Message{ID: 20, Data: []byte("ping")}))
+
+	r := fragment.NewReassembler(conf)
+	var got []*message.Message
+	for len(got) < 2 {
+		msg, err := packer.Unpack(conn)
+		require.NoError(t, err)
+		if msg.Flag&packing.FlagFragment == 0 {
+			got = append(got, msg)
+			continue
+		}
+		assert.LessOrEqual(t, len(msg.Data), fragment.HeaderSize+conf.ChunkSize)
+		if msg, err = r.Add(msg, time.Now()); err != nil {
+			require.NoError(t, err)
+		} else if msg != nil {
+			got = append(got, msg)
+		}
+	}
+	for _, msg := range got {
+		switch msg.ID {
+		case 11:
+			assert.Equal(t, data, msg.Data)
+		case 21:
+			assert.Equal(t, []byte("ping"), msg.Data)
+		default:
+			t.Fatalf("unexpected message %d", msg.ID)
+		}
+	}
+}
+
+func TestServer_FragmentationInvalid(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	srv := NewServer(rawEchoHandler{}, Fragmentation(fragment.Config{}))
+	assert.Error(t, srv.ServeListener(lis))
+}
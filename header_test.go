@@ -0,0 +1,82 @@
+package ktcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/metadata"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// headerHandler replies the trace id of the request header and the server metadata.
+type headerHandler struct{}
+
+func (headerHandler) OnConnect(*Session) {}
+
+func (headerHandler) OnMessage(c Context) {
+	md, _ := metadata.FromServerContext(c)
+	c.ReplyHeader().Set("x-trace-id", c.RequestHeader().Get("x-trace-id"))
+	if stream := c.Stream(); stream != nil {
+		var in testData.TestModel
+		_ = stream.RecvMsg(&in)
+		_ = stream.SendMsg(2, &testData.TestModel{Name: md.Get("x-locale")})
+		_ = stream.Finish(nil)
+		return
+	}
+	var in testData.TestModel
+	if err := c.Bind(&in); err != nil {
+		return
+	}
+	_ = c.Send(2, &testData.TestModel{Name: md.Get("x-locale") + in.Name})
+}
+
+func (headerHandler) OnClose(*Session) {}
+
+func TestSession_Header(t *testing.T) {
+	_, conn := serveTest(t, headerHandler{})
+
+	replies := make(chan *message.Message, 1)
+	c := NewClient(conn, ClientHandler(func(msg *message.Message) {
+		replies <- &message.Message{ID: msg.ID, Data: append([]byte(nil), msg.Data...), Header: msg.Header}
+	}))
+
+	ctx := metadata.NewClientContext(context.Background(), metadata.New(map[string][]string{
+		"X-Trace-ID": {"trace"},
+		"X-Locale":   {"en"},
+	}))
+	require.NoError(t, c.SendContext(ctx, 1, &testData.TestModel{Name: "a"}))
+	var out testData.TestModel
+	select {
+	case msg := <-replies:
+		require.NoError(t, c.codec.Unmarshal(msg.Data, &out))
+		assert.Equal(t, "ena", out.Name)
+		assert.Equal(t, "trace", msg.Header.Get("X-Trace-ID"))
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reply")
+	}
+
+	// the request without a header has no server metadata.
+	require.NoError(t, c.Send(1, &testData.TestModel{Name: "b"}))
+	msg := <-replies
+	require.NoError(t, c.codec.Unmarshal(msg.Data, &out))
+	assert.Equal(t, "b", out.Name)
+	assert.Equal(t, message.Header{"x-trace-id": {""}}, msg.Header)
+}
+
+func TestStream_Header(t *testing.T) {
+	_, conn := serveTest(t, headerHandler{})
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stream, err := c.NewStream(metadata.AppendToClientContext(ctx, "x-locale", "fr"), 1)
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(&testData.TestModel{}))
+	var out testData.TestModel
+	require.NoError(t, stream.RecvMsg(&out))
+	assert.Equal(t, "fr", out.Name)
+}
@@ -0,0 +1,230 @@
+// Package integrity implements the frame integrity trailer: a frame counter and a checksum
+// appended to the message data, inside the frame of the wrapped packer:
+//
+//	data(n)|counter(8)|sum(4 or 32)
+//
+// The sum is a CRC32C checksum or an HMAC-SHA256 of the message id, flag, data and counter.
+// The HMAC key of a session is derived from a shared secret and a random salt the server writes
+// when the connection is established:
+//
+//	server -> client: salt(16)
+//
+// The counters of both directions start from zero, a frame whose counter is not the next one of
+// its direction is rejected as well as a frame whose sum mismatches.
+package integrity
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+const (
+	// SaltSize is the size of the salt of the session HMAC key.
+	SaltSize = 16
+	// CounterSize is the size of the frame counter of the trailer.
+	CounterSize = 8
+)
+
+// ErrMismatch is returned when the checksum or the counter of a frame mismatches.
+var ErrMismatch = errors.New("integrity: checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Algorithm is the checksum algorithm of the trailer.
+type Algorithm int
+
+const (
+	// CRC32C detects the corrupted frames.
+	CRC32C Algorithm = iota
+	// HMACSHA256 detects the tampered frames, it requires Config.Secret.
+	HMACSHA256
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case CRC32C:
+		return "crc32c"
+	case HMACSHA256:
+		return "hmac-sha256"
+	default:
+		return "unknown"
+	}
+}
+
+// Size returns the size of the sum.
+func (a Algorithm) Size() int {
+	if a == HMACSHA256 {
+		return sha256.Size
+	}
+	return crc32.Size
+}
+
+// Config is the integrity config of a listener.
+type Config struct {
+	// Algorithm of the checksum.
+	Algorithm Algorithm
+	// Secret shared with the clients, the HMAC key of a session is derived from it.
+	Secret []byte
+}
+
+func (c *Config) validate() error {
+	switch c.Algorithm {
+	case CRC32C:
+	case HMACSHA256:
+		if len(c.Secret) == 0 {
+			return fmt.Errorf("%s requires a secret", c.Algorithm)
+		}
+	default:
+		return fmt.Errorf("invalid algorithm %d", c.Algorithm)
+	}
+	return nil
+}
+
+// sessionKey derives the HMAC key of a session from the secret and the salt.
+func (c *Config) sessionKey(salt []byte) []byte {
+	h := hmac.New(sha256.New, c.Secret)
+	h.Write(salt)
+	return h.Sum(nil)
+}
+
+// ServerHandshake writes the salt of the session key to w if the algorithm is keyed,
+// and returns the Packer of the session wrapping p.
+func ServerHandshake(w io.Writer, p packing.Packer, conf *Config) (*Packer, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	if conf.Algorithm != HMACSHA256 {
+		return NewPacker(p, conf.Algorithm, nil)
+	}
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt err: %s", err)
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, fmt.Errorf("write salt err: %s", err)
+	}
+	return NewPacker(p, conf.Algorithm, conf.sessionKey(salt))
+}
+
+// ClientHandshake reads the salt of the session key from r if the algorithm is keyed,
+// and returns the Packer of the connection wrapping p.
+func ClientHandshake(r io.Reader, p packing.Packer, conf *Config) (*Packer, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	if conf.Algorithm != HMACSHA256 {
+		return NewPacker(p, conf.Algorithm, nil)
+	}
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, fmt.Errorf("read salt err: %s", err)
+	}
+	return NewPacker(p, conf.Algorithm, conf.sessionKey(salt))
+}
+
+var _ packing.AppendPacker = &Packer{}
+
+// Packer wraps a packing.Packer, it appends the trailer to the data of the packed messages and
+// verifies and strips the trailer of the unpacked messages.
+//
+// The frames are numbered when they are packed, so Pack must be called in the order the frames are written
+// and must not be called concurrently. A frame which fails to pack doesn't take a number.
+type Packer struct {
+	packer  packing.Packer
+	alg     Algorithm
+	key     []byte
+	sendSeq uint64 // the counter of the next packed frame
+	recvSeq uint64
+}
+
+// NewPacker creates a *Packer wrapping p, key is the HMAC key of HMACSHA256.
+func NewPacker(p packing.Packer, alg Algorithm, key []byte) (*Packer, error) {
+	if alg == HMACSHA256 && len(key) == 0 {
+		return nil, fmt.Errorf("%s requires a key", alg)
+	}
+	if alg != CRC32C && alg != HMACSHA256 {
+		return nil, fmt.Errorf("invalid algorithm %d", alg)
+	}
+	return &Packer{packer: p, alg: alg, key: key}, nil
+}
+
+// Packer returns the wrapped packer.
+func (t *Packer) Packer() packing.Packer {
+	return t.packer
+}
+
+// Pack implements the Packer Pack method.
+func (t *Packer) Pack(msg *message.Message) ([]byte, error) {
+	return t.AppendPack(nil, msg)
+}
+
+// AppendPack implements the AppendPacker AppendPack method.
+func (t *Packer) AppendPack(dst []byte, msg *message.Message) ([]byte, error) {
+	seq := t.sendSeq
+
+	buf := packing.GetBuffer()
+	defer packing.PutBuffer(buf)
+	data := append((*buf)[:0], msg.Data...)
+	data = binary.BigEndian.AppendUint64(data, seq)
+	data = t.sum(data, msg.ID, msg.Flag, data)
+	*buf = data
+
+	dst, err := packing.AppendPack(t.packer, dst, &message.Message{ID: msg.ID, Flag: msg.Flag, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	t.sendSeq++
+	return dst, nil
+}
+
+// Unpack implements the Packer Unpack method, it returns ErrMismatch if the trailer mismatches.
+// The message can be released as the ones of the wrapped packer.
+func (t *Packer) Unpack(reader io.Reader) (*message.Message, error) {
+	msg, err := t.packer.Unpack(reader)
+	if err != nil {
+		return nil, err
+	}
+	size := t.alg.Size()
+	if len(msg.Data) < CounterSize+size {
+		msg.Release()
+		return nil, fmt.Errorf("%w: the dataSize %d is less than the trailer", ErrMismatch, len(msg.Data))
+	}
+	n := len(msg.Data) - CounterSize - size
+	seq := binary.BigEndian.Uint64(msg.Data[n:])
+	if seq != t.recvSeq {
+		msg.Release()
+		return nil, fmt.Errorf("%w: counter %d, expected %d", ErrMismatch, seq, t.recvSeq)
+	}
+	var sum [sha256.Size]byte
+	if !hmac.Equal(t.sum(sum[:0], msg.ID, msg.Flag, msg.Data[:n+CounterSize]), msg.Data[n+CounterSize:]) {
+		msg.Release()
+		return nil, fmt.Errorf("%w: message %d", ErrMismatch, msg.ID)
+	}
+	t.recvSeq++
+	msg.Data = msg.Data[:n]
+	return msg, nil
+}
+
+// sum appends the sum of the message id, flag and data with counter to dst.
+func (t *Packer) sum(dst []byte, id uint32, flag uint16, data []byte) []byte {
+	var head [6]byte
+	binary.BigEndian.PutUint32(head[:4], id)
+	binary.BigEndian.PutUint16(head[4:], flag)
+	if t.alg == CRC32C {
+		crc := crc32.Update(0, castagnoli, head[:])
+		return binary.BigEndian.AppendUint32(dst, crc32.Update(crc, castagnoli, data))
+	}
+	h := hmac.New(sha256.New, t.key)
+	h.Write(head[:])
+	h.Write(data)
+	return h.Sum(dst)
+}
@@ -0,0 +1,100 @@
+package integrity
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handshake returns the packers of both sides of a connection.
+func handshake(t *testing.T, conf *Config) (client, server *Packer) {
+	t.Helper()
+	var wire bytes.Buffer
+	server, err := ServerHandshake(&wire, packing.NewDefaultPacker(), conf)
+	require.NoError(t, err)
+	client, err = ClientHandshake(&wire, packing.NewDefaultPacker(), conf)
+	require.NoError(t, err)
+	return client, server
+}
+
+func TestPacker(t *testing.T) {
+	for _, conf := range []*Config{{Algorithm: CRC32C}, {Algorithm: HMACSHA256, Secret: []byte("secret")}} {
+		t.Run(conf.Algorithm.String(), func(t *testing.T) {
+			client, server := handshake(t, conf)
+
+			var wire bytes.Buffer
+			for i := 0; i < 3; i++ {
+				frame, err := client.Pack(&message.Message{ID: uint32(i), Flag: packing.OKType, Data: []byte("data")})
+				require.NoError(t, err)
+				assert.Len(t, frame, 10+4+CounterSize+conf.Algorithm.Size())
+				wire.Write(frame)
+			}
+			for i := 0; i < 3; i++ {
+				msg, err := server.Unpack(&wire)
+				require.NoError(t, err)
+				assert.Equal(t, uint32(i), msg.ID)
+				assert.Equal(t, uint16(packing.OKType), msg.Flag)
+				assert.Equal(t, []byte("data"), msg.Data)
+			}
+		})
+	}
+}
+
+func TestPacker_Mismatch(t *testing.T) {
+	client, server := handshake(t, &Config{Algorithm: HMACSHA256, Secret: []byte("secret")})
+
+	frame, err := client.Pack(&message.Message{ID: 1, Data: []byte("gold=10")})
+	require.NoError(t, err)
+	tampered := append([]byte{}, frame...)
+	tampered[16] = '9'
+	_, err = server.Unpack(bytes.NewReader(tampered))
+	assert.True(t, errors.Is(err, ErrMismatch))
+
+	_, err = server.Unpack(bytes.NewReader(frame))
+	require.NoError(t, err)
+	// replayed.
+	_, err = server.Unpack(bytes.NewReader(frame))
+	assert.True(t, errors.Is(err, ErrMismatch))
+}
+
+func TestPacker_PackFailed(t *testing.T) {
+	p, err := packing.NewFramePacker(packing.DefaultFrameSpec)
+	require.NoError(t, err)
+	client, err := NewPacker(p, CRC32C, nil)
+	require.NoError(t, err)
+	server, err := NewPacker(p, CRC32C, nil)
+	require.NoError(t, err)
+
+	// the frame beyond the max data size of the wrapped packer is not numbered.
+	_, err = client.Pack(&message.Message{ID: 1, Data: make([]byte, packing.DefaultFrameSpec.MaxDataSize)})
+	require.Error(t, err)
+
+	frame, err := client.Pack(&message.Message{ID: 2, Data: []byte("data")})
+	require.NoError(t, err)
+	msg, err := server.Unpack(bytes.NewReader(frame))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), msg.Data)
+}
+
+func TestPacker_SessionKey(t *testing.T) {
+	conf := &Config{Algorithm: HMACSHA256, Secret: []byte("secret")}
+	client, _ := handshake(t, conf)
+	_, server := handshake(t, conf)
+
+	frame, err := client.Pack(&message.Message{ID: 1})
+	require.NoError(t, err)
+	_, err = server.Unpack(bytes.NewReader(frame))
+	assert.True(t, errors.Is(err, ErrMismatch))
+}
+
+func TestConfig_Validate(t *testing.T) {
+	_, err := ServerHandshake(&bytes.Buffer{}, packing.NewDefaultPacker(), &Config{Algorithm: HMACSHA256})
+	assert.Error(t, err)
+	_, err = ServerHandshake(&bytes.Buffer{}, packing.NewDefaultPacker(), &Config{Algorithm: 9})
+	assert.Error(t, err)
+}
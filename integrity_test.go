@@ -0,0 +1,52 @@
+package ktcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/integrity"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closeHandler echoes the requests and reports the closed sessions.
+type closeHandler struct {
+	rawEchoHandler
+	closed chan *Session
+}
+
+func (h *closeHandler) OnClose(s *Session) {
+	h.closed <- s
+}
+
+func TestServer_Integrity(t *testing.T) {
+	conf := &integrity.Config{Algorithm: integrity.HMACSHA256, Secret: []byte("secret")}
+	h := &closeHandler{closed: make(chan *Session, 1)}
+	srv, conn := serveTest(t, h, withRawCodec, Integrity(conf))
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	packer, err := integrity.ClientHandshake(conn, packing.NewDefaultPacker(), conf)
+	require.NoError(t, err)
+	require.NoError(t, packing.PackTo(conn, packer, &message.Message{ID: 1, Data: []byte("hello")}))
+	msg, err := packer.Unpack(conn)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), msg.ID)
+	assert.Equal(t, []byte("hello"), msg.Data)
+
+	frame, err := packer.Pack(&message.Message{ID: 3, Data: []byte("hello")})
+	require.NoError(t, err)
+	frame[10]++
+	_, err = conn.Write(frame)
+	require.NoError(t, err)
+
+	select {
+	case sess := <-h.closed:
+		assert.Equal(t, CloseChecksum, sess.CloseReason())
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not closed")
+	}
+	assert.Equal(t, int64(1), srv.Closed(CloseChecksum))
+	assert.Equal(t, int64(0), srv.Closed(CloseError))
+}
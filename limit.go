@@ -0,0 +1,223 @@
+package ktcp
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/kwstars/ktcp/ratelimit"
+	"github.com/kwstars/ktcp/sync/atomic"
+)
+
+// RejectReason is the reason why an accepted connection is rejected.
+type RejectReason int
+
+const (
+	RejectAcceptRate RejectReason = iota + 1
+	RejectMaxSessions
+	RejectMaxSessionsPerIP
+	RejectListenerMaxSessions
+	rejectReasonEnd
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectAcceptRate:
+		return "accept_rate"
+	case RejectMaxSessions:
+		return "max_sessions"
+	case RejectMaxSessionsPerIP:
+		return "max_sessions_per_ip"
+	case RejectListenerMaxSessions:
+		return "listener_max_sessions"
+	default:
+		return "unknown"
+	}
+}
+
+// rejectTimeout bounds the time a reject policy may take on a rejected connection.
+const rejectTimeout = time.Second
+
+// RejectPolicy handles a rejected connection, the connection is closed after it returns.
+// packer is the packer of the listener which accepted the connection.
+//
+// The policy runs off the accept loop, and the deadline of the connection is at most one second
+// from the rejection unless the policy sets its own. It is not called for the connections of the
+// listeners with TLS, encryption, integrity or negotiation, which are closed right away since the
+// client cannot decode a frame of the listener packer before the handshakes.
+type RejectPolicy func(conn net.Conn, packer packing.Packer, reason RejectReason)
+
+// RejectClose closes the rejected connections right away.
+func RejectClose(net.Conn, packing.Packer, RejectReason) {}
+
+// RejectNotify sends the frame of msg, e.g. a "server full" message, before closing the rejected connections.
+// The frame is written within writeTimeout, or the one second deadline of RejectPolicy if zero.
+func RejectNotify(msg *message.Message, writeTimeout time.Duration) RejectPolicy {
+	return func(conn net.Conn, packer packing.Packer, _ RejectReason) {
+		frame, err := packer.Pack(msg)
+		if err != nil {
+			return
+		}
+		if writeTimeout > 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+		_, _ = conn.Write(frame)
+	}
+}
+
+// MaxSessions with the max number of concurrent sessions of the server. Zero means no limit.
+func MaxSessions(n int) ServerOption {
+	return func(s *Server) {
+		s.limiter.maxSessions = n
+	}
+}
+
+// MaxSessionsPerIP with the max number of concurrent sessions of a remote IP. Zero means no limit.
+func MaxSessionsPerIP(n int) ServerOption {
+	return MaxSessionsPerCIDR(n, 8*net.IPv4len, 8*net.IPv6len)
+}
+
+// MaxSessionsPerCIDR with the max number of concurrent sessions of the remote addresses
+// sharing the same IPv4 or IPv6 prefix, e.g. (n, 24, 64). Zero means no limit.
+func MaxSessionsPerCIDR(n, ipv4Bits, ipv6Bits int) ServerOption {
+	return func(s *Server) {
+		s.limiter.maxPerIP = n
+		s.limiter.ipv4Mask = net.CIDRMask(ipv4Bits, 8*net.IPv4len)
+		s.limiter.ipv6Mask = net.CIDRMask(ipv6Bits, 8*net.IPv6len)
+	}
+}
+
+// AcceptRate with the accepting rate limit of the server, r connections per second with burst.
+func AcceptRate(r float64, burst int) ServerOption {
+	return func(s *Server) {
+		s.limiter.accept = ratelimit.NewBucket(r, burst)
+	}
+}
+
+// Rejection with the policy handling the rejected connections, RejectClose by default.
+func Rejection(p RejectPolicy) ServerOption {
+	return func(s *Server) {
+		s.limiter.policy = p
+	}
+}
+
+// RateLimit with the message rate limits of the sessions.
+func RateLimit(conf ratelimit.Config) ServerOption {
+	return func(s *Server) {
+		s.rateLimit = ratelimit.New(conf)
+	}
+}
+
+// Rejected returns the number of connections rejected for reason.
+func (s *Server) Rejected(reason RejectReason) int64 {
+	if reason <= 0 || reason >= rejectReasonEnd {
+		return 0
+	}
+	return s.limiter.rejects[reason].Get()
+}
+
+// connLimiter limits the accepted connections of a server.
+type connLimiter struct {
+	maxSessions int
+	maxPerIP    int
+	ipv4Mask    net.IPMask
+	ipv6Mask    net.IPMask
+	accept      *ratelimit.Bucket
+	policy      RejectPolicy
+	sessions    atomic.Int64
+	mu          sync.Mutex
+	perIP       map[string]int
+	rejects     [rejectReasonEnd]atomic.Int64
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{
+		policy: RejectClose,
+		perIP:  make(map[string]int),
+	}
+}
+
+// allowAccept reports whether the accepting rate allows one more connection.
+func (c *connLimiter) allowAccept() bool {
+	return c.accept == nil || c.accept.Allow()
+}
+
+// acquire takes a session slot of the server, the listener l and the remote address addr.
+// release must be called when the session ends if the reason is zero.
+func (c *connLimiter) acquire(l *listener, addr net.Addr) (release func(), reason RejectReason) {
+	if n := l.sessions.Add(1); l.maxSessions > 0 && int(n) > l.maxSessions {
+		l.sessions.Add(-1)
+		return nil, RejectListenerMaxSessions
+	}
+	if n := c.sessions.Add(1); c.maxSessions > 0 && int(n) > c.maxSessions {
+		c.sessions.Add(-1)
+		l.sessions.Add(-1)
+		return nil, RejectMaxSessions
+	}
+
+	key := c.ipKey(addr)
+	if key != "" {
+		c.mu.Lock()
+		if c.perIP[key] >= c.maxPerIP {
+			c.mu.Unlock()
+			c.sessions.Add(-1)
+			l.sessions.Add(-1)
+			return nil, RejectMaxSessionsPerIP
+		}
+		c.perIP[key]++
+		c.mu.Unlock()
+	}
+
+	return func() {
+		if key != "" {
+			c.mu.Lock()
+			if c.perIP[key]--; c.perIP[key] <= 0 {
+				delete(c.perIP, key)
+			}
+			c.mu.Unlock()
+		}
+		c.sessions.Add(-1)
+		l.sessions.Add(-1)
+	}, 0
+}
+
+// reject counts the rejection and hands the connection accepted on the listener l to the reject policy
+// before closing it. The connections of the listeners with handshakes are closed right away.
+func (c *connLimiter) reject(conn net.Conn, l *listener, reason RejectReason) {
+	c.rejects[reason].Add(1)
+	if !l.handshakes() {
+		_ = conn.SetDeadline(time.Now().Add(rejectTimeout))
+		c.policy(conn, l.packer, reason)
+	}
+	_ = conn.Close()
+}
+
+// ipKey returns the masked remote IP of addr, or empty if the per IP limit is off
+// or addr has no IP, e.g. a unix socket.
+func (c *connLimiter) ipKey(addr net.Addr) string {
+	if c.maxPerIP <= 0 {
+		return ""
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return ""
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(c.ipv4Mask).String()
+	}
+	return ip.Mask(c.ipv6Mask).String()
+}
@@ -0,0 +1,177 @@
+package ktcp
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/kwstars/ktcp/encoding/proto"
+	"github.com/kwstars/ktcp/integrity"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/negotiate"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/kwstars/ktcp/ratelimit"
+	"github.com/kwstars/ktcp/secure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_MaxSessionsPerIP(t *testing.T) {
+	full := &message.Message{ID: 999, Flag: packing.ErrType, Data: []byte("server full")}
+	srv, conn := serveTest(t, &echoHandler{}, MaxSessionsPerIP(1), Rejection(RejectNotify(full, time.Second)))
+	addr := conn.RemoteAddr().String()
+	roundTrip(t, conn, 1, &testData.TestModel{Id: 1})
+
+	rejected, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rejected.Close()
+	require.NoError(t, rejected.SetReadDeadline(time.Now().Add(time.Second)))
+	msg, err := packing.NewDefaultPacker().Unpack(rejected)
+	require.NoError(t, err)
+	assert.Equal(t, full.ID, msg.ID)
+	assert.Equal(t, full.Data, msg.Data)
+	_, err = rejected.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), srv.Rejected(RejectMaxSessionsPerIP))
+
+	// the slot is released when the first session ends.
+	conn.Close()
+	require.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		defer c.Close()
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		msg, err := packing.NewDefaultPacker().Unpack(c)
+		return err != nil && msg == nil
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestServer_MaxSessions(t *testing.T) {
+	srv, conn := serveTest(t, &echoHandler{}, MaxSessions(1))
+	addr := conn.RemoteAddr().String()
+	roundTrip(t, conn, 1, &testData.TestModel{Id: 1})
+
+	rejected, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rejected.Close()
+	require.NoError(t, rejected.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = rejected.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), srv.Rejected(RejectMaxSessions))
+	assert.Equal(t, int64(0), srv.Rejected(RejectMaxSessionsPerIP))
+}
+
+func TestServer_AcceptRate(t *testing.T) {
+	srv, conn := serveTest(t, &echoHandler{}, AcceptRate(0.001, 1))
+	addr := conn.RemoteAddr().String()
+	roundTrip(t, conn, 1, &testData.TestModel{Id: 1})
+
+	rejected, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rejected.Close()
+	require.NoError(t, rejected.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = rejected.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), srv.Rejected(RejectAcceptRate))
+}
+
+func TestServer_AcceptRateSlowPolicy(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	policy := func(net.Conn, packing.Packer, RejectReason) { <-block }
+	srv, conn := serveTest(t, &echoHandler{}, AcceptRate(0.001, 1), Rejection(policy))
+
+	for i := 0; i < 2; i++ {
+		rejected, err := net.Dial("tcp", conn.RemoteAddr().String())
+		require.NoError(t, err)
+		defer rejected.Close()
+	}
+	// the blocked policy does not stall the accept loop.
+	assert.Eventually(t, func() bool {
+		return srv.Rejected(RejectAcceptRate) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestConnLimiter_rejectHandshakes(t *testing.T) {
+	c := newConnLimiter()
+	var called bool
+	c.policy = func(net.Conn, packing.Packer, RejectReason) { called = true }
+
+	for _, l := range []*listener{
+		{tlsConf: &tls.Config{}},
+		{secure: &secure.Config{}},
+		{integrity: &integrity.Config{}},
+		{negotiate: &negotiate.Config{}},
+	} {
+		l.packer = packing.NewDefaultPacker()
+		server, client := net.Pipe()
+		c.reject(server, l, RejectMaxSessions)
+		assert.False(t, called)
+		_, err := client.Read(make([]byte, 1))
+		assert.Error(t, err)
+		client.Close()
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	// the deadline bounds a policy writing to a peer which does not read.
+	c.policy = RejectNotify(&message.Message{ID: 1, Data: []byte("full")}, 0)
+	start := time.Now()
+	c.reject(server, &listener{packer: packing.NewDefaultPacker()}, RejectMaxSessions)
+	assert.Less(t, time.Since(start), 2*rejectTimeout)
+	assert.Equal(t, int64(5), c.rejects[RejectMaxSessions].Get())
+}
+
+func TestConnLimiter_ipKey(t *testing.T) {
+	c := newConnLimiter()
+	assert.Equal(t, "", c.ipKey(&net.TCPAddr{IP: net.ParseIP("10.0.0.1")}))
+
+	MaxSessionsPerCIDR(1, 24, 64)(&Server{limiter: c})
+	assert.Equal(t, "10.0.0.0", c.ipKey(&net.TCPAddr{IP: net.ParseIP("10.0.0.1")}))
+	assert.Equal(t, "2001:db8::", c.ipKey(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}))
+	assert.Equal(t, "", c.ipKey(&net.UnixAddr{Name: "/tmp/ktcp.sock", Net: "unix"}))
+}
+
+func TestServer_RateLimitReply(t *testing.T) {
+	_, conn := serveTest(t, &echoHandler{}, RateLimit(ratelimit.Config{
+		Messages: map[uint32]ratelimit.Limit{7: {Rate: 0.001, Burst: 1}},
+		Action:   ratelimit.Reply,
+		ReplyID:  500,
+	}))
+
+	msg, _ := roundTrip(t, conn, 7, &testData.TestModel{Id: 1})
+	assert.Equal(t, uint32(8), msg.ID)
+
+	frame, err := packing.NewDefaultPacker().Pack(&message.Message{ID: 7, Flag: packing.OKType})
+	require.NoError(t, err)
+	_, err = conn.Write(frame)
+	require.NoError(t, err)
+	msg, err = packing.NewDefaultPacker().Unpack(conn)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(500), msg.ID)
+	assert.Equal(t, uint16(packing.ErrType), msg.Flag)
+	var e errors.Error
+	require.NoError(t, proto.New().Unmarshal(msg.Data, &e))
+	assert.Equal(t, "RATE_LIMITED", e.Reason)
+	assert.Equal(t, int32(429), e.Code)
+}
+
+func TestServer_RateLimitDisconnect(t *testing.T) {
+	_, conn := serveTest(t, &echoHandler{}, RateLimit(ratelimit.Config{
+		Session: ratelimit.Limit{Rate: 0.001, Burst: 1},
+		Action:  ratelimit.Disconnect,
+	}))
+
+	roundTrip(t, conn, 1, &testData.TestModel{Id: 1})
+	frame, err := packing.NewDefaultPacker().Pack(&message.Message{ID: 1, Flag: packing.OKType})
+	require.NoError(t, err)
+	_, err = conn.Write(frame)
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
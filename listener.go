@@ -0,0 +1,188 @@
+package ktcp
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/integrity"
+	"github.com/kwstars/ktcp/negotiate"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/kwstars/ktcp/proxyproto"
+	"github.com/kwstars/ktcp/secure"
+	"github.com/kwstars/ktcp/sync/atomic"
+)
+
+// DefaultListenerName is the name of the listener configured by Network, Address and Listener.
+const DefaultListenerName = "default"
+
+// ListenerOption is a listener option.
+type ListenerOption func(*listener)
+
+// ListenerTLSConfig with listener tls config.
+func ListenerTLSConfig(c *tls.Config) ListenerOption {
+	return func(l *listener) {
+		l.tlsConf = c
+	}
+}
+
+// ListenerPacker with listener packer, the server Packer is used if not set.
+func ListenerPacker(p packing.Packer) ListenerOption {
+	return func(l *listener) {
+		l.packer = p
+	}
+}
+
+// ListenerCodec with listener codec, the server Codec is used if not set.
+func ListenerCodec(c encoding.Codec) ListenerOption {
+	return func(l *listener) {
+		l.codec = c
+	}
+}
+
+// ListenerMaxSessions with the max number of concurrent sessions of the listener.
+// Zero means no limit.
+func ListenerMaxSessions(n int) ListenerOption {
+	return func(l *listener) {
+		l.maxSessions = n
+	}
+}
+
+// ListenerProxyProtocol reads the PROXY protocol header of the connections from the proxies trusted by p.
+func ListenerProxyProtocol(p *proxyproto.Policy) ListenerOption {
+	return func(l *listener) {
+		l.proxy = p
+	}
+}
+
+// ListenerEncryption encrypts the message data of the sessions of the listener,
+// the key of a session is exchanged when it is established, see package secure.
+// The exchange does not authenticate the server, it does not protect against a man in the middle.
+func ListenerEncryption(c *secure.Config) ListenerOption {
+	return func(l *listener) {
+		l.secure = c
+	}
+}
+
+// ListenerIntegrity appends the integrity trailer to the frames of the sessions of the listener,
+// see package integrity.
+func ListenerIntegrity(c *integrity.Config) ListenerOption {
+	return func(l *listener) {
+		l.integrity = c
+	}
+}
+
+// ListenerNegotiation negotiates the protocol version and the capabilities of the sessions of the listener
+// when they are established, see package negotiate. The encryption and the integrity trailer of the
+// listener are then used by the sessions which negotiated them.
+func ListenerNegotiation(c *negotiate.Config) ListenerOption {
+	return func(l *listener) {
+		l.negotiate = c
+	}
+}
+
+// TLSConfig with the tls config of the default listener.
+func TLSConfig(c *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConf = c
+	}
+}
+
+// ProxyProtocol reads the PROXY protocol header of the connections of the default listener
+// from the proxies trusted by p.
+func ProxyProtocol(p *proxyproto.Policy) ServerOption {
+	return func(s *Server) {
+		s.proxy = p
+	}
+}
+
+// Encryption encrypts the message data of the sessions of the default listener,
+// the key of a session is exchanged when it is established, see package secure.
+// The exchange does not authenticate the server, it does not protect against a man in the middle.
+func Encryption(c *secure.Config) ServerOption {
+	return func(s *Server) {
+		s.secure = c
+	}
+}
+
+// Integrity appends the integrity trailer to the frames of the sessions of the default listener,
+// see package integrity.
+func Integrity(c *integrity.Config) ServerOption {
+	return func(s *Server) {
+		s.integrity = c
+	}
+}
+
+// Negotiation negotiates the protocol version and the capabilities of the sessions of the default listener
+// when they are established, see package negotiate.
+func Negotiation(c *negotiate.Config) ServerOption {
+	return func(s *Server) {
+		s.negotiate = c
+	}
+}
+
+// AddListener serves an additional listener with its own options.
+// The sessions accepted on it share the server session registry.
+func AddListener(name string, lis net.Listener, opts ...ListenerOption) ServerOption {
+	return func(s *Server) {
+		s.listeners = append(s.listeners, newListener(name, lis, opts...))
+	}
+}
+
+// AddAddress listens on an additional network address when the server starts.
+func AddAddress(name, network, address string, opts ...ListenerOption) ServerOption {
+	return func(s *Server) {
+		l := newListener(name, nil, opts...)
+		l.network = network
+		l.address = address
+		s.listeners = append(s.listeners, l)
+	}
+}
+
+// listener is a net.Listener served by the server and the options of the sessions it accepts.
+type listener struct {
+	name        string
+	network     string
+	address     string
+	lis         net.Listener
+	tlsConf     *tls.Config
+	proxy       *proxyproto.Policy
+	secure      *secure.Config
+	integrity   *integrity.Config
+	negotiate   *negotiate.Config
+	packer      packing.Packer
+	codec       encoding.Codec
+	maxSessions int
+	sessions    atomic.Int64
+}
+
+func newListener(name string, lis net.Listener, opts ...ListenerOption) *listener {
+	l := &listener{name: name, lis: lis}
+	for _, o := range opts {
+		o(l)
+	}
+	return l
+}
+
+// listen opens the listener if it has not been provided.
+func (l *listener) listen() (err error) {
+	if l.lis != nil {
+		return
+	}
+	l.lis, err = net.Listen(l.network, l.address)
+	return
+}
+
+// handshakes reports whether the sessions of the listener start with a handshake, TLS or one of
+// the session packer, so the frames of the listener packer are not understood before it.
+func (l *listener) handshakes() bool {
+	return l.tlsConf != nil || l.secure != nil || l.integrity != nil || l.negotiate != nil
+}
+
+// wrap applies the listener transport options to an accepted connection.
+func (l *listener) wrap(conn net.Conn) net.Conn {
+	if l.tlsConf != nil {
+		return tls.Server(conn, l.tlsConf)
+	}
+	return conn
+}
@@ -1,8 +1,104 @@
 package message
 
+import (
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+const (
+	minPoolClass = 6  // 64B
+	maxPoolClass = 20 // 1MB
+)
+
+// pools holds the pooled messages by the capacity class of Data.
+var pools [maxPoolClass - minPoolClass + 1]sync.Pool
+
 // Message is the unpacked message object.
 type Message struct {
-	ID   uint32 // 协议id
-	Flag uint16 // message是否正确 1:正确 2:错误
-	Data []byte // 数据
+	ID     uint32 // 协议id
+	Flag   uint16 // message是否正确 1:正确 2:错误
+	Data   []byte // 数据
+	Header Header // 头部, nil if the message has no header
+	pooled bool
+}
+
+// Header is the key/value header section of a message, e.g. trace id, auth token, client version or locale.
+// The keys are lower case, it converts to the kratos metadata.Metadata.
+type Header map[string][]string
+
+// Get returns the first value of key.
+func (h Header) Get(key string) string {
+	if v := h[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Set sets the value of key, it replaces the existing values.
+func (h Header) Set(key, value string) {
+	h[strings.ToLower(key)] = []string{value}
+}
+
+// Add appends the value to key.
+func (h Header) Add(key, value string) {
+	key = strings.ToLower(key)
+	h[key] = append(h[key], value)
+}
+
+// Values returns the values of key.
+func (h Header) Values(key string) []string {
+	return h[strings.ToLower(key)]
+}
+
+// Keys returns the keys of the header.
+func (h Header) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Acquire returns a message from the pool whose Data has length n.
+// The message is returned to the pool by Release.
+func Acquire(n int) *Message {
+	class := poolClass(n)
+	if class < 0 {
+		return &Message{Data: make([]byte, n)}
+	}
+	m, ok := pools[class].Get().(*Message)
+	if !ok {
+		m = &Message{Data: make([]byte, 0, 1<<(class+minPoolClass))}
+	}
+	m.pooled = true
+	m.Data = m.Data[:n]
+	return m
+}
+
+// Release returns the message to the pool if it comes from Acquire.
+// The message and its Data must not be used after Release.
+func (m *Message) Release() {
+	if m == nil || !m.pooled {
+		return
+	}
+	m.pooled = false
+	class := poolClass(cap(m.Data))
+	if class < 0 || cap(m.Data) != 1<<(class+minPoolClass) {
+		return
+	}
+	m.ID, m.Flag, m.Data, m.Header = 0, 0, m.Data[:0], nil
+	pools[class].Put(m)
+}
+
+// poolClass returns the class of the size n, or -1 if n is beyond the pooled sizes.
+func poolClass(n int) int {
+	if n <= 1<<minPoolClass {
+		return 0
+	}
+	class := bits.Len(uint(n-1)) - minPoolClass
+	if class > maxPoolClass-minPoolClass {
+		return -1
+	}
+	return class
 }
@@ -0,0 +1,42 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolClass(t *testing.T) {
+	assert.Equal(t, 0, poolClass(0))
+	assert.Equal(t, 0, poolClass(64))
+	assert.Equal(t, 1, poolClass(65))
+	assert.Equal(t, 1, poolClass(128))
+	assert.Equal(t, 14, poolClass(1<<20))
+	assert.Equal(t, -1, poolClass(1<<20+1))
+}
+
+func TestAcquireRelease(t *testing.T) {
+	m := Acquire(100)
+	assert.Equal(t, 100, len(m.Data))
+	assert.Equal(t, 128, cap(m.Data))
+	m.ID, m.Flag = 1, 2
+	m.Release()
+	assert.Equal(t, false, m.pooled)
+	// releasing twice is a no-op.
+	m.Release()
+
+	big := Acquire(1<<20 + 1)
+	assert.Equal(t, false, big.pooled)
+	big.Release()
+
+	// messages not from Acquire are never pooled.
+	(&Message{Data: make([]byte, 64)}).Release()
+}
+
+func TestAcquire_NoAllocs(t *testing.T) {
+	Acquire(512).Release()
+	allocs := testing.AllocsPerRun(100, func() {
+		Acquire(512).Release()
+	})
+	assert.Equal(t, float64(0), allocs)
+}
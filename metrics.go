@@ -0,0 +1,95 @@
+package ktcp
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/metrics"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// Metrics measures the server by m, see package metrics. The nil metrics of m are not measured.
+func Metrics(m *metrics.Metrics) ServerOption {
+	return func(s *Server) {
+		if m != nil {
+			s.metrics = m.Complete()
+		}
+	}
+}
+
+// idLabel returns the label value of the message id, metrics.OtherID if it is not registered.
+func idLabel(id uint32) string {
+	if _, ok := msgtype.Lookup(id); ok {
+		return strconv.FormatUint(uint64(id), 10)
+	}
+	return metrics.OtherID
+}
+
+// measureReject records the connection rejected on the listener l.
+func (s *Server) measureReject(l *listener, reason RejectReason) {
+	if s.metrics != nil {
+		s.metrics.Rejects.With(l.name, reason.String()).Inc()
+	}
+}
+
+// measureIn records the inbound frame msg.
+func (s *Session) measureIn(msg *message.Message) {
+	s.stats.framesIn.Add(1)
+	s.stats.bytesIn.Add(int64(len(msg.Data)))
+	s.stats.lastActive.Swap(time.Now().UnixNano())
+	if s.metrics != nil {
+		id := idLabel(msg.ID)
+		s.metrics.FramesIn.With(id).Inc()
+		s.metrics.BytesIn.With(id).Add(float64(len(msg.Data)))
+	}
+}
+
+// measureOut records the outbound frame msg.
+func (s *Session) measureOut(msg *message.Message) {
+	s.stats.framesOut.Add(1)
+	s.stats.bytesOut.Add(int64(len(msg.Data)))
+	s.stats.lastActive.Swap(time.Now().UnixNano())
+	if s.metrics != nil {
+		id := idLabel(msg.ID)
+		s.metrics.FramesOut.With(id).Inc()
+		s.metrics.BytesOut.With(id).Add(float64(len(msg.Data)))
+	}
+}
+
+// measureError records the outbound message msg if it has the error flag.
+func (s *Session) measureError(msg *message.Message) {
+	if s.metrics != nil && packing.MessageType(msg.Flag) == packing.ErrType {
+		s.metrics.Errors.With(idLabel(msg.ID)).Inc()
+	}
+}
+
+// measureHandler records the running handler of the message id,
+// the returned func records its latency once it finishes.
+func (s *Session) measureHandler(id uint32) func() {
+	if s.metrics == nil {
+		return func() {}
+	}
+	start := time.Now()
+	queue := s.metrics.QueueDepth.With(metrics.QueueHandlers)
+	queue.Add(1)
+	return func() {
+		queue.Sub(1)
+		s.metrics.Latency.With(idLabel(id)).Observe(time.Since(start).Seconds())
+	}
+}
+
+// measureBatch records the change of the pending frames of the write batch.
+func (s *Session) measureBatch(delta int) {
+	if s.metrics != nil && delta != 0 {
+		s.metrics.QueueDepth.With(metrics.QueueBatch).Add(float64(delta))
+	}
+}
+
+// measureRetry records a connection write retried on a temporary error.
+func (s *Session) measureRetry() {
+	if s.metrics != nil {
+		s.metrics.WriteRetries.Inc()
+	}
+}
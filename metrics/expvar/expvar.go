@@ -0,0 +1,150 @@
+// Package expvar implements the ktcp metrics on the standard library expvar.
+//
+// The metrics are published as one map of the metric names, each metric is a map keyed by its
+// label values joined with ",", or "total" without labels. A histogram is a map of its count,
+// sum and cumulative buckets, e.g. {"count": 3, "sum": 0.02, "le_0.005": 1, "le_0.01": 2, ...}.
+package expvar
+
+import (
+	stdexpvar "expvar"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2/metrics"
+
+	ktcpmetrics "github.com/kwstars/ktcp/metrics"
+)
+
+// DefaultBuckets is the upper bounds in seconds of the latency buckets.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// New publishes the metrics of a server under name and returns them, it panics if name is published already.
+func New(name string) *ktcpmetrics.Metrics {
+	root := stdexpvar.NewMap(name)
+	return &ktcpmetrics.Metrics{
+		Sessions:     NewGauge(root, "sessions"),
+		Accepts:      NewCounter(root, "accepts"),
+		Rejects:      NewCounter(root, "rejects"),
+		Closes:       NewCounter(root, "closes"),
+		FramesIn:     NewCounter(root, "frames_in"),
+		BytesIn:      NewCounter(root, "bytes_in"),
+		FramesOut:    NewCounter(root, "frames_out"),
+		BytesOut:     NewCounter(root, "bytes_out"),
+		Latency:      NewHistogram(root, "latency_seconds", DefaultBuckets),
+		Errors:       NewCounter(root, "errors"),
+		QueueDepth:   NewGauge(root, "queue_depth"),
+		WriteRetries: NewCounter(root, "write_retries"),
+	}
+}
+
+// key returns the map key of the label values.
+func key(lvs []string) string {
+	if len(lvs) == 0 {
+		return "total"
+	}
+	return strings.Join(lvs, ",")
+}
+
+// NewCounter returns a counter published in root under name.
+func NewCounter(root *stdexpvar.Map, name string) metrics.Counter {
+	m := new(stdexpvar.Map)
+	root.Set(name, m)
+	return &counter{m: m, key: key(nil)}
+}
+
+type counter struct {
+	m   *stdexpvar.Map
+	key string
+}
+
+func (c *counter) With(lvs ...string) metrics.Counter {
+	return &counter{m: c.m, key: key(lvs)}
+}
+
+func (c *counter) Inc() {
+	c.m.AddFloat(c.key, 1)
+}
+
+func (c *counter) Add(delta float64) {
+	c.m.AddFloat(c.key, delta)
+}
+
+// NewGauge returns a gauge published in root under name.
+func NewGauge(root *stdexpvar.Map, name string) metrics.Gauge {
+	m := new(stdexpvar.Map)
+	root.Set(name, m)
+	return &gauge{m: m, key: key(nil)}
+}
+
+type gauge struct {
+	m   *stdexpvar.Map
+	key string
+}
+
+func (g *gauge) With(lvs ...string) metrics.Gauge {
+	return &gauge{m: g.m, key: key(lvs)}
+}
+
+func (g *gauge) Set(value float64) {
+	v := new(stdexpvar.Float)
+	v.Set(value)
+	g.m.Set(g.key, v)
+}
+
+func (g *gauge) Add(delta float64) {
+	g.m.AddFloat(g.key, delta)
+}
+
+func (g *gauge) Sub(delta float64) {
+	g.m.AddFloat(g.key, -delta)
+}
+
+// NewHistogram returns a histogram with the bucket upper bounds in ascending order published in root under name.
+func NewHistogram(root *stdexpvar.Map, name string, buckets []float64) metrics.Observer {
+	m := new(stdexpvar.Map)
+	root.Set(name, m)
+	keys := make([]string, len(buckets))
+	for i, b := range buckets {
+		keys[i] = "le_" + strconv.FormatFloat(b, 'g', -1, 64)
+	}
+	return &histogram{vec: &histogramVec{m: m, buckets: buckets, keys: keys}}
+}
+
+// histogramVec is the histograms of a metric by label values.
+type histogramVec struct {
+	mu      sync.Mutex
+	m       *stdexpvar.Map
+	buckets []float64
+	keys    []string
+}
+
+type histogram struct {
+	vec  *histogramVec
+	hist *stdexpvar.Map // nil until the histogram without labels observes
+}
+
+func (h *histogram) With(lvs ...string) metrics.Observer {
+	k := key(lvs)
+	h.vec.mu.Lock()
+	defer h.vec.mu.Unlock()
+	hist, ok := h.vec.m.Get(k).(*stdexpvar.Map)
+	if !ok {
+		hist = new(stdexpvar.Map)
+		h.vec.m.Set(k, hist)
+	}
+	return &histogram{vec: h.vec, hist: hist}
+}
+
+func (h *histogram) Observe(value float64) {
+	if h.hist == nil {
+		h = h.With().(*histogram)
+	}
+	h.hist.Add("count", 1)
+	h.hist.AddFloat("sum", value)
+	for i, b := range h.vec.buckets {
+		if value <= b {
+			h.hist.Add(h.vec.keys[i], 1)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package expvar
+
+import (
+	stdexpvar "expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	m := New("ktcp_test")
+	m.Accepts.With(":8080").Inc()
+	m.Accepts.With(":8080").Inc()
+	m.Rejects.With(":8080", "max_conns").Add(3)
+	m.Sessions.With(":8080").Add(2)
+	m.Sessions.With(":8080").Sub(1)
+	m.QueueDepth.With("batch").Set(5)
+	m.WriteRetries.Inc()
+	m.Latency.With("1").Observe(0.002)
+	m.Latency.With("1").Observe(0.2)
+
+	root := stdexpvar.Get("ktcp_test").(*stdexpvar.Map)
+	get := func(name, key string) string {
+		return root.Get(name).(*stdexpvar.Map).Get(key).String()
+	}
+	assert.Equal(t, "2", get("accepts", ":8080"))
+	assert.Equal(t, "3", get("rejects", ":8080,max_conns"))
+	assert.Equal(t, "1", get("sessions", ":8080"))
+	assert.Equal(t, "5", get("queue_depth", "batch"))
+	assert.Equal(t, "1", get("write_retries", "total"))
+
+	hist := root.Get("latency_seconds").(*stdexpvar.Map).Get("1").(*stdexpvar.Map)
+	assert.Equal(t, "2", hist.Get("count").String())
+	assert.Equal(t, "0.202", hist.Get("sum").String())
+	assert.Nil(t, hist.Get("le_0.001"))
+	assert.Equal(t, "1", hist.Get("le_0.005").String())
+	assert.Equal(t, "2", hist.Get("le_0.25").String())
+}
+
+func TestHistogram_WithoutLabels(t *testing.T) {
+	root := new(stdexpvar.Map)
+	h := NewHistogram(root, "h", []float64{1})
+	assert.Nil(t, root.Get("h").(*stdexpvar.Map).Get("total"))
+
+	h.Observe(0.5)
+	h.Observe(2)
+	hist := root.Get("h").(*stdexpvar.Map).Get("total").(*stdexpvar.Map)
+	assert.Equal(t, "2", hist.Get("count").String())
+	assert.Equal(t, "1", hist.Get("le_1").String())
+}
@@ -0,0 +1,96 @@
+// Package metrics defines the metrics of a ktcp server on the kratos metrics interfaces,
+// so the kratos Prometheus adapters fit them, e.g. a CounterVec with the labels of the metric:
+//
+//	Accepts: prom.NewCounter(prometheus.NewCounterVec(opts, []string{metrics.LabelListener}))
+//
+// See package expvar for an implementation on the standard library expvar.
+package metrics
+
+import (
+	"github.com/go-kratos/kratos/v2/metrics"
+)
+
+// The label names of the metrics, the label values are given in the order of the metric docs.
+const (
+	LabelListener = "listener"
+	LabelReason   = "reason"
+	LabelID       = "id" // the registered message id, OtherID for the others
+	LabelQueue    = "queue"
+)
+
+// OtherID is the id label value of the messages not registered in package msgtype,
+// so that the clients cannot grow the label values by sending arbitrary message ids.
+const OtherID = "other"
+
+// The queues of Metrics.QueueDepth.
+const (
+	// QueueHandlers is the running message handlers.
+	QueueHandlers = "handlers"
+	// QueueBatch is the frames pending in the write batches, see ktcp.WriteBatch.
+	QueueBatch = "batch"
+)
+
+// Metrics is the metrics of a server, the nil ones are not measured.
+type Metrics struct {
+	// Sessions is the gauge of the active sessions by listener.
+	Sessions metrics.Gauge
+	// Accepts counts the accepted connections by listener.
+	Accepts metrics.Counter
+	// Rejects counts the rejected connections by listener and reason.
+	Rejects metrics.Counter
+	// Closes counts the closed sessions by reason.
+	Closes metrics.Counter
+	// FramesIn counts the inbound frames by message id.
+	FramesIn metrics.Counter
+	// BytesIn counts the data bytes of the inbound frames by message id.
+	BytesIn metrics.Counter
+	// FramesOut counts the outbound frames by message id.
+	FramesOut metrics.Counter
+	// BytesOut counts the data bytes of the outbound frames by message id.
+	BytesOut metrics.Counter
+	// Latency observes the seconds the message handlers take by message id.
+	Latency metrics.Observer
+	// Errors counts the outbound messages with the error flag by message id.
+	Errors metrics.Counter
+	// QueueDepth is the gauge of the queue depths by queue.
+	QueueDepth metrics.Gauge
+	// WriteRetries counts the connection writes retried on temporary errors.
+	WriteRetries metrics.Counter
+}
+
+// Complete returns a copy of m whose nil metrics are no-ops.
+func (m Metrics) Complete() *Metrics {
+	for _, c := range []*metrics.Counter{&m.Accepts, &m.Rejects, &m.Closes, &m.FramesIn, &m.BytesIn,
+		&m.FramesOut, &m.BytesOut, &m.Errors, &m.WriteRetries} {
+		if *c == nil {
+			*c = nopCounter{}
+		}
+	}
+	for _, g := range []*metrics.Gauge{&m.Sessions, &m.QueueDepth} {
+		if *g == nil {
+			*g = nopGauge{}
+		}
+	}
+	if m.Latency == nil {
+		m.Latency = nopObserver{}
+	}
+	return &m
+}
+
+type nopCounter struct{}
+
+func (c nopCounter) With(...string) metrics.Counter { return c }
+func (nopCounter) Inc()                             {}
+func (nopCounter) Add(float64)                      {}
+
+type nopGauge struct{}
+
+func (g nopGauge) With(...string) metrics.Gauge { return g }
+func (nopGauge) Set(float64)                    {}
+func (nopGauge) Add(float64)                    {}
+func (nopGauge) Sub(float64)                    {}
+
+type nopObserver struct{}
+
+func (o nopObserver) With(...string) metrics.Observer { return o }
+func (nopObserver) Observe(float64)                   {}
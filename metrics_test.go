@@ -0,0 +1,61 @@
+package ktcp
+
+import (
+	stdexpvar "expvar"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/metrics"
+	"github.com/kwstars/ktcp/metrics/expvar"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	m := expvar.New("ktcp_server_test")
+	_, conn := serveTest(t, &burstHandler{n: 3, flush: true}, withRawCodec, Metrics(m), WriteBatch(time.Hour, 0))
+	require.NoError(t, packing.PackTo(conn, packing.NewDefaultPacker(), &message.Message{ID: 1}))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+	for i := 0; i < 3; i++ {
+		_, err := packing.NewDefaultPacker().Unpack(conn)
+		require.NoError(t, err)
+	}
+
+	root := stdexpvar.Get("ktcp_server_test").(*stdexpvar.Map)
+	get := func(name, key string) string {
+		if v := root.Get(name).(*stdexpvar.Map).Get(key); v != nil {
+			return v.String()
+		}
+		return ""
+	}
+	assert.Equal(t, "1", get("accepts", DefaultListenerName))
+	assert.Equal(t, "1", get("sessions", DefaultListenerName))
+	// the message ids are not registered.
+	assert.Equal(t, "1", get("frames_in", metrics.OtherID))
+	assert.Equal(t, "3", get("frames_out", metrics.OtherID))
+	assert.Equal(t, "", get("frames_out", "1"))
+	assert.Equal(t, "0", get("queue_depth", "batch"))
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return get("sessions", DefaultListenerName) == "0" && get("closes", CloseError.String()) == "1"
+	}, 3*time.Second, 10*time.Millisecond)
+	hist := root.Get("latency_seconds").(*stdexpvar.Map).Get(metrics.OtherID).(*stdexpvar.Map)
+	assert.Equal(t, "1", hist.Get("count").String())
+	assert.Equal(t, "0", get("queue_depth", "handlers"))
+}
+
+func TestIDLabel(t *testing.T) {
+	msgtype.Register(msgtype.Type{
+		ID:          1001,
+		Direction:   msgtype.Response,
+		MessageType: (*descriptorpb.DescriptorProto)(nil).ProtoReflect().Type(),
+	})
+	assert.Equal(t, "1001", idLabel(1001))
+	assert.Equal(t, metrics.OtherID, idLabel(1002))
+}
@@ -0,0 +1,214 @@
+// Package logging is a middleware writing the access logs of the requests handled by a ktcp server.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/kwstars/ktcp"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/kwstars/ktcp/packing"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Redacted replaces the redacted string fields in the payloads.
+const Redacted = "[REDACTED]"
+
+// Verbosity is what is logged for a request.
+type Verbosity int
+
+const (
+	// Off does not log the requests.
+	Off Verbosity = iota
+	// Summary logs the request without its payload.
+	Summary
+	// Payload logs the request with the JSON of its request and reply payloads.
+	Payload
+)
+
+// Option is a logging option.
+type Option func(*options)
+
+// Sampling logs the rate of the successful requests, 0 to 1, the failed requests are always logged.
+// All the requests are logged by default.
+func Sampling(rate float64) Option {
+	return func(o *options) {
+		o.sampling = rate
+	}
+}
+
+// DefaultVerbosity with the verbosity of the requests, Summary by default.
+func DefaultVerbosity(v Verbosity) Option {
+	return func(o *options) {
+		o.verbosity = v
+	}
+}
+
+// MessageVerbosity with the verbosity of the requests of the message ids.
+func MessageVerbosity(v Verbosity, ids ...uint32) Option {
+	return func(o *options) {
+		if o.messages == nil {
+			o.messages = make(map[uint32]Verbosity)
+		}
+		for _, id := range ids {
+			o.messages[id] = v
+		}
+	}
+}
+
+// Redact redacts the fields of names in the payloads, in addition to the fields with the debug_redact option.
+func Redact(names ...string) Option {
+	return func(o *options) {
+		if o.redact == nil {
+			o.redact = make(map[protoreflect.Name]bool)
+		}
+		for _, name := range names {
+			o.redact[protoreflect.Name(name)] = true
+		}
+	}
+}
+
+type options struct {
+	sampling  float64
+	verbosity Verbosity
+	messages  map[uint32]Verbosity
+	redact    map[protoreflect.Name]bool
+}
+
+func (o *options) verbosityOf(id uint32) Verbosity {
+	if v, ok := o.messages[id]; ok {
+		return v
+	}
+	return o.verbosity
+}
+
+// Server is a server logging middleware, it logs the session, the message, the sizes of the request
+// and reply, the latency and the error of every request. The reply size is the data size of the
+// response frame, it is logged if the response is sent before the middleware returns.
+func Server(logger log.Logger, opts ...Option) middleware.Middleware {
+	o := &options{sampling: 1, verbosity: Summary}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
+			c, ok := ktcp.FromContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+			id := c.GetReqMsg().ID
+			verbosity := o.verbosityOf(id)
+			if verbosity == Off {
+				return handler(ctx, req)
+			}
+			reqSize := len(c.GetReqMsg().Data)
+			start := time.Now()
+
+			reply, err = handler(ctx, req)
+			if err == nil && o.sampling < 1 && rand.Float64() >= o.sampling {
+				return
+			}
+
+			sess := c.GetSession()
+			kv := []interface{}{
+				"kind", "server",
+				"component", "ktcp",
+				"session", sess.ID(),
+				"remote", sess.RemoteAddr().String(),
+				"msg_id", id,
+			}
+			if t, ok := msgtype.Lookup(id); ok {
+				kv = append(kv, "msg_name", string(t.MessageType.Descriptor().FullName()))
+				if t.Service != "" {
+					kv = append(kv, "operation", "/"+t.Service+"/"+t.Method)
+				}
+			}
+			kv = append(kv, "req_size", reqSize)
+			if resp := c.Response(); resp != nil {
+				kv = append(kv, "resp_size", len(resp.Data))
+			}
+			kv = append(kv, "latency", time.Since(start).Seconds())
+			level := log.LevelInfo
+			if err != nil {
+				se := errors.FromError(err)
+				kv = append(kv, "flag", packing.ErrType, "code", se.Code, "reason", se.Reason)
+				level = log.LevelError
+			} else {
+				kv = append(kv, "flag", packing.OKType)
+			}
+			if verbosity == Payload {
+				kv = append(kv, "req", o.render(req), "reply", o.render(reply))
+			}
+			_ = log.WithContext(ctx, logger).Log(level, kv...)
+			return
+		}
+	}
+}
+
+// render returns the JSON of the payload v with its redacted fields.
+func (o *options) render(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	m, ok := v.(protobuf.Message)
+	if !ok {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+	m = protobuf.Clone(m)
+	o.redactMessage(m.ProtoReflect())
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func (o *options) redactMessage(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if o.redacted(fd) {
+			if fd.Kind() == protoreflect.StringKind && fd.Cardinality() != protoreflect.Repeated {
+				m.Set(fd, protoreflect.ValueOfString(Redacted))
+			} else {
+				m.Clear(fd)
+			}
+			return true
+		}
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+					o.redactMessage(v.Message())
+					return true
+				})
+			}
+		case fd.Message() == nil:
+		case fd.IsList():
+			for i, list := 0, v.List(); i < list.Len(); i++ {
+				o.redactMessage(list.Get(i).Message())
+			}
+		default:
+			o.redactMessage(v.Message())
+		}
+		return true
+	})
+}
+
+func (o *options) redacted(fd protoreflect.FieldDescriptor) bool {
+	if o.redact[fd.Name()] {
+		return true
+	}
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	return ok && opts.GetDebugRedact()
+}
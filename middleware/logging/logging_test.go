@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/kwstars/ktcp"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	echoID = 100 + iota
+	failID
+	quietID
+)
+
+// entry is a logged line.
+type entry struct {
+	level log.Level
+	kv    map[interface{}]interface{}
+}
+
+type logger chan entry
+
+func (l logger) Log(level log.Level, keyvals ...interface{}) error {
+	kv := make(map[interface{}]interface{})
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		kv[keyvals[i]] = keyvals[i+1]
+	}
+	l <- entry{level: level, kv: kv}
+	return nil
+}
+
+// handler replies the request through the middlewares, the requests of failID fail.
+type handler struct{}
+
+func (handler) OnConnect(*ktcp.Session) {}
+
+func (handler) OnMessage(c ktcp.Context) {
+	var in testData.TestModel
+	if err := c.Bind(&in); err != nil {
+		return
+	}
+	h := c.Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		if c.GetReqMsg().ID == failID {
+			return nil, errors.BadRequest("INVALID_NAME", "invalid name")
+		}
+		return req, c.Send(c.GetReqMsg().ID+1, req)
+	})
+	if _, err := h(c, &in); err != nil {
+		_ = c.SendError(c.GetReqMsg().ID+1, errors.FromError(err))
+	}
+}
+
+func (handler) OnClose(*ktcp.Session) {}
+
+func serve(t *testing.T, l logger, opts ...Option) *ktcp.Client {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := ktcp.NewServer(handler{}, ktcp.Listener(lis), ktcp.Middleware(Server(l, opts...)))
+	go func() { _ = srv.Serve() }()
+	t.Cleanup(func() { _ = srv.Stop(context.Background()) })
+
+	c, err := ktcp.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func next(t *testing.T, l logger) entry {
+	t.Helper()
+	select {
+	case e := <-l:
+		return e
+	case <-time.After(3 * time.Second):
+		t.Fatal("no log")
+		return entry{}
+	}
+}
+
+func TestServer(t *testing.T) {
+	msgtype.Register(msgtype.Type{
+		ID:          echoID,
+		Direction:   msgtype.Request,
+		Service:     "test.Echo",
+		Method:      "Echo",
+		MessageType: (*testData.TestModel)(nil).ProtoReflect().Type(),
+	})
+	l := make(logger, 1)
+	c := serve(t, l, MessageVerbosity(Payload, echoID), MessageVerbosity(Off, quietID), Redact("name"))
+
+	require.NoError(t, c.Send(quietID, &testData.TestModel{}))
+	require.NoError(t, c.Send(echoID, &testData.TestModel{Id: 1, Name: "secret", Hobby: []string{"go"}}))
+	e := next(t, l)
+	assert.Equal(t, log.LevelInfo, e.level)
+	assert.Equal(t, uint32(echoID), e.kv["msg_id"])
+	assert.Equal(t, "test.test_model", e.kv["msg_name"])
+	assert.Equal(t, "/test.Echo/Echo", e.kv["operation"])
+	assert.Greater(t, e.kv["req_size"], 0)
+	assert.Equal(t, e.kv["req_size"], e.kv["resp_size"])
+	assert.Contains(t, e.kv["req"], Redacted)
+	assert.NotContains(t, e.kv["req"], "secret")
+	assert.Contains(t, e.kv["reply"], "go")
+
+	require.NoError(t, c.Send(failID, &testData.TestModel{}))
+	e = next(t, l)
+	assert.Equal(t, log.LevelError, e.level)
+	assert.Equal(t, "INVALID_NAME", e.kv["reason"])
+	assert.Equal(t, int32(400), e.kv["code"])
+	assert.Nil(t, e.kv["req"])
+	// the error is sent once the middleware returns.
+	assert.NotContains(t, e.kv, "resp_size")
+}
+
+func TestServer_Sampling(t *testing.T) {
+	l := make(logger, 1)
+	c := serve(t, l, Sampling(0))
+
+	// the successful requests are not sampled, the failed ones are logged.
+	require.NoError(t, c.Send(echoID, &testData.TestModel{}))
+	require.NoError(t, c.Send(failID, &testData.TestModel{}))
+	assert.Equal(t, "INVALID_NAME", next(t, l).kv["reason"])
+}
+
+func TestRender_Redact(t *testing.T) {
+	o := &options{}
+	Redact("name")(o)
+	out := o.render(&descriptorpb.DescriptorProto{
+		Name:  ptr("M"),
+		Field: []*descriptorpb.FieldDescriptorProto{{Name: ptr("f"), JsonName: ptr("j")}},
+	})
+	assert.NotContains(t, out, `"M"`)
+	assert.NotContains(t, out, `"f"`)
+	assert.Contains(t, out, `"j"`)
+	assert.Equal(t, `{"a":1}`, o.render(map[string]int{"a": 1}))
+}
+
+func ptr(s string) *string {
+	return &s
+}
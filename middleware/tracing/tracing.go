@@ -0,0 +1,155 @@
+// Package tracing is a middleware tracing the requests of a ktcp server and client by OpenTelemetry.
+//
+// The spans are named after the operation of the ktcp.Transporter of the request, the /service/method
+// of the generated code, and the trace context is propagated in the message header. The kratos
+// tracing middleware works with the ktcp.Transporter as well, without the ktcp attributes.
+package tracing
+
+import (
+	"context"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/kwstars/ktcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+// The attributes of the spans.
+const (
+	AttrMessageID   = attribute.Key("ktcp.message_id")
+	AttrSession     = attribute.Key("ktcp.session")
+	AttrRequestSize = attribute.Key("ktcp.request_size")
+	AttrReplySize   = attribute.Key("ktcp.reply_size")
+	AttrCode        = attribute.Key("ktcp.code")
+	AttrReason      = attribute.Key("ktcp.reason")
+)
+
+const defaultTracerName = "github.com/kwstars/ktcp"
+
+// Option is a tracing option.
+type Option func(*options)
+
+// WithTracerProvider with the tracer provider, the global one by default, see otel.SetTracerProvider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.provider = provider
+	}
+}
+
+// WithPropagator with the propagator of the trace context, the W3C trace context and baggage by default.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(o *options) {
+		o.propagator = propagator
+	}
+}
+
+// WithTracerName with the tracer name, the ktcp module path by default.
+func WithTracerName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+type options struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+	name       string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+		name:       defaultTracerName,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.provider == nil {
+		o.provider = otel.GetTracerProvider()
+	}
+	return o
+}
+
+// Server starts a server span for the handled requests, its parent is the trace context of the request header.
+func Server(opts ...Option) middleware.Middleware {
+	o := newOptions(opts)
+	tracer := o.provider.Tracer(o.name)
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+			ctx = o.propagator.Extract(ctx, tr.RequestHeader())
+			ctx, span := tracer.Start(ctx, tr.Operation(), trace.WithSpanKind(trace.SpanKindServer))
+			defer span.End()
+
+			attrs := []attribute.KeyValue{attribute.String("rpc.system", tr.Kind().String())}
+			if t, ok := tr.(ktcp.Transporter); ok {
+				attrs = append(attrs, AttrMessageID.Int64(int64(t.MessageID())))
+			}
+			if c, ok := ktcp.FromContext(ctx); ok {
+				attrs = append(attrs,
+					AttrSession.String(c.GetSession().ID()),
+					AttrRequestSize.Int(len(c.GetReqMsg().Data)),
+				)
+			}
+			span.SetAttributes(attrs...)
+
+			reply, err = handler(ctx, req)
+			setResult(span, reply, err)
+			return
+		}
+	}
+}
+
+// Client starts a client span for the sent requests, its trace context is injected into the request header.
+func Client(opts ...Option) middleware.Middleware {
+	o := newOptions(opts)
+	tracer := o.provider.Tracer(o.name)
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
+			tr, ok := transport.FromClientContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+			ctx, span := tracer.Start(ctx, tr.Operation(), trace.WithSpanKind(trace.SpanKindClient))
+			defer span.End()
+			o.propagator.Inject(ctx, tr.RequestHeader())
+
+			attrs := []attribute.KeyValue{attribute.String("rpc.system", tr.Kind().String())}
+			if t, ok := tr.(ktcp.Transporter); ok {
+				attrs = append(attrs, AttrMessageID.Int64(int64(t.MessageID())))
+			}
+			if m, ok := req.(proto.Message); ok {
+				attrs = append(attrs, AttrRequestSize.Int(proto.Size(m)))
+			}
+			span.SetAttributes(attrs...)
+
+			reply, err = handler(ctx, req)
+			setResult(span, reply, err)
+			return
+		}
+	}
+}
+
+// setResult sets the result of the request to span.
+func setResult(span trace.Span, reply interface{}, err error) {
+	if err != nil {
+		e := errors.FromError(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, e.Message)
+		span.SetAttributes(AttrCode.Int64(int64(e.Code)), AttrReason.String(e.Reason))
+		return
+	}
+	if m, ok := reply.(proto.Message); ok {
+		span.SetAttributes(AttrReplySize.Int(proto.Size(m)))
+	}
+	span.SetStatus(codes.Ok, "")
+}
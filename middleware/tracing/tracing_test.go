@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	kratostracing "github.com/go-kratos/kratos/v2/middleware/tracing"
+	"github.com/kwstars/ktcp"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	echoID = 200 + iota
+	failID
+)
+
+// handler replies the request through the middlewares, the requests of failID fail.
+type handler struct{}
+
+func (handler) OnConnect(*ktcp.Session) {}
+
+func (handler) OnMessage(c ktcp.Context) {
+	var in testData.TestModel
+	if err := c.Bind(&in); err != nil {
+		return
+	}
+	h := c.Middleware(func(ctx context.Context, req interface{}) (interface{}, error) {
+		if c.GetReqMsg().ID == failID {
+			return nil, errors.BadRequest("INVALID_NAME", "invalid name")
+		}
+		return req, nil
+	})
+	out, err := h(c, &in)
+	if err != nil {
+		_ = c.SendError(c.GetReqMsg().ID, errors.FromError(err))
+		return
+	}
+	_ = c.Send(c.GetReqMsg().ID, out)
+}
+
+func (handler) OnClose(*ktcp.Session) {}
+
+func serve(t *testing.T, server middleware.Middleware, opts ...ktcp.ClientOption) *ktcp.Client {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := ktcp.NewServer(handler{}, ktcp.Listener(lis), ktcp.Middleware(server))
+	go func() { _ = srv.Serve() }()
+	t.Cleanup(func() { _ = srv.Stop(context.Background()) })
+
+	c, err := ktcp.Dial("tcp", lis.Addr().String(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func newProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+// spans waits for n spans of exporter.
+func spans(t *testing.T, exporter *tracetest.InMemoryExporter, n int) tracetest.SpanStubs {
+	t.Helper()
+	require.Eventually(t, func() bool { return len(exporter.GetSpans()) >= n }, 3*time.Second, 10*time.Millisecond)
+	return exporter.GetSpans()
+}
+
+func attrs(s tracetest.SpanStub) map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, kv := range s.Attributes {
+		m[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	return m
+}
+
+func TestTracing(t *testing.T) {
+	msgtype.Register(msgtype.Type{
+		ID:          echoID,
+		Direction:   msgtype.Request,
+		Service:     "test.Echo",
+		Method:      "Echo",
+		MessageType: (*testData.TestModel)(nil).ProtoReflect().Type(),
+	})
+	provider, exporter := newProvider()
+	c := serve(t, Server(WithTracerProvider(provider)), ktcp.ClientMiddleware(Client(WithTracerProvider(provider))))
+
+	require.NoError(t, c.SendContext(context.Background(), echoID, &testData.TestModel{Id: 1, Name: "go"}))
+	got := spans(t, exporter, 2)
+	client, server := got[0], got[1]
+	if client.SpanKind != trace.SpanKindClient {
+		client, server = server, client
+	}
+	assert.Equal(t, "/test.Echo/Echo", client.Name)
+	assert.Equal(t, "/test.Echo/Echo", server.Name)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, client.SpanContext.TraceID(), server.SpanContext.TraceID())
+	assert.Equal(t, client.SpanContext.SpanID(), server.Parent.SpanID())
+
+	a := attrs(server)
+	assert.Equal(t, "tcp", a["rpc.system"])
+	assert.Equal(t, int64(echoID), a[string(AttrMessageID)])
+	assert.NotEmpty(t, a[string(AttrSession)])
+	assert.Greater(t, a[string(AttrRequestSize)], int64(0))
+	assert.Equal(t, a[string(AttrRequestSize)], a[string(AttrReplySize)])
+	assert.Equal(t, codes.Ok, server.Status.Code)
+	assert.Equal(t, int64(echoID), attrs(client)[string(AttrMessageID)])
+}
+
+func TestServer_Error(t *testing.T) {
+	provider, exporter := newProvider()
+	c := serve(t, Server(WithTracerProvider(provider)))
+
+	require.NoError(t, c.Send(failID, &testData.TestModel{}))
+	server := spans(t, exporter, 1)[0]
+	assert.Equal(t, "201", server.Name)
+	assert.False(t, server.Parent.IsValid())
+	assert.Equal(t, codes.Error, server.Status.Code)
+	a := attrs(server)
+	assert.Equal(t, int64(400), a[string(AttrCode)])
+	assert.Equal(t, "INVALID_NAME", a[string(AttrReason)])
+}
+
+func TestKratosTracing(t *testing.T) {
+	provider, exporter := newProvider()
+	c := serve(t, kratostracing.Server(kratostracing.WithTracerProvider(provider)),
+		ktcp.ClientMiddleware(kratostracing.Client(kratostracing.WithTracerProvider(provider))))
+
+	require.NoError(t, c.SendContext(context.Background(), echoID, &testData.TestModel{}))
+	got := spans(t, exporter, 2)
+	assert.Equal(t, got[0].SpanContext.TraceID(), got[1].SpanContext.TraceID())
+	assert.True(t, got[0].Parent.IsValid() || got[1].Parent.IsValid())
+}
@@ -0,0 +1,138 @@
+// Package msgtype is the registry of the protobuf message types of the message ids, filled by the
+// code generated by protoc-gen-go-ktcp.
+//
+// It decodes the payloads of the message ids dynamically, e.g. to log them or to decode the traffic
+// captures, and validates the type of the messages sent.
+package msgtype
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/kwstars/ktcp/encoding"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrUnknown is returned when the message id is not registered.
+var ErrUnknown = errors.New("msgtype: unknown message id")
+
+// Direction is the direction of a message.
+type Direction uint8
+
+const (
+	// Request is a message sent by the client.
+	Request Direction = iota + 1
+	// Response is a message sent by the server.
+	Response
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Request:
+		return "request"
+	case Response:
+		return "response"
+	default:
+		return "unknown"
+	}
+}
+
+// Type is the message type of a message id.
+type Type struct {
+	ID          uint32
+	Direction   Direction
+	Service     string // full name of the owning service, e.g. helloworld.Greeter
+	Method      string
+	MessageType protoreflect.MessageType
+}
+
+var (
+	mu    sync.RWMutex
+	types = make(map[uint32]Type)
+)
+
+// Register registers the message types, it panics if an id is already registered
+// with another message type or direction.
+func Register(ts ...Type) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, t := range ts {
+		if t.MessageType == nil {
+			panic(fmt.Sprintf("msgtype: message id %d registered without a message type", t.ID))
+		}
+		if r, ok := types[t.ID]; ok {
+			if r.MessageType.Descriptor().FullName() != t.MessageType.Descriptor().FullName() || r.Direction != t.Direction {
+				panic(fmt.Sprintf("msgtype: message id %d registered as %s %s and %s %s", t.ID,
+					r.Direction, r.MessageType.Descriptor().FullName(), t.Direction, t.MessageType.Descriptor().FullName()))
+			}
+			continue
+		}
+		types[t.ID] = t
+	}
+}
+
+// Lookup returns the message type of id.
+func Lookup(id uint32) (Type, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := types[id]
+	return t, ok
+}
+
+// Range calls f with the registered types in id order until f returns false.
+func Range(f func(t Type) bool) {
+	mu.RLock()
+	ts := make([]Type, 0, len(types))
+	for _, t := range types {
+		ts = append(ts, t)
+	}
+	mu.RUnlock()
+	sort.Slice(ts, func(i, j int) bool { return ts[i].ID < ts[j].ID })
+	for _, t := range ts {
+		if !f(t) {
+			return
+		}
+	}
+}
+
+// New returns a new message of the type of id.
+func New(id uint32) (proto.Message, error) {
+	t, ok := Lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknown, id)
+	}
+	return t.MessageType.New().Interface(), nil
+}
+
+// Decode unmarshals data by codec into a new message of the type of id.
+func Decode(codec encoding.Codec, id uint32, data []byte) (proto.Message, error) {
+	m, err := New(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := codec.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate returns an error if v is not a message of the type of id,
+// nil if id is not registered.
+func Validate(id uint32, v interface{}) error {
+	t, ok := Lookup(id)
+	if !ok {
+		return nil
+	}
+	want := t.MessageType.Descriptor().FullName()
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("msgtype: message id %d is %s, got %T", id, want, v)
+	}
+	if got := m.ProtoReflect().Descriptor().FullName(); got != want {
+		return fmt.Errorf("msgtype: message id %d is %s, got %s", id, want, got)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package msgtype
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kwstars/ktcp/encoding/proto"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	modelType      = (*testData.TestModel)(nil).ProtoReflect().Type()
+	descriptorType = (*descriptorpb.DescriptorProto)(nil).ProtoReflect().Type()
+)
+
+func TestRegister(t *testing.T) {
+	Register(
+		Type{ID: 1, Direction: Request, Service: "test.Service", Method: "Get", MessageType: modelType},
+		Type{ID: 2, Direction: Response, Service: "test.Service", Method: "Get", MessageType: descriptorType},
+	)
+	// registering the same type again is a no-op.
+	Register(Type{ID: 1, Direction: Request, Service: "test.Service", Method: "Get", MessageType: modelType})
+
+	got, ok := Lookup(1)
+	require.True(t, ok)
+	assert.Equal(t, Request, got.Direction)
+	assert.Equal(t, "test.Service", got.Service)
+	_, ok = Lookup(3)
+	assert.False(t, ok)
+
+	var ids []uint32
+	Range(func(t Type) bool {
+		ids = append(ids, t.ID)
+		return true
+	})
+	assert.Equal(t, []uint32{1, 2}, ids)
+
+	assert.Panics(t, func() { Register(Type{ID: 1, Direction: Request, MessageType: descriptorType}) })
+	assert.Panics(t, func() { Register(Type{ID: 1, Direction: Response, MessageType: modelType}) })
+	assert.Panics(t, func() { Register(Type{ID: 9}) })
+}
+
+func TestDecodeValidate(t *testing.T) {
+	Register(Type{ID: 10, Direction: Request, MessageType: modelType})
+	codec := proto.New()
+	data, err := codec.Marshal(&testData.TestModel{Id: 1, Name: "ktcp"})
+	require.NoError(t, err)
+
+	m, err := Decode(codec, 10, data)
+	require.NoError(t, err)
+	assert.Equal(t, "ktcp", m.(*testData.TestModel).Name)
+	_, err = Decode(codec, 11, data)
+	assert.True(t, errors.Is(err, ErrUnknown))
+
+	assert.NoError(t, Validate(10, &testData.TestModel{}))
+	assert.Error(t, Validate(10, &descriptorpb.DescriptorProto{}))
+	assert.Error(t, Validate(10, "ktcp"))
+	assert.NoError(t, Validate(11, "ktcp"))
+}
@@ -0,0 +1,219 @@
+// Package negotiate implements the connect-time handshake negotiating the protocol version and the
+// capabilities of a session, before the other handshakes of the listener:
+//
+//	client -> server: hello frame, the client protocol version and capabilities
+//	server -> client: result frame, or an error frame if the server rejects the client
+//
+// The frames are packed by the listener packer with the id MessageID, their data is JSON.
+// The server selects the capabilities in its order of preference among the ones of the client,
+// encryption and sequence numbers are used if both sides support them.
+package negotiate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+const (
+	// Version is the current protocol version.
+	Version uint16 = 1
+	// MessageID is the message id of the handshake frames.
+	MessageID uint32 = 0
+)
+
+// The reasons of the rejections.
+const (
+	ReasonInvalidHello       = "INVALID_HELLO"
+	ReasonUnsupportedVersion = "UNSUPPORTED_VERSION"
+	ReasonUnsupportedCodec   = "UNSUPPORTED_CODEC"
+	ReasonEncryptionRequired = "ENCRYPTION_REQUIRED"
+	ReasonSequenceRequired   = "SEQUENCE_REQUIRED"
+)
+
+// Capabilities is what a side of a connection supports.
+type Capabilities struct {
+	// Compressors by name, see package compress.
+	Compressors []string `json:"compressors,omitempty"`
+	// Codecs by name, see package encoding.
+	Codecs []string `json:"codecs,omitempty"`
+	// Encryption of the message data, see package secure.
+	Encryption bool `json:"encryption,omitempty"`
+	// Sequence numbers and checksums of the frames, see package integrity.
+	Sequence bool `json:"sequence,omitempty"`
+}
+
+// Hello is the protocol version and the capabilities of a client.
+type Hello struct {
+	Version uint16 `json:"version"`
+	Capabilities
+}
+
+// Result is the protocol version and the capabilities selected by the server.
+type Result struct {
+	Version    uint16 `json:"version"`
+	Compressor string `json:"compressor,omitempty"` // empty if the outbound frames are not compressed
+	Codec      string `json:"codec"`
+	Encryption bool   `json:"encryption,omitempty"`
+	Sequence   bool   `json:"sequence,omitempty"`
+}
+
+// RejectError is the error of a handshake rejected by the server.
+type RejectError struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("negotiate: rejected %s: %s", e.Reason, e.Message)
+}
+
+// Config is the negotiation config of a listener.
+type Config struct {
+	// MinVersion is the oldest protocol version accepted, 1 if zero.
+	MinVersion uint16
+	// MaxVersion is the newest protocol version, Version if zero.
+	MaxVersion uint16
+	// Codecs offered besides the listener codec by name in order of preference, see encoding.GetCodec.
+	Codecs []string
+	// RequireEncryption rejects the clients without encryption if the listener encrypts.
+	RequireEncryption bool
+	// RequireSequence rejects the clients without sequence numbers if the listener has an integrity trailer.
+	RequireSequence bool
+	// Timeout bounds the handshake, zero means no timeout.
+	Timeout time.Duration
+}
+
+// Select returns the result of hello against the capabilities of the server in order of preference,
+// a *RejectError if they are not compatible.
+func (c *Config) Select(hello *Hello, server Capabilities) (*Result, error) {
+	minVersion, maxVersion := c.MinVersion, c.MaxVersion
+	if minVersion == 0 {
+		minVersion = 1
+	}
+	if maxVersion == 0 {
+		maxVersion = Version
+	}
+	if hello.Version < minVersion {
+		return nil, &RejectError{
+			Reason:  ReasonUnsupportedVersion,
+			Message: fmt.Sprintf("version %d is older than %d", hello.Version, minVersion),
+		}
+	}
+
+	r := &Result{
+		Version:    hello.Version,
+		Compressor: first(server.Compressors, hello.Compressors),
+		Codec:      first(server.Codecs, hello.Codecs),
+		Encryption: server.Encryption && hello.Encryption,
+		Sequence:   server.Sequence && hello.Sequence,
+	}
+	if r.Version > maxVersion {
+		r.Version = maxVersion
+	}
+	if r.Codec == "" {
+		return nil, &RejectError{
+			Reason:  ReasonUnsupportedCodec,
+			Message: fmt.Sprintf("none of the codecs %v is supported, want one of %v", hello.Codecs, server.Codecs),
+		}
+	}
+	if server.Encryption && c.RequireEncryption && !r.Encryption {
+		return nil, &RejectError{Reason: ReasonEncryptionRequired, Message: "the encryption is required"}
+	}
+	if server.Sequence && c.RequireSequence && !r.Sequence {
+		return nil, &RejectError{Reason: ReasonSequenceRequired, Message: "the sequence numbers are required"}
+	}
+	return r, nil
+}
+
+// first returns the first of names which is in the peer names.
+func first(names, peer []string) string {
+	for _, name := range names {
+		for _, p := range peer {
+			if name == p {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// ServerHandshake reads the hello of the client from rw and writes the result selected by conf,
+// or the error frame if the client is rejected. The returned error is a *RejectError in the latter case.
+func ServerHandshake(rw io.ReadWriter, p packing.Packer, conf *Config, server Capabilities) (*Result, error) {
+	msg, err := p.Unpack(rw)
+	if err != nil {
+		return nil, fmt.Errorf("read hello err: %s", err)
+	}
+	var hello Hello
+	if msg.ID != MessageID {
+		err = fmt.Errorf("unexpected message id %d", msg.ID)
+	} else {
+		err = json.Unmarshal(msg.Data, &hello)
+	}
+	msg.Release()
+
+	var r *Result
+	if err != nil {
+		err = &RejectError{Reason: ReasonInvalidHello, Message: err.Error()}
+	} else {
+		r, err = conf.Select(&hello, server)
+	}
+	if err != nil {
+		if werr := writeFrame(rw, p, packing.ErrType, err); werr != nil {
+			return nil, werr
+		}
+		return nil, err
+	}
+	if err := writeFrame(rw, p, packing.OKType, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ClientHandshake writes hello to rw and reads the result selected by the server.
+// The returned error is a *RejectError if the server rejects the client.
+func ClientHandshake(rw io.ReadWriter, p packing.Packer, hello *Hello) (*Result, error) {
+	if err := writeFrame(rw, p, packing.OKType, hello); err != nil {
+		return nil, err
+	}
+	msg, err := p.Unpack(rw)
+	if err != nil {
+		return nil, fmt.Errorf("read result err: %s", err)
+	}
+	defer msg.Release()
+	if msg.ID != MessageID {
+		return nil, fmt.Errorf("unexpected message id %d", msg.ID)
+	}
+	if packing.MessageType(msg.Flag) == packing.ErrType {
+		var rejected RejectError
+		if err := json.Unmarshal(msg.Data, &rejected); err != nil {
+			return nil, fmt.Errorf("invalid error frame: %s", err)
+		}
+		return nil, &rejected
+	}
+	var r Result
+	if err := json.Unmarshal(msg.Data, &r); err != nil {
+		return nil, fmt.Errorf("invalid result frame: %s", err)
+	}
+	return &r, nil
+}
+
+func writeFrame(w io.Writer, p packing.Packer, flag uint16, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b, err := p.Pack(&message.Message{ID: MessageID, Flag: flag, Data: data})
+	if err != nil {
+		return fmt.Errorf("pack handshake frame err: %s", err)
+	}
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("write handshake frame err: %s", err)
+	}
+	return nil
+}
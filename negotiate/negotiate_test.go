@@ -0,0 +1,103 @@
+package negotiate
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var server = Capabilities{
+	Compressors: []string{"gzip", "deflate"},
+	Codecs:      []string{"proto", "json"},
+	Encryption:  true,
+	Sequence:    true,
+}
+
+func TestConfig_Select(t *testing.T) {
+	conf := &Config{}
+	r, err := conf.Select(&Hello{Version: 3, Capabilities: Capabilities{
+		Compressors: []string{"deflate", "gzip"},
+		Codecs:      []string{"json"},
+		Encryption:  true,
+	}}, server)
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Version: Version, Compressor: "gzip", Codec: "json", Encryption: true}, r)
+
+	// the capabilities are optional but the codec.
+	r, err = conf.Select(&Hello{Version: 1, Capabilities: Capabilities{Codecs: []string{"proto"}}}, server)
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Version: 1, Codec: "proto"}, r)
+}
+
+func TestConfig_SelectRejected(t *testing.T) {
+	for name, c := range map[string]struct {
+		conf   Config
+		hello  Hello
+		reason string
+	}{
+		"version": {
+			conf:   Config{MinVersion: 2, MaxVersion: 3},
+			hello:  Hello{Version: 1, Capabilities: Capabilities{Codecs: []string{"proto"}}},
+			reason: ReasonUnsupportedVersion,
+		},
+		"codec": {
+			hello:  Hello{Version: 1, Capabilities: Capabilities{Codecs: []string{"xml"}}},
+			reason: ReasonUnsupportedCodec,
+		},
+		"encryption": {
+			conf:   Config{RequireEncryption: true},
+			hello:  Hello{Version: 1, Capabilities: Capabilities{Codecs: []string{"proto"}, Sequence: true}},
+			reason: ReasonEncryptionRequired,
+		},
+		"sequence": {
+			conf:   Config{RequireSequence: true},
+			hello:  Hello{Version: 1, Capabilities: Capabilities{Codecs: []string{"proto"}, Encryption: true}},
+			reason: ReasonSequenceRequired,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.conf.Select(&c.hello, server)
+			var rejected *RejectError
+			require.True(t, errors.As(err, &rejected))
+			assert.Equal(t, c.reason, rejected.Reason)
+		})
+	}
+}
+
+// handshake runs both sides of the handshake of hello.
+func handshake(t *testing.T, conf *Config, hello *Hello) (client, server *Result, clientErr, serverErr error) {
+	t.Helper()
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		server, err = ServerHandshake(s, packing.NewDefaultPacker(), conf, Capabilities{Codecs: []string{"proto"}})
+		errCh <- err
+	}()
+	client, clientErr = ClientHandshake(c, packing.NewDefaultPacker(), hello)
+	serverErr = <-errCh
+	return
+}
+
+func TestHandshake(t *testing.T) {
+	client, server, err, serverErr := handshake(t, &Config{}, &Hello{Version: Version, Capabilities: Capabilities{Codecs: []string{"proto"}}})
+	require.NoError(t, err)
+	require.NoError(t, serverErr)
+	assert.Equal(t, server, client)
+	assert.Equal(t, "proto", client.Codec)
+}
+
+func TestHandshake_Rejected(t *testing.T) {
+	_, _, err, serverErr := handshake(t, &Config{MinVersion: 2}, &Hello{Version: 1, Capabilities: Capabilities{Codecs: []string{"proto"}}})
+	var rejected *RejectError
+	require.True(t, errors.As(err, &rejected))
+	assert.Equal(t, ReasonUnsupportedVersion, rejected.Reason)
+	assert.Equal(t, serverErr, err)
+}
@@ -0,0 +1,72 @@
+package ktcp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/encoding/json"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/negotiate"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/kwstars/ktcp/secure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Negotiation(t *testing.T) {
+	_, conn := serveTest(t, &echoHandler{}, Negotiation(&negotiate.Config{Codecs: []string{json.Name}}), Encryption(&secure.Config{}))
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+	r, err := negotiate.ClientHandshake(conn, packing.NewDefaultPacker(), &negotiate.Hello{
+		Version:      negotiate.Version,
+		Capabilities: negotiate.Capabilities{Codecs: []string{json.Name}, Encryption: true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &negotiate.Result{Version: negotiate.Version, Codec: json.Name, Encryption: true}, r)
+
+	// the session is encrypted and uses the json codec.
+	packer, err := secure.ClientHandshake(conn, packing.NewDefaultPacker())
+	require.NoError(t, err)
+	codec := encoding.GetCodec(json.Name)
+	data, err := codec.Marshal(&testData.TestModel{Name: "ktcp"})
+	require.NoError(t, err)
+	require.NoError(t, packing.PackTo(conn, packer, &message.Message{ID: 1, Data: data}))
+	msg, err := packer.Unpack(conn)
+	require.NoError(t, err)
+	var out testData.TestModel
+	require.NoError(t, codec.Unmarshal(msg.Data, &out))
+	assert.Equal(t, "ktcp", out.Name)
+}
+
+func TestServer_NegotiationWithoutEncryption(t *testing.T) {
+	_, conn := serveTest(t, &echoHandler{}, Negotiation(&negotiate.Config{}), Encryption(&secure.Config{}))
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+	r, err := negotiate.ClientHandshake(conn, packing.NewDefaultPacker(), &negotiate.Hello{
+		Version:      negotiate.Version,
+		Capabilities: negotiate.Capabilities{Codecs: []string{"proto"}},
+	})
+	require.NoError(t, err)
+	assert.False(t, r.Encryption)
+
+	msg, out := roundTrip(t, conn, 1, &testData.TestModel{Name: "ktcp"})
+	assert.Equal(t, uint32(2), msg.ID)
+	assert.Equal(t, "ktcp", out.Name)
+}
+
+func TestServer_NegotiationRejected(t *testing.T) {
+	srv, conn := serveTest(t, &echoHandler{}, Negotiation(&negotiate.Config{RequireEncryption: true}), Encryption(&secure.Config{}))
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+	_, err := negotiate.ClientHandshake(conn, packing.NewDefaultPacker(), &negotiate.Hello{
+		Version:      negotiate.Version,
+		Capabilities: negotiate.Capabilities{Codecs: []string{"proto"}},
+	})
+	var rejected *negotiate.RejectError
+	require.True(t, errors.As(err, &rejected))
+	assert.Equal(t, negotiate.ReasonEncryptionRequired, rejected.Reason)
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), srv.Closed(CloseHandshake))
+}
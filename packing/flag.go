@@ -0,0 +1,49 @@
+package packing
+
+// The bits of Message.Flag.
+// The low bits are the message type, OKType or ErrType, the high bits mark how the data is encoded.
+const (
+	// TypeMask is the bits of the message type.
+	TypeMask uint16 = 0x000F
+	// FlagCompressed marks the compressed data, the code of the compressor is in CompressorMask.
+	FlagCompressed uint16 = 1 << 15
+	// CompressorMask is the bits of the compressor code.
+	CompressorMask uint16 = 0x7 << compressorShift
+	// FlagFragment marks a fragment of a large message, the data starts with a fragment header.
+	FlagFragment uint16 = 1 << 11
+	// FlagStream marks a frame of a stream, the data starts with the stream id.
+	FlagStream uint16 = 1 << 10
+	// FlagEndStream marks the last frame sent by a side of a stream.
+	FlagEndStream uint16 = 1 << 9
+	// FlagCancelStream cancels a stream.
+	FlagCancelStream uint16 = 1 << 8
+	// FlagChannel marks a frame of a virtual channel, the data starts with the channel header.
+	FlagChannel uint16 = 1 << 7
+	// FlagHeader marks the data starting with the header section, see EncodeHeader.
+	FlagHeader uint16 = 1 << 6
+
+	compressorShift = 12
+)
+
+// MessageType returns the message type of flag.
+func MessageType(flag uint16) uint16 {
+	return flag & TypeMask
+}
+
+// CompressorCode returns the compressor code of flag, zero if the data is not compressed.
+func CompressorCode(flag uint16) uint8 {
+	if flag&FlagCompressed == 0 {
+		return 0
+	}
+	return uint8((flag & CompressorMask) >> compressorShift)
+}
+
+// SetCompressor returns flag marked as compressed by the compressor code, code is 1 to 7.
+// Zero code clears the compressed mark.
+func SetCompressor(flag uint16, code uint8) uint16 {
+	flag &^= FlagCompressed | CompressorMask
+	if code == 0 {
+		return flag
+	}
+	return flag | FlagCompressed | (uint16(code)<<compressorShift)&CompressorMask
+}
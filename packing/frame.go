@@ -0,0 +1,285 @@
+package packing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/kwstars/ktcp/message"
+)
+
+// FieldKind is the kind of a frame header field.
+type FieldKind int
+
+const (
+	// FieldLength is the size of the data, or of the whole frame if LengthIncludesHeader is set.
+	FieldLength FieldKind = iota + 1
+	// FieldID is the message ID.
+	FieldID
+	// FieldFlag is the message flag.
+	FieldFlag
+	// FieldPadding is ignored, it is written as zero.
+	FieldPadding
+)
+
+func (k FieldKind) String() string {
+	switch k {
+	case FieldLength:
+		return "length"
+	case FieldID:
+		return "id"
+	case FieldFlag:
+		return "flag"
+	case FieldPadding:
+		return "padding"
+	default:
+		return "unknown"
+	}
+}
+
+// Field is a frame header field of Width bytes.
+// The width of the length, id and flag fields is 1, 2, 4 or 8.
+//
+// A 1-byte flag field holds the message type, FlagHeader and FlagChannel only: the streams, the
+// fragmentation and the compression need a flag field of 2 bytes or more, see the bits of Message.Flag.
+type Field struct {
+	Kind  FieldKind `json:"kind"`
+	Width int       `json:"width"`
+}
+
+// FrameSpec declares the layout of a frame: the header fields in wire order followed by the data.
+type FrameSpec struct {
+	// ByteOrder of the header fields, little endian if nil.
+	ByteOrder binary.ByteOrder
+	// Fields of the header in wire order, it must contain a single FieldLength.
+	// The messages have zero ID or flag if the header has no such field, the message type of the
+	// packed messages is dropped without a flag field but their other flag bits are refused.
+	Fields []Field
+	// LengthIncludesHeader is set if the length field is the size of the whole frame.
+	LengthIncludesHeader bool
+	// MaxDataSize represents the max size of `data`, zero means no limit.
+	MaxDataSize int
+}
+
+// DefaultFrameSpec is the layout of DefaultPacker.
+var DefaultFrameSpec = FrameSpec{
+	ByteOrder: binary.LittleEndian,
+	Fields: []Field{
+		{Kind: FieldLength, Width: 4},
+		{Kind: FieldID, Width: 4},
+		{Kind: FieldFlag, Width: 2},
+	},
+	MaxDataSize: 1 << 10 << 10, // 1MB
+}
+
+var _ AppendPacker = &FramePacker{}
+
+// FramePacker is a Packer treating the packet with the layout of a FrameSpec, e.g.
+// a big endian frame with a 2-byte id and no flag:
+//
+//	packing.NewFramePacker(packing.FrameSpec{
+//		ByteOrder: binary.BigEndian,
+//		Fields: []packing.Field{
+//			{Kind: packing.FieldLength, Width: 4},
+//			{Kind: packing.FieldID, Width: 2},
+//		},
+//	})
+type FramePacker struct {
+	spec      FrameSpec
+	order     binary.ByteOrder
+	headerLen int
+	flagWidth int // zero if the header has no flag field
+}
+
+// NewFramePacker creates a *FramePacker, returns an error if spec is invalid.
+func NewFramePacker(spec FrameSpec) (*FramePacker, error) {
+	f := &FramePacker{spec: spec, order: spec.ByteOrder}
+	if f.order == nil {
+		f.order = binary.LittleEndian
+	}
+
+	seen := make(map[FieldKind]bool)
+	for _, field := range spec.Fields {
+		switch field.Kind {
+		case FieldLength, FieldID, FieldFlag:
+			if seen[field.Kind] {
+				return nil, fmt.Errorf("duplicate %s field", field.Kind)
+			}
+			seen[field.Kind] = true
+			if !validWidth(field.Width) {
+				return nil, fmt.Errorf("invalid width %d of %s field", field.Width, field.Kind)
+			}
+			if field.Kind == FieldFlag {
+				f.flagWidth = field.Width
+			}
+		case FieldPadding:
+			if field.Width <= 0 {
+				return nil, fmt.Errorf("invalid width %d of %s field", field.Width, field.Kind)
+			}
+		default:
+			return nil, fmt.Errorf("invalid field kind %d", field.Kind)
+		}
+		f.headerLen += field.Width
+	}
+	if !seen[FieldLength] {
+		return nil, fmt.Errorf("no %s field", FieldLength)
+	}
+	return f, nil
+}
+
+// Spec returns the frame spec of the packer.
+func (f *FramePacker) Spec() FrameSpec {
+	return f.spec
+}
+
+// HeaderLen returns the size of the frame header.
+func (f *FramePacker) HeaderLen() int {
+	return f.headerLen
+}
+
+// Pack implements the Packer Pack method.
+func (f *FramePacker) Pack(msg *message.Message) ([]byte, error) {
+	return f.AppendPack(make([]byte, 0, f.headerLen+len(msg.Data)), msg)
+}
+
+// AppendPack implements the AppendPacker AppendPack method.
+func (f *FramePacker) AppendPack(dst []byte, msg *message.Message) ([]byte, error) {
+	dataSize := len(msg.Data)
+	if f.spec.MaxDataSize > 0 && dataSize > f.spec.MaxDataSize {
+		return dst, fmt.Errorf("the dataSize %d is beyond the max: %d", dataSize, f.spec.MaxDataSize)
+	}
+	if err := f.checkFlag(msg.Flag); err != nil {
+		return dst, err
+	}
+
+	n := len(dst)
+	buffer := grow(dst, f.headerLen+dataSize)
+	offset := n
+	for _, field := range f.spec.Fields {
+		var v uint64
+		switch field.Kind {
+		case FieldLength:
+			v = uint64(dataSize)
+			if f.spec.LengthIncludesHeader {
+				v += uint64(f.headerLen)
+			}
+		case FieldID:
+			v = uint64(msg.ID)
+		case FieldFlag:
+			v = uint64(msg.Flag)
+		}
+		b := buffer[offset : offset+field.Width]
+		offset += field.Width
+		if field.Kind == FieldPadding {
+			for i := range b {
+				b[i] = 0
+			}
+			continue
+		}
+		if err := putUint(b, f.order, v); err != nil {
+			return dst, fmt.Errorf("write %s field err: %s", field.Kind, err)
+		}
+	}
+	copy(buffer[n+f.headerLen:], msg.Data)
+	return buffer, nil
+}
+
+// checkFlag returns an error if the flag bits would be lost by the flag field.
+func (f *FramePacker) checkFlag(flag uint16) error {
+	switch {
+	case f.flagWidth == 0 && flag&^TypeMask != 0:
+		return fmt.Errorf("the flag %#x needs a flag field", flag)
+	case f.flagWidth == 1 && flag > 0xff:
+		return fmt.Errorf("the flag %#x does not fit the 1-byte flag field", flag)
+	}
+	return nil
+}
+
+// Unpack implements the Packer Unpack method.
+// The message comes from message.Acquire, it can be released once it is handled.
+func (f *FramePacker) Unpack(reader io.Reader) (*message.Message, error) {
+	headerBuffer, buf, err := readHeader(reader, f.headerLen)
+	if err != nil {
+		return nil, fmt.Errorf("read header err: %s", err)
+	}
+
+	var (
+		length uint64
+		id     uint32
+		flag   uint16
+	)
+	offset := 0
+	for _, field := range f.spec.Fields {
+		b := headerBuffer[offset : offset+field.Width]
+		offset += field.Width
+		switch field.Kind {
+		case FieldLength:
+			length = getUint(b, f.order)
+		case FieldID:
+			id = uint32(getUint(b, f.order))
+		case FieldFlag:
+			flag = uint16(getUint(b, f.order))
+		}
+	}
+	releaseHeader(reader, f.headerLen, buf)
+
+	if f.spec.LengthIncludesHeader {
+		if length < uint64(f.headerLen) {
+			return nil, fmt.Errorf("the frame length %d is less than the header: %d", length, f.headerLen)
+		}
+		length -= uint64(f.headerLen)
+	}
+	if f.spec.MaxDataSize > 0 && length > uint64(f.spec.MaxDataSize) {
+		return nil, fmt.Errorf("the dataSize %d is beyond the max: %d", length, f.spec.MaxDataSize)
+	}
+	if length > math.MaxInt32 {
+		return nil, fmt.Errorf("the dataSize %d is too large", length)
+	}
+
+	msg := message.Acquire(int(length))
+	if _, err := io.ReadFull(reader, msg.Data); err != nil {
+		msg.Release()
+		return nil, fmt.Errorf("read data err: %s", err)
+	}
+	msg.ID = id
+	msg.Flag = flag
+	return msg, nil
+}
+
+func validWidth(width int) bool {
+	return width == 1 || width == 2 || width == 4 || width == 8
+}
+
+// putUint writes v to b of width 1, 2, 4 or 8, returns an error if v overflows.
+func putUint(b []byte, order binary.ByteOrder, v uint64) error {
+	if len(b) < 8 && v >= 1<<(8*uint(len(b))) {
+		return fmt.Errorf("value %d overflows %d bytes", v, len(b))
+	}
+	switch len(b) {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		order.PutUint16(b, uint16(v))
+	case 4:
+		order.PutUint32(b, uint32(v))
+	case 8:
+		order.PutUint64(b, v)
+	}
+	return nil
+}
+
+// getUint reads an unsigned integer from b of width 1, 2, 4 or 8.
+func getUint(b []byte, order binary.ByteOrder) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(order.Uint16(b))
+	case 4:
+		return uint64(order.Uint32(b))
+	case 8:
+		return order.Uint64(b)
+	}
+	return 0
+}
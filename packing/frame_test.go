@@ -0,0 +1,123 @@
+package packing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFramePacker(t *testing.T) {
+	tests := []struct {
+		name string
+		spec FrameSpec
+	}{
+		{name: "no length", spec: FrameSpec{Fields: []Field{{Kind: FieldID, Width: 4}}}},
+		{name: "duplicate id", spec: FrameSpec{Fields: []Field{{Kind: FieldLength, Width: 4}, {Kind: FieldID, Width: 4}, {Kind: FieldID, Width: 2}}}},
+		{name: "invalid width", spec: FrameSpec{Fields: []Field{{Kind: FieldLength, Width: 3}}}},
+		{name: "invalid padding", spec: FrameSpec{Fields: []Field{{Kind: FieldLength, Width: 4}, {Kind: FieldPadding}}}},
+		{name: "invalid kind", spec: FrameSpec{Fields: []Field{{Kind: FieldLength, Width: 4}, {Kind: 10, Width: 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFramePacker(tt.spec)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFramePacker_DefaultSpec(t *testing.T) {
+	f, err := NewFramePacker(DefaultFrameSpec)
+	require.NoError(t, err)
+	assert.Equal(t, 10, f.HeaderLen())
+
+	msg := &message.Message{ID: 1001, Flag: OKType, Data: []byte("hello")}
+	got, err := f.Pack(msg)
+	require.NoError(t, err)
+	want, err := NewDefaultPacker().Pack(msg)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestFramePacker_PackUnpack(t *testing.T) {
+	f, err := NewFramePacker(FrameSpec{
+		ByteOrder: binary.BigEndian,
+		Fields: []Field{
+			{Kind: FieldID, Width: 2},
+			{Kind: FieldPadding, Width: 1},
+			{Kind: FieldLength, Width: 2},
+		},
+		LengthIncludesHeader: true,
+	})
+	require.NoError(t, err)
+
+	msg := &message.Message{ID: 0x0102, Data: []byte("abc")}
+	packed, err := f.Pack(msg)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x01, 0x02, 0x00, 0x00, 0x08, 'a', 'b', 'c'}, packed)
+
+	got, err := f.Unpack(bytes.NewReader(packed))
+	require.NoError(t, err)
+	assertMessage(t, msg, got)
+
+	_, err = f.Pack(&message.Message{ID: 0x10000})
+	assert.Error(t, err)
+
+	// the length is less than the header.
+	_, err = f.Unpack(bytes.NewReader([]byte{0x01, 0x02, 0x00, 0x00, 0x04}))
+	assert.Error(t, err)
+}
+
+func TestFramePacker_MaxDataSize(t *testing.T) {
+	f, err := NewFramePacker(FrameSpec{
+		Fields:      []Field{{Kind: FieldLength, Width: 1}, {Kind: FieldFlag, Width: 1}},
+		MaxDataSize: 2,
+	})
+	require.NoError(t, err)
+
+	_, err = f.Pack(&message.Message{Data: []byte("abc")})
+	assert.Error(t, err)
+	_, err = f.Unpack(bytes.NewReader([]byte{0x03, 0x01, 'a', 'b', 'c'}))
+	assert.Error(t, err)
+
+	got, err := f.Unpack(bytes.NewReader([]byte{0x02, 0x01, 'a', 'b'}))
+	require.NoError(t, err)
+	assertMessage(t, &message.Message{Flag: 1, Data: []byte("ab")}, got)
+}
+
+func TestDefaultPacker_ByteOrder(t *testing.T) {
+	d := NewDefaultPacker()
+	d.ByteOrder = binary.BigEndian
+	packed, err := d.Pack(&message.Message{ID: 1, Flag: OKType, Data: []byte("a")})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 1, 0, 0, 0, 1, 0, 1, 'a'}, packed)
+}
+
+func assertMessage(t *testing.T, want, got *message.Message) {
+	t.Helper()
+	assert.Equal(t, want.ID, got.ID)
+	assert.Equal(t, want.Flag, got.Flag)
+	assert.Equal(t, want.Data, got.Data)
+}
+
+func TestFramePacker_FlagWidth(t *testing.T) {
+	f, err := NewFramePacker(FrameSpec{Fields: []Field{{Kind: FieldLength, Width: 2}, {Kind: FieldFlag, Width: 1}}})
+	require.NoError(t, err)
+	_, err = f.Pack(&message.Message{Flag: OKType | FlagHeader})
+	assert.NoError(t, err)
+	_, err = f.Pack(&message.Message{Flag: OKType | FlagStream})
+	assert.Error(t, err)
+	_, err = f.Pack(&message.Message{Flag: SetCompressor(OKType, 1)})
+	assert.Error(t, err)
+
+	// without a flag field, only the message type is dropped.
+	f, err = NewFramePacker(FrameSpec{Fields: []Field{{Kind: FieldLength, Width: 2}}})
+	require.NoError(t, err)
+	_, err = f.Pack(&message.Message{Flag: ErrType})
+	assert.NoError(t, err)
+	_, err = f.Pack(&message.Message{Flag: OKType | FlagFragment})
+	assert.Error(t, err)
+}
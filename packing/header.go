@@ -0,0 +1,88 @@
+package packing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/kwstars/ktcp/message"
+)
+
+// ErrHeaderTooLarge is returned when the header section exceeds 64KB or a key exceeds 255 bytes.
+var ErrHeaderTooLarge = errors.New("message header too large")
+
+// EncodeHeader returns the message whose data starts with the header section of msg,
+// the flag is marked with FlagHeader. msg is returned as is if it has no header.
+//
+// The header section has the format:
+//
+// size(2)|[keySize(1)|key|valueSize(2)|value]...
+//
+// size is the size of the entries, a key with several values has an entry per value.
+func EncodeHeader(msg *message.Message) (*message.Message, error) {
+	if len(msg.Header) == 0 {
+		return msg, nil
+	}
+	size := 0
+	for k, vs := range msg.Header {
+		if len(k) > math.MaxUint8 {
+			return nil, ErrHeaderTooLarge
+		}
+		for _, v := range vs {
+			size += 1 + len(k) + 2 + len(v)
+		}
+	}
+	if size > math.MaxUint16 {
+		return nil, ErrHeaderTooLarge
+	}
+
+	data := make([]byte, 2, 2+size+len(msg.Data))
+	binary.BigEndian.PutUint16(data, uint16(size))
+	for k, vs := range msg.Header {
+		for _, v := range vs {
+			data = append(data, byte(len(k)))
+			data = append(data, k...)
+			data = binary.BigEndian.AppendUint16(data, uint16(len(v)))
+			data = append(data, v...)
+		}
+	}
+	data = append(data, msg.Data...)
+	return &message.Message{ID: msg.ID, Flag: msg.Flag | FlagHeader, Data: data}, nil
+}
+
+// DecodeHeader decodes the header section of msg into msg.Header if the flag is marked with FlagHeader,
+// the section is stripped from the data and the mark is cleared.
+func DecodeHeader(msg *message.Message) error {
+	if msg.Flag&FlagHeader == 0 {
+		return nil
+	}
+	if len(msg.Data) < 2 {
+		return fmt.Errorf("invalid header section: the data size %d", len(msg.Data))
+	}
+	end := 2 + int(binary.BigEndian.Uint16(msg.Data))
+	if end > len(msg.Data) {
+		return fmt.Errorf("invalid header section: the size %d is beyond the data", end-2)
+	}
+
+	h := make(message.Header)
+	for b := msg.Data[2:end]; len(b) > 0; {
+		n := int(b[0])
+		if 1+n+2 > len(b) {
+			return fmt.Errorf("invalid header section: truncated key")
+		}
+		k := strings.ToLower(string(b[1 : 1+n]))
+		b = b[1+n:]
+		n = int(binary.BigEndian.Uint16(b))
+		if 2+n > len(b) {
+			return fmt.Errorf("invalid header section: truncated value of %s", k)
+		}
+		h[k] = append(h[k], string(b[2:2+n]))
+		b = b[2+n:]
+	}
+	msg.Header = h
+	msg.Flag &^= FlagHeader
+	msg.Data = msg.Data[:copy(msg.Data, msg.Data[end:])]
+	return nil
+}
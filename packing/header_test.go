@@ -0,0 +1,56 @@
+package packing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader_EncodeDecode(t *testing.T) {
+	msg := &message.Message{ID: 1, Flag: OKType, Data: []byte("hello"), Header: message.Header{}}
+	msg.Header.Set("X-Trace-ID", "abc")
+	msg.Header.Add("locale", "en")
+	msg.Header.Add("locale", "fr")
+
+	encoded, err := EncodeHeader(msg)
+	require.NoError(t, err)
+	assert.Equal(t, OKType|FlagHeader, encoded.Flag)
+	assert.Nil(t, encoded.Header)
+	assert.Equal(t, "hello", string(msg.Data))
+
+	require.NoError(t, DecodeHeader(encoded))
+	assert.Equal(t, uint16(OKType), encoded.Flag)
+	assert.Equal(t, "hello", string(encoded.Data))
+	assert.Equal(t, "abc", encoded.Header.Get("x-trace-id"))
+	assert.Equal(t, []string{"en", "fr"}, encoded.Header["locale"])
+}
+
+func TestHeader_NotPresent(t *testing.T) {
+	msg := &message.Message{ID: 1, Flag: OKType, Data: []byte("hello")}
+	encoded, err := EncodeHeader(msg)
+	require.NoError(t, err)
+	assert.Same(t, msg, encoded)
+
+	require.NoError(t, DecodeHeader(msg))
+	assert.Nil(t, msg.Header)
+	assert.Equal(t, "hello", string(msg.Data))
+}
+
+func TestHeader_Errors(t *testing.T) {
+	_, err := EncodeHeader(&message.Message{Header: message.Header{strings.Repeat("k", 256): {"v"}}})
+	assert.Equal(t, ErrHeaderTooLarge, err)
+	_, err = EncodeHeader(&message.Message{Header: message.Header{"k": {strings.Repeat("v", 1<<16)}}})
+	assert.Equal(t, ErrHeaderTooLarge, err)
+
+	for _, data := range [][]byte{
+		{0},
+		{0, 5, 1},
+		{0, 3, 1, 'k', 0},
+		{0, 5, 1, 'k', 0, 2, 'v'},
+	} {
+		assert.Error(t, DecodeHeader(&message.Message{Flag: FlagHeader, Data: data}))
+	}
+}
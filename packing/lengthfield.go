@@ -0,0 +1,293 @@
+package packing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/kwstars/ktcp/message"
+)
+
+const (
+	// DefaultMaxFrameLength is the max size of a frame if LengthFieldConfig.MaxFrameLength is zero.
+	DefaultMaxFrameLength = 1 << 10 << 10 // 1MB
+	// VarintLength is the LengthFieldLength of a base 128 varint length field.
+	VarintLength = -1
+	// StripLengthField is the InitialBytesToStrip stripping all the bytes up to the end of the length field.
+	StripLengthField = -1
+)
+
+// LengthFieldConfig describes a frame whose length is read from a length field,
+// like the LengthFieldBasedFrameDecoder of Netty.
+//
+// The frame length is the value of the length field + LengthAdjustment + the end offset of the length field.
+// After a frame is read, InitialBytesToStrip bytes are stripped from its beginning,
+// the message fields are read from the stripped frame.
+//
+// e.g. a 2-byte length field with a 1-byte id, the length being the size of the id and the data:
+//
+//	| length(2) | id(1) | data(n) |
+//
+//	packing.LengthFieldConfig{
+//		LengthFieldLength:   2,
+//		InitialBytesToStrip: packing.StripLengthField,
+//		IDLength:            1,
+//		DataOffset:          1,
+//	}
+type LengthFieldConfig struct {
+	// ByteOrder of the fixed width fields, big endian if nil.
+	ByteOrder binary.ByteOrder `json:"-"`
+	// LengthFieldOffset is the offset of the length field in the frame.
+	LengthFieldOffset int `json:"length_field_offset"`
+	// LengthFieldLength is the width of the length field: 1, 2, 4, 8 or VarintLength.
+	LengthFieldLength int `json:"length_field_length"`
+	// LengthAdjustment is added to the value of the length field to get the size of the frame after the length field.
+	LengthAdjustment int `json:"length_adjustment"`
+	// InitialBytesToStrip is the number of bytes stripped from the frame, zero or at least the end
+	// of the length field. It must be StripLengthField for a varint length field.
+	InitialBytesToStrip int `json:"initial_bytes_to_strip"`
+	// MaxFrameLength is the max size of a frame, DefaultMaxFrameLength if zero.
+	MaxFrameLength int `json:"max_frame_length"`
+	// IDOffset and IDLength locate the message ID in the stripped frame, IDLength is 0, 1, 2 or 4.
+	IDOffset int `json:"id_offset"`
+	IDLength int `json:"id_length"`
+	// FlagOffset and FlagLength locate the message flag in the stripped frame, FlagLength is 0, 1 or 2.
+	FlagOffset int `json:"flag_offset"`
+	FlagLength int `json:"flag_length"`
+	// DataOffset is the offset of the message data in the stripped frame.
+	DataOffset int `json:"data_offset"`
+}
+
+var _ Packer = &LengthFieldPacker{}
+
+// LengthFieldPacker is a Packer of the frames described by a LengthFieldConfig.
+// The bytes which are not message fields are written as zero.
+type LengthFieldPacker struct {
+	conf  LengthFieldConfig
+	order binary.ByteOrder
+}
+
+// NewLengthFieldPacker creates a *LengthFieldPacker, returns an error if conf is invalid.
+func NewLengthFieldPacker(conf LengthFieldConfig) (*LengthFieldPacker, error) {
+	l := &LengthFieldPacker{conf: conf, order: conf.ByteOrder}
+	if l.order == nil {
+		l.order = binary.BigEndian
+	}
+
+	if conf.LengthFieldOffset < 0 {
+		return nil, fmt.Errorf("invalid length field offset %d", conf.LengthFieldOffset)
+	}
+	if conf.MaxFrameLength < 0 || conf.MaxFrameLength > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid max frame length %d", conf.MaxFrameLength)
+	}
+	if conf.MaxFrameLength == 0 {
+		l.conf.MaxFrameLength = DefaultMaxFrameLength
+	}
+	if conf.LengthFieldLength == VarintLength {
+		if conf.InitialBytesToStrip != StripLengthField {
+			return nil, errors.New("a varint length field must be stripped")
+		}
+	} else {
+		if !validWidth(conf.LengthFieldLength) {
+			return nil, fmt.Errorf("invalid length field length %d", conf.LengthFieldLength)
+		}
+		end := conf.LengthFieldOffset + conf.LengthFieldLength
+		if strip := conf.InitialBytesToStrip; strip != 0 && strip != StripLengthField && strip < end {
+			return nil, fmt.Errorf("initial bytes to strip %d is within the length field", strip)
+		}
+		if conf.InitialBytesToStrip == 0 {
+			if conf.DataOffset < end {
+				return nil, fmt.Errorf("data offset %d is within the length field", conf.DataOffset)
+			}
+			// the message fields are in the unstripped frame with the length field.
+			if overlaps(conf.IDOffset, conf.IDLength, conf.LengthFieldOffset, conf.LengthFieldLength) {
+				return nil, fmt.Errorf("id field [%d, %d) overlaps the length field", conf.IDOffset, conf.IDOffset+conf.IDLength)
+			}
+			if overlaps(conf.FlagOffset, conf.FlagLength, conf.LengthFieldOffset, conf.LengthFieldLength) {
+				return nil, fmt.Errorf("flag field [%d, %d) overlaps the length field", conf.FlagOffset, conf.FlagOffset+conf.FlagLength)
+			}
+		}
+	}
+
+	if conf.IDLength != 0 && conf.IDLength != 1 && conf.IDLength != 2 && conf.IDLength != 4 {
+		return nil, fmt.Errorf("invalid id length %d", conf.IDLength)
+	}
+	if conf.FlagLength != 0 && conf.FlagLength != 1 && conf.FlagLength != 2 {
+		return nil, fmt.Errorf("invalid flag length %d", conf.FlagLength)
+	}
+	if conf.IDOffset < 0 || conf.IDOffset+conf.IDLength > conf.DataOffset {
+		return nil, fmt.Errorf("id field [%d, %d) is beyond the data offset %d", conf.IDOffset, conf.IDOffset+conf.IDLength, conf.DataOffset)
+	}
+	if conf.FlagOffset < 0 || conf.FlagOffset+conf.FlagLength > conf.DataOffset {
+		return nil, fmt.Errorf("flag field [%d, %d) is beyond the data offset %d", conf.FlagOffset, conf.FlagOffset+conf.FlagLength, conf.DataOffset)
+	}
+	if overlaps(conf.IDOffset, conf.IDLength, conf.FlagOffset, conf.FlagLength) {
+		return nil, fmt.Errorf("id field [%d, %d) overlaps the flag field", conf.IDOffset, conf.IDOffset+conf.IDLength)
+	}
+	return l, nil
+}
+
+// overlaps reports whether the fields [off1, off1+n1) and [off2, off2+n2) overlap.
+func overlaps(off1, n1, off2, n2 int) bool {
+	return n1 > 0 && n2 > 0 && off1 < off2+n2 && off2 < off1+n1
+}
+
+// Config returns the config of the packer.
+func (l *LengthFieldPacker) Config() LengthFieldConfig {
+	return l.conf
+}
+
+// strip returns the number of bytes stripped from a frame whose length field ends at lengthEnd.
+func (l *LengthFieldPacker) strip(lengthEnd int) int {
+	if l.conf.InitialBytesToStrip == StripLengthField {
+		return lengthEnd
+	}
+	return l.conf.InitialBytesToStrip
+}
+
+// Pack implements the Packer Pack method.
+func (l *LengthFieldPacker) Pack(msg *message.Message) ([]byte, error) {
+	body := make([]byte, l.conf.DataOffset+len(msg.Data))
+	if l.conf.IDLength > 0 {
+		if err := putUint(body[l.conf.IDOffset:l.conf.IDOffset+l.conf.IDLength], l.order, uint64(msg.ID)); err != nil {
+			return nil, fmt.Errorf("write id err: %s", err)
+		}
+	}
+	if l.conf.FlagLength > 0 {
+		if err := putUint(body[l.conf.FlagOffset:l.conf.FlagOffset+l.conf.FlagLength], l.order, uint64(msg.Flag)); err != nil {
+			return nil, fmt.Errorf("write flag err: %s", err)
+		}
+	}
+	copy(body[l.conf.DataOffset:], msg.Data)
+
+	if l.conf.LengthFieldLength == VarintLength {
+		length := len(body) - l.conf.LengthAdjustment
+		if length < 0 {
+			return nil, fmt.Errorf("negative length %d", length)
+		}
+		var varint [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(varint[:], uint64(length))
+		if err := l.checkFrameLength(l.conf.LengthFieldOffset + n + len(body)); err != nil {
+			return nil, err
+		}
+		frame := make([]byte, l.conf.LengthFieldOffset, l.conf.LengthFieldOffset+n+len(body))
+		frame = append(frame, varint[:n]...)
+		return append(frame, body...), nil
+	}
+
+	lengthEnd := l.conf.LengthFieldOffset + l.conf.LengthFieldLength
+	strip := l.strip(lengthEnd)
+	frame := body
+	if strip > 0 {
+		frame = make([]byte, strip+len(body))
+		copy(frame[strip:], body)
+	}
+	if err := l.checkFrameLength(len(frame)); err != nil {
+		return nil, err
+	}
+	length := len(frame) - lengthEnd - l.conf.LengthAdjustment
+	if length < 0 {
+		return nil, fmt.Errorf("negative length %d", length)
+	}
+	if err := putUint(frame[l.conf.LengthFieldOffset:lengthEnd], l.order, uint64(length)); err != nil {
+		return nil, fmt.Errorf("write length err: %s", err)
+	}
+	return frame, nil
+}
+
+// Unpack implements the Packer Unpack method.
+func (l *LengthFieldPacker) Unpack(reader io.Reader) (*message.Message, error) {
+	var (
+		head      []byte
+		length    uint64
+		lengthEnd int
+	)
+	if l.conf.LengthFieldLength == VarintLength {
+		head = make([]byte, l.conf.LengthFieldOffset)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			return nil, fmt.Errorf("read length field err: %s", err)
+		}
+		br, ok := reader.(io.ByteReader)
+		if !ok {
+			br = byteReader{reader}
+		}
+		v, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("read length field err: %s", err)
+		}
+		length = v
+		lengthEnd = l.conf.LengthFieldOffset + uvarintLen(v)
+	} else {
+		lengthEnd = l.conf.LengthFieldOffset + l.conf.LengthFieldLength
+		head = make([]byte, lengthEnd)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			return nil, fmt.Errorf("read length field err: %s", err)
+		}
+		length = getUint(head[l.conf.LengthFieldOffset:], l.order)
+	}
+
+	frameLength := int64(length) + int64(l.conf.LengthAdjustment) + int64(lengthEnd)
+	if length > math.MaxInt32 || frameLength > math.MaxInt32 || frameLength < int64(lengthEnd) {
+		return nil, fmt.Errorf("invalid frame length %d", frameLength)
+	}
+	if err := l.checkFrameLength(int(frameLength)); err != nil {
+		return nil, err
+	}
+	strip := l.strip(lengthEnd)
+	if int64(strip) > frameLength {
+		return nil, fmt.Errorf("the frame length %d is less than the initial bytes to strip %d", frameLength, strip)
+	}
+
+	rest := make([]byte, int(frameLength)-lengthEnd)
+	if _, err := io.ReadFull(reader, rest); err != nil {
+		return nil, fmt.Errorf("read frame err: %s", err)
+	}
+
+	var frame []byte
+	if strip >= lengthEnd {
+		frame = rest[strip-lengthEnd:]
+	} else {
+		frame = append(head[strip:], rest...)
+	}
+	if len(frame) < l.conf.DataOffset {
+		return nil, fmt.Errorf("the frame size %d is less than the data offset %d", len(frame), l.conf.DataOffset)
+	}
+
+	msg := &message.Message{Data: frame[l.conf.DataOffset:]}
+	if l.conf.IDLength > 0 {
+		msg.ID = uint32(getUint(frame[l.conf.IDOffset:l.conf.IDOffset+l.conf.IDLength], l.order))
+	}
+	if l.conf.FlagLength > 0 {
+		msg.Flag = uint16(getUint(frame[l.conf.FlagOffset:l.conf.FlagOffset+l.conf.FlagLength], l.order))
+	}
+	return msg, nil
+}
+
+func (l *LengthFieldPacker) checkFrameLength(n int) error {
+	if n > l.conf.MaxFrameLength {
+		return fmt.Errorf("the frame length %d is beyond the max: %d", n, l.conf.MaxFrameLength)
+	}
+	return nil
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// byteReader reads the bytes of a varint one by one.
+type byteReader struct {
+	io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	_, err := io.ReadFull(b.Reader, buf[:])
+	return buf[0], err
+}
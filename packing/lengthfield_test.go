@@ -0,0 +1,155 @@
+package packing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLengthFieldPacker(t *testing.T) {
+	tests := []struct {
+		name string
+		conf LengthFieldConfig
+	}{
+		{name: "invalid length", conf: LengthFieldConfig{LengthFieldLength: 3}},
+		{name: "varint not stripped", conf: LengthFieldConfig{LengthFieldLength: VarintLength}},
+		{name: "strip within length", conf: LengthFieldConfig{LengthFieldLength: 4, InitialBytesToStrip: 2}},
+		{name: "data within length", conf: LengthFieldConfig{LengthFieldLength: 4, DataOffset: 2}},
+		{name: "invalid id", conf: LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: StripLengthField, IDLength: 8, DataOffset: 8}},
+		{name: "id beyond data", conf: LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: StripLengthField, IDLength: 4, DataOffset: 2}},
+		{name: "flag beyond data", conf: LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: StripLengthField, FlagOffset: 1, FlagLength: 2, DataOffset: 2}},
+		{name: "id over unstripped length", conf: LengthFieldConfig{LengthFieldLength: 2, IDOffset: 1, IDLength: 2, DataOffset: 4}},
+		{name: "flag over unstripped length", conf: LengthFieldConfig{LengthFieldOffset: 1, LengthFieldLength: 2, FlagLength: 2, DataOffset: 4}},
+		{name: "id over flag", conf: LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: StripLengthField, IDLength: 2, FlagOffset: 1, FlagLength: 1, DataOffset: 2}},
+		{name: "negative max frame length", conf: LengthFieldConfig{LengthFieldLength: 2, MaxFrameLength: -1, DataOffset: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLengthFieldPacker(tt.conf)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLengthFieldPacker(t *testing.T) {
+	tests := []struct {
+		name  string
+		conf  LengthFieldConfig
+		msg   *message.Message
+		frame []byte
+	}{
+		{
+			// 2 bytes length field at offset 0, strip header
+			name:  "strip length",
+			conf:  LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: StripLengthField},
+			msg:   &message.Message{Data: []byte("HELLO")},
+			frame: []byte{0x00, 0x05, 'H', 'E', 'L', 'L', 'O'},
+		},
+		{
+			// 2 bytes length field at offset 0, do not strip header, the length is the whole frame
+			name:  "length includes header",
+			conf:  LengthFieldConfig{LengthFieldLength: 2, LengthAdjustment: -2, DataOffset: 2},
+			msg:   &message.Message{Data: []byte("HELLO")},
+			frame: []byte{0x00, 0x07, 'H', 'E', 'L', 'L', 'O'},
+		},
+		{
+			// 2 bytes header before the 3 bytes length field, id in the header
+			name:  "header before length",
+			conf:  LengthFieldConfig{LengthFieldOffset: 2, LengthFieldLength: 4, IDLength: 2, DataOffset: 6},
+			msg:   &message.Message{ID: 0xCAFE, Data: []byte("HI")},
+			frame: []byte{0xCA, 0xFE, 0x00, 0x00, 0x00, 0x02, 'H', 'I'},
+		},
+		{
+			// little endian length with id and flag after it, the length is the size of id, flag and data
+			name: "id and flag after length",
+			conf: LengthFieldConfig{
+				ByteOrder:           binary.LittleEndian,
+				LengthFieldLength:   4,
+				InitialBytesToStrip: StripLengthField,
+				IDLength:            2,
+				FlagOffset:          2,
+				FlagLength:          1,
+				DataOffset:          3,
+			},
+			msg:   &message.Message{ID: 7, Flag: ErrType, Data: []byte("x")},
+			frame: []byte{0x04, 0x00, 0x00, 0x00, 0x07, 0x00, 0x02, 'x'},
+		},
+		{
+			// strip beyond the length field
+			name:  "strip padding",
+			conf:  LengthFieldConfig{LengthFieldLength: 1, InitialBytesToStrip: 3},
+			msg:   &message.Message{Data: []byte("ab")},
+			frame: []byte{0x04, 0x00, 0x00, 'a', 'b'},
+		},
+		{
+			// protobuf style varint length prefix
+			name:  "varint",
+			conf:  LengthFieldConfig{LengthFieldLength: VarintLength, InitialBytesToStrip: StripLengthField, IDLength: 1, DataOffset: 1},
+			msg:   &message.Message{ID: 9, Data: bytes.Repeat([]byte{'v'}, 200)},
+			frame: append([]byte{0xC9, 0x01, 0x09}, bytes.Repeat([]byte{'v'}, 200)...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewLengthFieldPacker(tt.conf)
+			require.NoError(t, err)
+
+			frame, err := l.Pack(tt.msg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.frame, frame)
+
+			// two frames in a row.
+			r := bytes.NewReader(append(append([]byte{}, frame...), frame...))
+			for i := 0; i < 2; i++ {
+				msg, err := l.Unpack(r)
+				require.NoError(t, err)
+				assert.Equal(t, tt.msg, msg)
+			}
+			assert.Equal(t, 0, r.Len())
+		})
+	}
+}
+
+func TestLengthFieldPacker_MaxFrameLength(t *testing.T) {
+	for _, length := range []int{2, VarintLength} {
+		l, err := NewLengthFieldPacker(LengthFieldConfig{
+			LengthFieldLength:   length,
+			InitialBytesToStrip: StripLengthField,
+			MaxFrameLength:      4,
+		})
+		require.NoError(t, err)
+
+		_, err = l.Pack(&message.Message{Data: []byte("abcd")})
+		assert.Error(t, err)
+
+		frame, err := l.Pack(&message.Message{Data: []byte("a")})
+		require.NoError(t, err)
+		frame[len(frame)-2] = 100
+		_, err = l.Unpack(bytes.NewReader(frame))
+		assert.Error(t, err)
+	}
+}
+
+func TestLengthFieldPacker_DefaultMaxFrameLength(t *testing.T) {
+	l, err := NewLengthFieldPacker(LengthFieldConfig{LengthFieldLength: 8, InitialBytesToStrip: StripLengthField})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMaxFrameLength, l.Config().MaxFrameLength)
+
+	// a header announcing a huge frame is refused before the frame is allocated.
+	for _, length := range []uint64{DefaultMaxFrameLength, 1 << 40} {
+		head := binary.BigEndian.AppendUint64(nil, length)
+		_, err = l.Unpack(bytes.NewReader(head))
+		assert.Error(t, err)
+	}
+}
+
+func TestLengthFieldPacker_NegativeLength(t *testing.T) {
+	l, err := NewLengthFieldPacker(LengthFieldConfig{LengthFieldLength: 1, LengthAdjustment: -4, DataOffset: 1})
+	require.NoError(t, err)
+	_, err = l.Unpack(bytes.NewReader([]byte{0x00, 'a'}))
+	assert.Error(t, err)
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 
 	"github.com/kwstars/ktcp/message"
-	"github.com/spf13/cast"
 )
 
 const (
@@ -25,7 +24,7 @@ type Packer interface {
 	Unpack(reader io.Reader) (*message.Message, error)
 }
 
-var _ Packer = &DefaultPacker{}
+var _ AppendPacker = &DefaultPacker{}
 
 // NewDefaultPacker create a *DefaultPacker with initial field value.
 func NewDefaultPacker() *DefaultPacker {
@@ -46,52 +45,75 @@ func NewDefaultPacker() *DefaultPacker {
 // | `flag`     | uint16 | 2       |                         |
 // | `data`     | []byte | dynamic |                         |
 // .
+//
+// Use FramePacker for the other byte orders and header layouts.
 type DefaultPacker struct {
 	// MaxDataSize represents the max size of `data`
 	MaxDataSize int
+	// ByteOrder of the header, little endian if nil.
+	ByteOrder binary.ByteOrder
 }
 
 func (d *DefaultPacker) bytesOrder() binary.ByteOrder {
+	if d.ByteOrder != nil {
+		return d.ByteOrder
+	}
 	return binary.LittleEndian
 }
 
 // Pack implements the Packer Pack method.
 func (d *DefaultPacker) Pack(msg *message.Message) ([]byte, error) {
+	return d.AppendPack(make([]byte, 0, 4+4+2+len(msg.Data)), msg)
+}
+
+// AppendPack implements the AppendPacker AppendPack method.
+func (d *DefaultPacker) AppendPack(dst []byte, msg *message.Message) ([]byte, error) {
 	dataSize := len(msg.Data)
-	buffer := make([]byte, 4+4+2+dataSize)
-	d.bytesOrder().PutUint32(buffer[:4], uint32(dataSize)) // write dataSize
-	id, err := cast.ToUint32E(msg.ID)
-	if err != nil {
-		return nil, fmt.Errorf("invalid type of msg.ID: %s", err)
-	}
-	d.bytesOrder().PutUint32(buffer[4:8], id)        // write id
-	d.bytesOrder().PutUint16(buffer[8:10], msg.Flag) // write flag
-	copy(buffer[10:], msg.Data)                      // write data
-	return buffer, nil
+	order := d.bytesOrder()
+	n := len(dst)
+	dst = grow(dst, 4+4+2+dataSize)
+	order.PutUint32(dst[n:n+4], uint32(dataSize)) // write dataSize
+	order.PutUint32(dst[n+4:n+8], msg.ID)         // write id
+	order.PutUint16(dst[n+8:n+10], msg.Flag)      // write flag
+	copy(dst[n+10:], msg.Data)                    // write data
+	return dst, nil
 }
 
 // Unpack implements the Packer Unpack method.
 // Unpack returns the msg whose ID is type of int.
 // So we need use int id to register routes.
+//
+// The message comes from message.Acquire, it can be released once it is handled.
+// The header is peeked without copying if reader is a *bufio.Reader.
 func (d *DefaultPacker) Unpack(reader io.Reader) (*message.Message, error) {
-	headerBuffer := make([]byte, 4+4+2)
-	if _, err := io.ReadFull(reader, headerBuffer); err != nil {
+	headerBuffer, buf, err := readHeader(reader, 4+4+2)
+	if err != nil {
 		return nil, fmt.Errorf("read size and id err: %s", err)
 	}
-	dataSize := d.bytesOrder().Uint32(headerBuffer[:4])
+	order := d.bytesOrder()
+	dataSize := order.Uint32(headerBuffer[:4])
+	id := order.Uint32(headerBuffer[4:8])
+	flag := order.Uint16(headerBuffer[8:10])
+	releaseHeader(reader, 4+4+2, buf)
 	if d.MaxDataSize > 0 && int(dataSize) > d.MaxDataSize {
 		return nil, fmt.Errorf("the dataSize %d is beyond the max: %d", dataSize, d.MaxDataSize)
 	}
-	id := d.bytesOrder().Uint32(headerBuffer[4:8])
-	flag := d.bytesOrder().Uint16(headerBuffer[8:10])
-	data := make([]byte, dataSize)
-	if _, err := io.ReadFull(reader, data); err != nil {
+	msg := message.Acquire(int(dataSize))
+	if _, err := io.ReadFull(reader, msg.Data); err != nil {
+		msg.Release()
 		return nil, fmt.Errorf("read data err: %s", err)
 	}
-	msg := &message.Message{
-		ID:   id,
-		Flag: flag,
-		Data: data,
-	}
+	msg.ID = id
+	msg.Flag = flag
 	return msg, nil
 }
+
+// grow extends b by n bytes.
+func grow(b []byte, n int) []byte {
+	if len(b)+n <= cap(b) {
+		return b[:len(b)+n]
+	}
+	nb := make([]byte, len(b)+n, 2*cap(b)+n)
+	copy(nb, b)
+	return nb
+}
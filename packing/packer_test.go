@@ -0,0 +1,159 @@
+package packing
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPacker_PackUnpack(t *testing.T) {
+	d := NewDefaultPacker()
+	msg := &message.Message{ID: 1001, Flag: OKType, Data: []byte("hello")}
+	packed, err := d.Pack(msg)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{5, 0, 0, 0, 0xE9, 0x03, 0, 0, 1, 0, 'h', 'e', 'l', 'l', 'o'}, packed)
+
+	appended, err := d.AppendPack([]byte("prefix"), msg)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("prefix"), packed...), appended)
+
+	for _, r := range []io.Reader{
+		bytes.NewReader(append(packed, packed...)),
+		bufio.NewReader(bytes.NewReader(append(packed, packed...))),
+	} {
+		for i := 0; i < 2; i++ {
+			got, err := d.Unpack(r)
+			require.NoError(t, err)
+			assertMessage(t, msg, got)
+			got.Release()
+		}
+		_, err = d.Unpack(r)
+		assert.Error(t, err)
+	}
+}
+
+func TestDefaultPacker_UnpackErr(t *testing.T) {
+	d := NewDefaultPacker()
+	d.MaxDataSize = 4
+	packed, err := d.Pack(&message.Message{Data: []byte("hello")})
+	require.NoError(t, err)
+
+	_, err = d.Unpack(bufio.NewReader(bytes.NewReader(packed)))
+	assert.Error(t, err)
+	_, err = d.Unpack(bufio.NewReader(bytes.NewReader(packed[:5])))
+	assert.Error(t, err)
+	_, err = d.Unpack(bytes.NewReader(packed[:12]))
+	assert.Error(t, err)
+}
+
+func TestPackTo(t *testing.T) {
+	var w bytes.Buffer
+	msg := &message.Message{ID: 1, Flag: OKType, Data: []byte("hello")}
+	require.NoError(t, PackTo(&w, NewDefaultPacker(), msg))
+	l, err := NewLengthFieldPacker(LengthFieldConfig{LengthFieldLength: 2, InitialBytesToStrip: StripLengthField})
+	require.NoError(t, err)
+	require.NoError(t, PackTo(&w, l, msg))
+
+	got, err := NewDefaultPacker().Unpack(&w)
+	require.NoError(t, err)
+	assertMessage(t, msg, got)
+	got, err = l.Unpack(&w)
+	require.NoError(t, err)
+	assert.Equal(t, msg.Data, got.Data)
+}
+
+func TestDefaultPacker_NoAllocs(t *testing.T) {
+	d := NewDefaultPacker()
+	msg := &message.Message{ID: 1, Flag: OKType, Data: make([]byte, 256)}
+	packed, err := d.Pack(msg)
+	require.NoError(t, err)
+	r := bytes.NewReader(packed)
+	br := bufio.NewReader(r)
+	buf := make([]byte, 0, 1024)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = d.AppendPack(buf[:0], msg)
+		r.Reset(packed)
+		br.Reset(r)
+		m, _ := d.Unpack(br)
+		m.Release()
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func benchmarkMessage(size int) *message.Message {
+	return &message.Message{ID: 1, Flag: OKType, Data: bytes.Repeat([]byte{'x'}, size)}
+}
+
+func BenchmarkDefaultPacker_Pack(b *testing.B) {
+	d := NewDefaultPacker()
+	msg := benchmarkMessage(512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = d.Pack(msg)
+	}
+}
+
+func BenchmarkDefaultPacker_AppendPack(b *testing.B) {
+	d := NewDefaultPacker()
+	msg := benchmarkMessage(512)
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = d.AppendPack(buf[:0], msg)
+	}
+}
+
+func BenchmarkPackTo(b *testing.B) {
+	d := NewDefaultPacker()
+	msg := benchmarkMessage(512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = PackTo(io.Discard, d, msg)
+	}
+}
+
+func BenchmarkDefaultPacker_Unpack(b *testing.B) {
+	d := NewDefaultPacker()
+	packed, _ := d.Pack(benchmarkMessage(512))
+	r := bytes.NewReader(packed)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(packed)
+		m, _ := d.Unpack(r)
+		m.Release()
+	}
+}
+
+func BenchmarkDefaultPacker_UnpackBuffered(b *testing.B) {
+	d := NewDefaultPacker()
+	packed, _ := d.Pack(benchmarkMessage(512))
+	r := bytes.NewReader(packed)
+	br := bufio.NewReader(r)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(packed)
+		br.Reset(r)
+		m, _ := d.Unpack(br)
+		m.Release()
+	}
+}
+
+func BenchmarkFramePacker_UnpackBuffered(b *testing.B) {
+	f, _ := NewFramePacker(DefaultFrameSpec)
+	packed, _ := f.Pack(benchmarkMessage(512))
+	r := bytes.NewReader(packed)
+	br := bufio.NewReader(r)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(packed)
+		br.Reset(r)
+		m, _ := f.Unpack(br)
+		m.Release()
+	}
+}
@@ -0,0 +1,103 @@
+package packing
+
+import (
+	"bufio"
+	"io"
+	"sync"
+
+	"github.com/kwstars/ktcp/message"
+)
+
+const (
+	defaultBufferSize = 4 << 10  // 4KB
+	maxBufferSize     = 64 << 10 // 64KB, the larger buffers are not pooled
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, defaultBufferSize)
+		return &b
+	},
+}
+
+// GetBuffer returns an empty buffer from the pool.
+func GetBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// PutBuffer returns the buffer to the pool.
+func PutBuffer(b *[]byte) {
+	if cap(*b) > maxBufferSize {
+		return
+	}
+	*b = (*b)[:0]
+	bufferPool.Put(b)
+}
+
+// AppendPacker is a Packer which packs Message by appending to a buffer.
+type AppendPacker interface {
+	Packer
+
+	// AppendPack appends the packet of msg to dst and returns the extended buffer.
+	AppendPack(dst []byte, msg *message.Message) ([]byte, error)
+}
+
+// AppendPack appends the packet of msg to dst with p, it uses AppendPack if p is an AppendPacker.
+func AppendPack(p Packer, dst []byte, msg *message.Message) ([]byte, error) {
+	if ap, ok := p.(AppendPacker); ok {
+		return ap.AppendPack(dst, msg)
+	}
+	b, err := p.Pack(msg)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, b...), nil
+}
+
+// PackTo writes the packet of msg to w, packing into a pooled buffer if p is an AppendPacker.
+func PackTo(w io.Writer, p Packer, msg *message.Message) error {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	b, err := AppendPack(p, *buf, msg)
+	if err != nil {
+		return err
+	}
+	*buf = b
+	_, err = w.Write(b)
+	return err
+}
+
+// readHeader reads the n bytes header from reader.
+// With a *bufio.Reader the header is peeked, otherwise it is read into the pooled buffer buf.
+// releaseHeader must be called once the header is parsed.
+func readHeader(reader io.Reader, n int) (header []byte, buf *[]byte, err error) {
+	if br, ok := reader.(*bufio.Reader); ok && br.Size() >= n {
+		if header, err = br.Peek(n); err != nil {
+			if len(header) > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, nil, err
+		}
+		return header, nil, nil
+	}
+
+	buf = GetBuffer()
+	if cap(*buf) < n {
+		*buf = make([]byte, n)
+	}
+	header = (*buf)[:n]
+	if _, err = io.ReadFull(reader, header); err != nil {
+		PutBuffer(buf)
+		return nil, nil, err
+	}
+	return header, buf, nil
+}
+
+// releaseHeader discards the peeked header from reader, or returns buf to the pool.
+func releaseHeader(reader io.Reader, n int, buf *[]byte) {
+	if buf != nil {
+		PutBuffer(buf)
+		return
+	}
+	_, _ = reader.(*bufio.Reader).Discard(n)
+}
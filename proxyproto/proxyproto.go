@@ -0,0 +1,270 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY protocol v1 and v2,
+// see https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107
+	v2HeaderLen = 16
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrNoHeader is returned when a trusted connection does not start with a PROXY protocol header.
+var ErrNoHeader = errors.New("proxyproto: no proxy protocol header")
+
+// Header is a PROXY protocol header.
+type Header struct {
+	// Version is 1 or 2.
+	Version int
+	// Local is true for the connections established by the proxy itself, e.g. health checks,
+	// the addresses are those of the connection then.
+	Local bool
+	// SourceAddr is the address of the client, nil if unknown.
+	SourceAddr net.Addr
+	// DestinationAddr is the address the client connected to, nil if unknown.
+	DestinationAddr net.Addr
+}
+
+// ReadHeader reads a v1 or v2 header from r.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	sig, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: read signature err: %w", err)
+	}
+	if string(sig) == v1Prefix {
+		return readV1(r)
+	}
+	if sig, err = r.Peek(len(v2Signature)); err == nil && bytes.Equal(sig, v2Signature) {
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 reads a human-readable header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxyproto: read v1 header err: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("proxyproto: v1 header is not terminated by CRLF in %d bytes", v1MaxLength)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Local = true
+		return h, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("proxyproto: invalid v1 header %q", line)
+	}
+	if fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("proxyproto: invalid v1 protocol %q", fields[1])
+	}
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	h.SourceAddr, h.DestinationAddr = src, dst
+	return h, nil
+}
+
+func parseV1Addr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("proxyproto: invalid v1 address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: invalid v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads a binary header.
+//
+// | segment     | size     | remark                                        |
+// | ----------- | -------- | --------------------------------------------- |
+// | `signature` | 12       |                                               |
+// | `ver_cmd`   | 1        | version 2 in the high 4 bits, LOCAL or PROXY  |
+// | `fam`       | 1        | address family and transport protocol         |
+// | `len`       | 2        | big endian size of the addresses and the TLVs |
+// | `addr`      | `len`    |                                               |
+// .
+func readV2(r *bufio.Reader) (*Header, error) {
+	buf := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("proxyproto: read v2 header err: %w", err)
+	}
+	if version := buf[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("proxyproto: invalid v2 version %d", version)
+	}
+	command := buf[12] & 0x0F
+	family := buf[13]
+	addr := make([]byte, binary.BigEndian.Uint16(buf[14:16]))
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return nil, fmt.Errorf("proxyproto: read v2 addresses err: %w", err)
+	}
+
+	h := &Header{Version: 2}
+	switch command {
+	case 0x0: // LOCAL
+		h.Local = true
+		return h, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("proxyproto: invalid v2 command %d", command)
+	}
+
+	switch family {
+	case 0x11, 0x12: // TCP or UDP over IPv4
+		if len(addr) < 12 {
+			return nil, fmt.Errorf("proxyproto: v2 IPv4 addresses too short: %d", len(addr))
+		}
+		h.SourceAddr = &net.TCPAddr{IP: net.IP(addr[0:4]), Port: int(binary.BigEndian.Uint16(addr[8:10]))}
+		h.DestinationAddr = &net.TCPAddr{IP: net.IP(addr[4:8]), Port: int(binary.BigEndian.Uint16(addr[10:12]))}
+	case 0x21, 0x22: // TCP or UDP over IPv6
+		if len(addr) < 36 {
+			return nil, fmt.Errorf("proxyproto: v2 IPv6 addresses too short: %d", len(addr))
+		}
+		h.SourceAddr = &net.TCPAddr{IP: net.IP(addr[0:16]), Port: int(binary.BigEndian.Uint16(addr[32:34]))}
+		h.DestinationAddr = &net.TCPAddr{IP: net.IP(addr[16:32]), Port: int(binary.BigEndian.Uint16(addr[34:36]))}
+	default: // UNSPEC and unix sockets keep the addresses of the connection
+		h.Local = true
+	}
+	return h, nil
+}
+
+// Policy decides which connections carry a PROXY protocol header.
+type Policy struct {
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// NewPolicy creates a Policy trusting the proxies in the CIDRs or IPs of trusted,
+// e.g. "10.0.0.0/8" or "192.0.2.1". Use "0.0.0.0/0" and "::/0" to trust all sources.
+// The header has to be read within timeout, zero means no timeout.
+func NewPolicy(timeout time.Duration, trusted ...string) (*Policy, error) {
+	if len(trusted) == 0 {
+		return nil, errors.New("proxyproto: no trusted source")
+	}
+	p := &Policy{timeout: timeout}
+	for _, t := range trusted {
+		if !strings.Contains(t, "/") {
+			ip := net.ParseIP(t)
+			if ip == nil {
+				return nil, fmt.Errorf("proxyproto: invalid trusted source %q", t)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			p.trusted = append(p.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("proxyproto: invalid trusted source %q: %s", t, err)
+		}
+		p.trusted = append(p.trusted, n)
+	}
+	return p, nil
+}
+
+// Trusted reports whether addr is a trusted proxy.
+func (p *Policy) Trusted(addr net.Addr) bool {
+	a, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range p.trusted {
+		if n.Contains(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap reads the header of the connection if it comes from a trusted proxy.
+// The connections from the other sources are returned as is.
+func (p *Policy) Wrap(conn net.Conn) (net.Conn, error) {
+	if !p.Trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	if p.timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(p.timeout)); err != nil {
+			return nil, err
+		}
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	r := bufio.NewReader(conn)
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, r: r, header: h}, nil
+}
+
+// Conn is a connection whose PROXY protocol header has been read.
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	header *Header
+}
+
+// Read reads the data following the header.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Header returns the PROXY protocol header of the connection.
+func (c *Conn) Header() *Header {
+	return c.header
+}
+
+// RemoteAddr returns the address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.header.Local || c.header.SourceAddr == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.header.SourceAddr
+}
+
+// LocalAddr returns the address the client connected to.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.header.Local || c.header.DestinationAddr == nil {
+		return c.Conn.LocalAddr()
+	}
+	return c.header.DestinationAddr
+}
+
+// ProxyAddr returns the address of the proxy.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
@@ -0,0 +1,175 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func v2Header(command, family byte, addr []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(b[14:16], uint16(len(addr)))
+	return append(b, addr...)
+}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xDC, 0x04, 0x01, 0xBB}
+	ipv6 := make([]byte, 36)
+	copy(ipv6[0:16], net.ParseIP("2001:db8::1"))
+	copy(ipv6[16:32], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(ipv6[32:34], 56324)
+	binary.BigEndian.PutUint16(ipv6[34:36], 443)
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  *Header
+		err   bool
+	}{
+		{
+			name:  "v1 tcp4",
+			input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			want: &Header{
+				Version:         1,
+				SourceAddr:      &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324},
+				DestinationAddr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443},
+			},
+		},
+		{
+			name:  "v1 tcp6",
+			input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			want: &Header{
+				Version:         1,
+				SourceAddr:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+				DestinationAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+			},
+		},
+		{
+			name:  "v1 unknown",
+			input: []byte("PROXY UNKNOWN\r\n"),
+			want:  &Header{Version: 1, Local: true},
+		},
+		{
+			name:  "v1 invalid address",
+			input: []byte("PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n"),
+			err:   true,
+		},
+		{
+			name:  "v1 not terminated",
+			input: []byte("PROXY TCP4 " + strings.Repeat("1", 200)),
+			err:   true,
+		},
+		{
+			name:  "v2 ipv4",
+			input: v2Header(0x1, 0x11, ipv4),
+			want: &Header{
+				Version:         2,
+				SourceAddr:      &net.TCPAddr{IP: net.IP{192, 0, 2, 1}, Port: 56324},
+				DestinationAddr: &net.TCPAddr{IP: net.IP{198, 51, 100, 1}, Port: 443},
+			},
+		},
+		{
+			name:  "v2 ipv6",
+			input: v2Header(0x1, 0x21, ipv6),
+			want: &Header{
+				Version:         2,
+				SourceAddr:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+				DestinationAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+			},
+		},
+		{
+			name:  "v2 local",
+			input: v2Header(0x0, 0x00, nil),
+			want:  &Header{Version: 2, Local: true},
+		},
+		{
+			name:  "v2 short addresses",
+			input: v2Header(0x1, 0x11, ipv4[:8]),
+			err:   true,
+		},
+		{
+			name:  "no header",
+			input: []byte("GET / HTTP/1.1\r\n"),
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ReadHeader(bufio.NewReader(bytes.NewReader(tt.input)))
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.Version, h.Version)
+			assert.Equal(t, tt.want.Local, h.Local)
+			if tt.want.SourceAddr != nil {
+				assert.Equal(t, tt.want.SourceAddr.String(), h.SourceAddr.String())
+				assert.Equal(t, tt.want.DestinationAddr.String(), h.DestinationAddr.String())
+			}
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	_, err := NewPolicy(0)
+	assert.Error(t, err)
+	_, err = NewPolicy(0, "10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = NewPolicy(0, "10.0.0")
+	assert.Error(t, err)
+
+	p, err := NewPolicy(0, "10.0.0.0/8", "192.0.2.1", "2001:db8::/32")
+	require.NoError(t, err)
+	assert.Equal(t, true, p.Trusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.Equal(t, true, p.Trusted(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}))
+	assert.Equal(t, false, p.Trusted(&net.TCPAddr{IP: net.ParseIP("192.0.2.2")}))
+	assert.Equal(t, true, p.Trusted(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}))
+	assert.Equal(t, false, p.Trusted(&net.UnixAddr{Name: "/tmp/ktcp.sock", Net: "unix"}))
+}
+
+func TestPolicy_Wrap(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\npayload"))
+	}()
+
+	conn, err := lis.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	p, err := NewPolicy(0, "127.0.0.1")
+	require.NoError(t, err)
+	wrapped, err := p.Wrap(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:56324", wrapped.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.1:443", wrapped.LocalAddr().String())
+	assert.Equal(t, conn.RemoteAddr(), wrapped.(*Conn).ProxyAddr())
+
+	data, err := io.ReadAll(wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(data))
+
+	// untrusted connections are returned as is.
+	p, err = NewPolicy(0, "10.0.0.0/8")
+	require.NoError(t, err)
+	wrapped, err = p.Wrap(conn)
+	require.NoError(t, err)
+	assert.Equal(t, conn, wrapped)
+}
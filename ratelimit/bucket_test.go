@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket_AllowN(t *testing.T) {
+	b := NewBucket(10, 2)
+	now := b.last
+
+	assert.Equal(t, true, b.AllowN(now, 1))
+	assert.Equal(t, true, b.AllowN(now, 1))
+	assert.Equal(t, false, b.AllowN(now, 1))
+
+	// 10 tokens per second, one token after 100ms.
+	now = now.Add(100 * time.Millisecond)
+	assert.Equal(t, true, b.AllowN(now, 1))
+	assert.Equal(t, false, b.AllowN(now, 1))
+
+	// never refills beyond the burst.
+	now = now.Add(time.Hour)
+	assert.Equal(t, false, b.AllowN(now, 3))
+	assert.Equal(t, true, b.AllowN(now, 2))
+}
+
+func TestBucket_MinBurst(t *testing.T) {
+	b := NewBucket(1, 0)
+	assert.Equal(t, true, b.AllowN(b.last, 1))
+	assert.Equal(t, false, b.AllowN(b.last, 1))
+}
@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Action is what the server does with a message exceeding the limits.
+type Action int
+
+const (
+	// Drop drops the message silently.
+	Drop Action = iota
+	// Reply drops the message and replies a rate limit error frame.
+	Reply
+	// Disconnect drops the message and closes the session once MaxStrikes is reached.
+	Disconnect
+)
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst tokens.
+// Zero Rate means no limit.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (l Limit) bucket() *Bucket {
+	if l.Rate <= 0 {
+		return nil
+	}
+	return NewBucket(l.Rate, l.Burst)
+}
+
+// Config declares the message rate limits of the sessions of a server.
+type Config struct {
+	// Session limits all messages of a session.
+	Session Limit `json:"session"`
+	// Messages limits the messages of a session by message ID.
+	Messages map[uint32]Limit `json:"messages"`
+	// User limits all messages of the sessions bound to the same user.
+	User Limit `json:"user"`
+	// Action is what to do with a message exceeding the limits.
+	Action Action `json:"action"`
+	// MaxStrikes closes the session after MaxStrikes violations, zero means never.
+	// Disconnect with zero MaxStrikes closes the session on the first violation.
+	MaxStrikes int `json:"max_strikes"`
+	// ReplyID is the message ID of the rate limit error frame, the request message ID is used if zero.
+	ReplyID uint32 `json:"reply_id"`
+}
+
+// Limiter holds the rate limits shared by the sessions of a server.
+type Limiter struct {
+	conf  Config
+	mu    sync.Mutex
+	users map[string]*userBucket
+}
+
+type userBucket struct {
+	bucket *Bucket
+	refs   int
+}
+
+// New creates a Limiter by conf.
+func New(conf Config) *Limiter {
+	if conf.Action == Disconnect && conf.MaxStrikes <= 0 {
+		conf.MaxStrikes = 1
+	}
+	return &Limiter{
+		conf:  conf,
+		users: make(map[string]*userBucket),
+	}
+}
+
+// Config returns the config of the limiter.
+func (l *Limiter) Config() Config {
+	return l.conf
+}
+
+// Session creates the limits of a new session.
+func (l *Limiter) Session() *SessionLimiter {
+	return &SessionLimiter{
+		limiter:  l,
+		session:  l.conf.Session.bucket(),
+		messages: make(map[uint32]*Bucket),
+	}
+}
+
+func (l *Limiter) acquireUser(user string) *Bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u, ok := l.users[user]
+	if !ok {
+		u = &userBucket{bucket: l.conf.User.bucket()}
+		l.users[user] = u
+	}
+	u.refs++
+	return u.bucket
+}
+
+func (l *Limiter) releaseUser(user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if u, ok := l.users[user]; ok {
+		if u.refs--; u.refs <= 0 {
+			delete(l.users, user)
+		}
+	}
+}
+
+// SessionLimiter holds the rate limits of a session.
+// It is safe for concurrent use.
+type SessionLimiter struct {
+	limiter  *Limiter
+	session  *Bucket
+	mu       sync.Mutex
+	messages map[uint32]*Bucket
+	user     string
+	userB    *Bucket
+	strikes  int
+}
+
+// SetUser binds the session to user, the user limit is shared by the sessions bound to the same user.
+func (s *SessionLimiter) SetUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user == user {
+		return
+	}
+	if s.user != "" {
+		s.limiter.releaseUser(s.user)
+		s.userB = nil
+	}
+	s.user = user
+	if user != "" {
+		s.userB = s.limiter.acquireUser(user)
+	}
+}
+
+// Allow reports whether the message id is allowed.
+// If not, it returns the number of violations of the session so far and whether
+// the session has to be closed.
+func (s *SessionLimiter) Allow(id uint32) (allowed bool, strikes int, disconnect bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.messages[id]
+	if !ok {
+		// only the configured ids are cached, the map is bounded by Config.Messages.
+		if limit, exist := s.limiter.conf.Messages[id]; exist {
+			b = limit.bucket()
+			s.messages[id] = b
+		}
+	}
+
+	if take(time.Now(), s.session, b, s.userB) {
+		return true, s.strikes, false
+	}
+
+	s.strikes++
+	max := s.limiter.conf.MaxStrikes
+	return false, s.strikes, max > 0 && s.strikes >= max
+}
+
+// take takes a token of each non-nil bucket if all of them allow it, the tokens taken
+// before a denying bucket are put back.
+func take(now time.Time, buckets ...*Bucket) bool {
+	for i, b := range buckets {
+		if b == nil || b.AllowN(now, 1) {
+			continue
+		}
+		for _, taken := range buckets[:i] {
+			if taken != nil {
+				taken.putBack(1)
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Close releases the user binding of the session.
+func (s *SessionLimiter) Close() {
+	s.SetUser("")
+}
+
+// Config returns the config of the limiter the session belongs to.
+func (s *SessionLimiter) Config() Config {
+	return s.limiter.conf
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionLimiter_Messages(t *testing.T) {
+	l := New(Config{
+		Messages: map[uint32]Limit{1: {Rate: 0.001, Burst: 2}},
+	})
+	s := l.Session()
+
+	allowed, _, _ := s.Allow(1)
+	assert.Equal(t, true, allowed)
+	allowed, _, _ = s.Allow(1)
+	assert.Equal(t, true, allowed)
+	allowed, strikes, disconnect := s.Allow(1)
+	assert.Equal(t, false, allowed)
+	assert.Equal(t, 1, strikes)
+	assert.Equal(t, false, disconnect)
+
+	// other message ids are not limited.
+	for i := 0; i < 10; i++ {
+		allowed, _, _ = s.Allow(2)
+		assert.Equal(t, true, allowed)
+	}
+
+	// each session has its own buckets.
+	allowed, _, _ = l.Session().Allow(1)
+	assert.Equal(t, true, allowed)
+}
+
+func TestSessionLimiter_Session(t *testing.T) {
+	s := New(Config{Session: Limit{Rate: 0.001, Burst: 1}}).Session()
+	allowed, _, _ := s.Allow(1)
+	assert.Equal(t, true, allowed)
+	allowed, _, _ = s.Allow(2)
+	assert.Equal(t, false, allowed)
+}
+
+func TestSessionLimiter_User(t *testing.T) {
+	l := New(Config{User: Limit{Rate: 0.001, Burst: 1}})
+	s1, s2 := l.Session(), l.Session()
+	s1.SetUser("u1")
+	s2.SetUser("u1")
+
+	allowed, _, _ := s1.Allow(1)
+	assert.Equal(t, true, allowed)
+	allowed, _, _ = s2.Allow(1)
+	assert.Equal(t, false, allowed)
+
+	s1.Close()
+	s2.Close()
+	assert.Equal(t, 0, len(l.users))
+
+	// a new binding starts with a full bucket.
+	s1.SetUser("u1")
+	allowed, _, _ = s1.Allow(1)
+	assert.Equal(t, true, allowed)
+}
+
+func TestSessionLimiter_Strikes(t *testing.T) {
+	s := New(Config{Session: Limit{Rate: 0.001, Burst: 1}, Action: Disconnect, MaxStrikes: 2}).Session()
+	allowed, _, _ := s.Allow(1)
+	assert.Equal(t, true, allowed)
+	_, strikes, disconnect := s.Allow(1)
+	assert.Equal(t, 1, strikes)
+	assert.Equal(t, false, disconnect)
+	_, strikes, disconnect = s.Allow(1)
+	assert.Equal(t, 2, strikes)
+	assert.Equal(t, true, disconnect)
+
+	// Disconnect without MaxStrikes closes the session on the first violation.
+	s = New(Config{Session: Limit{Rate: 0.001, Burst: 1}, Action: Disconnect}).Session()
+	s.Allow(1)
+	_, _, disconnect = s.Allow(1)
+	assert.Equal(t, true, disconnect)
+}
+
+func TestSessionLimiter_Unconfigured(t *testing.T) {
+	s := New(Config{Messages: map[uint32]Limit{1: {Rate: 0.001, Burst: 1}}}).Session()
+	for id := uint32(2); id < 100; id++ {
+		s.Allow(id)
+	}
+	assert.Equal(t, 0, len(s.messages))
+	s.Allow(1)
+	assert.Equal(t, 1, len(s.messages))
+}
+
+func TestSessionLimiter_DeniedNotConsumed(t *testing.T) {
+	l := New(Config{
+		Session:  Limit{Rate: 0.001, Burst: 2},
+		Messages: map[uint32]Limit{1: {Rate: 0.001, Burst: 1}},
+	})
+	s := l.Session()
+	allowed, _, _ := s.Allow(1)
+	assert.Equal(t, true, allowed)
+	// the denied message does not take a token of the session bucket.
+	allowed, _, _ = s.Allow(1)
+	assert.Equal(t, false, allowed)
+	allowed, _, _ = s.Allow(2)
+	assert.Equal(t, true, allowed)
+}
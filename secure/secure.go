@@ -0,0 +1,236 @@
+// Package secure implements the application-level encryption of the message data for the clients
+// which can't do TLS.
+//
+// The client and the server exchange their X25519 public keys when the connection is established:
+//
+//	client -> server: client public key(32)
+//	server -> client: server public key(32)
+//
+// An AES-256-GCM key per direction is derived from the shared secret with HKDF-SHA256.
+// The data of every frame is then sealed, prefixed with the frame counter of its direction:
+//
+//	counter(8)|ciphertext(n)|tag(16)
+//
+// The nonce is derived from the counter and the message id and flag are authenticated,
+// a frame whose counter is not the next one of its direction is rejected as replayed or reordered.
+//
+// The key exchange is anonymous: the keys are ephemeral and neither peer is authenticated, so the
+// encryption protects against passive eavesdroppers only. An active man in the middle can run a key
+// exchange with each peer and read or alter every frame. Use TLS, or authenticate the peers on top of
+// the session, e.g. a login with a shared secret, when the network is not trusted.
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+)
+
+const (
+	// KeySize is the size of an X25519 public key on the wire.
+	KeySize = 32
+	// CounterSize is the size of the frame counter prefixing the sealed data.
+	CounterSize = 8
+
+	clientInfo = "ktcp secure client to server"
+	serverInfo = "ktcp secure server to client"
+)
+
+// ErrReplayed is returned when a frame counter is not the next one expected.
+var ErrReplayed = errors.New("secure: replayed or reordered frame")
+
+// Config is the encryption config of a listener.
+type Config struct {
+	// HandshakeTimeout bounds the key exchange, zero means no timeout.
+	HandshakeTimeout time.Duration
+}
+
+var _ packing.AppendPacker = &Packer{}
+
+// Packer wraps a packing.Packer, it seals the data of the packed messages and opens the data of
+// the unpacked messages with the keys of a session.
+//
+// The frames are numbered when they are packed, so Pack must be called in the order the frames are written
+// and must not be called concurrently. A frame which fails to pack doesn't take a number.
+type Packer struct {
+	packer  packing.Packer
+	seal    cipher.AEAD
+	open    cipher.AEAD
+	sendSeq uint64 // the counter of the next packed frame
+	recvSeq uint64
+}
+
+// ServerHandshake performs the key exchange of the server side on rw and
+// returns the Packer of the session wrapping p. The client is not authenticated.
+func ServerHandshake(rw io.ReadWriter, p packing.Packer) (*Packer, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key err: %s", err)
+	}
+	peer, err := readPublicKey(rw)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rw.Write(key.PublicKey().Bytes()); err != nil {
+		return nil, fmt.Errorf("write public key err: %s", err)
+	}
+	return newPacker(p, key, peer, peer, key.PublicKey(), serverInfo, clientInfo)
+}
+
+// ClientHandshake performs the key exchange of the client side on rw and
+// returns the Packer of the connection wrapping p. The server is not authenticated.
+func ClientHandshake(rw io.ReadWriter, p packing.Packer) (*Packer, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key err: %s", err)
+	}
+	if _, err := rw.Write(key.PublicKey().Bytes()); err != nil {
+		return nil, fmt.Errorf("write public key err: %s", err)
+	}
+	peer, err := readPublicKey(rw)
+	if err != nil {
+		return nil, err
+	}
+	return newPacker(p, key, peer, key.PublicKey(), peer, clientInfo, serverInfo)
+}
+
+func readPublicKey(r io.Reader) (*ecdh.PublicKey, error) {
+	var b [KeySize]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, fmt.Errorf("read public key err: %s", err)
+	}
+	peer, err := ecdh.X25519().NewPublicKey(b[:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", err)
+	}
+	return peer, nil
+}
+
+// newPacker derives the keys of both directions, the salt is the public keys of the client and the server.
+func newPacker(p packing.Packer, key *ecdh.PrivateKey, peer, client, server *ecdh.PublicKey, sealInfo, openInfo string) (*Packer, error) {
+	secret, err := key.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange err: %s", err)
+	}
+	salt := append(client.Bytes(), server.Bytes()...)
+	prk := hkdfExtract(salt, secret)
+
+	s := &Packer{packer: p}
+	if s.seal, err = newAEAD(hkdfExpand(prk, sealInfo)); err != nil {
+		return nil, err
+	}
+	if s.open, err = newAEAD(hkdfExpand(prk, openInfo)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher err: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdfExtract and hkdfExpand implement HKDF-SHA256 of RFC 5869 for a single 32-byte key.
+func hkdfExtract(salt, secret []byte) []byte {
+	h := hmac.New(sha256.New, salt)
+	h.Write(secret)
+	return h.Sum(nil)
+}
+
+func hkdfExpand(prk []byte, info string) []byte {
+	h := hmac.New(sha256.New, prk)
+	h.Write([]byte(info))
+	h.Write([]byte{1})
+	return h.Sum(nil)
+}
+
+// Packer returns the wrapped packer.
+func (s *Packer) Packer() packing.Packer {
+	return s.packer
+}
+
+// Pack implements the Packer Pack method.
+func (s *Packer) Pack(msg *message.Message) ([]byte, error) {
+	return s.AppendPack(nil, msg)
+}
+
+// AppendPack implements the AppendPacker AppendPack method.
+func (s *Packer) AppendPack(dst []byte, msg *message.Message) ([]byte, error) {
+	seq := s.sendSeq
+
+	buf := packing.GetBuffer()
+	defer packing.PutBuffer(buf)
+	data := append((*buf)[:0], make([]byte, CounterSize)...)
+	binary.BigEndian.PutUint64(data, seq)
+	var (
+		nonce [12]byte
+		aad   [6]byte
+	)
+	data = s.seal.Seal(data, nonceOf(&nonce, seq), msg.Data, aadOf(&aad, msg))
+	*buf = data
+
+	dst, err := packing.AppendPack(s.packer, dst, &message.Message{ID: msg.ID, Flag: msg.Flag, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	s.sendSeq++
+	return dst, nil
+}
+
+// Unpack implements the Packer Unpack method, it returns ErrReplayed if the frame is out of order.
+// The data is opened in place, the message can be released as the ones of the wrapped packer.
+func (s *Packer) Unpack(reader io.Reader) (*message.Message, error) {
+	msg, err := s.packer.Unpack(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.Data) < CounterSize+s.open.Overhead() {
+		msg.Release()
+		return nil, fmt.Errorf("the sealed dataSize %d is too small", len(msg.Data))
+	}
+	seq := binary.BigEndian.Uint64(msg.Data)
+	if seq != s.recvSeq {
+		msg.Release()
+		return nil, fmt.Errorf("%w: counter %d, expected %d", ErrReplayed, seq, s.recvSeq)
+	}
+
+	var (
+		nonce [12]byte
+		aad   [6]byte
+	)
+	sealed := msg.Data[CounterSize:]
+	data, err := s.open.Open(sealed[:0], nonceOf(&nonce, seq), sealed, aadOf(&aad, msg))
+	if err != nil {
+		msg.Release()
+		return nil, fmt.Errorf("open data err: %s", err)
+	}
+	s.recvSeq++
+	msg.Data = msg.Data[:copy(msg.Data, data)]
+	return msg, nil
+}
+
+func nonceOf(nonce *[12]byte, seq uint64) []byte {
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce[:]
+}
+
+// aadOf returns the message id and flag authenticated with the data.
+func aadOf(aad *[6]byte, msg *message.Message) []byte {
+	binary.BigEndian.PutUint32(aad[:4], msg.ID)
+	binary.BigEndian.PutUint16(aad[4:6], msg.Flag)
+	return aad[:]
+}
@@ -0,0 +1,111 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handshake returns the packers of both sides of a connection.
+func handshake(t *testing.T) (client, server *Packer) {
+	t.Helper()
+	return handshakeWith(t, packing.NewDefaultPacker())
+}
+
+// handshakeWith returns the packers of both sides of a connection wrapping p.
+func handshakeWith(t *testing.T, p packing.Packer) (client, server *Packer) {
+	t.Helper()
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		server, err = ServerHandshake(s, p)
+		errCh <- err
+	}()
+	client, err := ClientHandshake(c, p)
+	require.NoError(t, err)
+	require.NoError(t, <-errCh)
+	return client, server
+}
+
+func TestPacker(t *testing.T) {
+	client, server := handshake(t)
+
+	var wire bytes.Buffer
+	for i := 0; i < 3; i++ {
+		frame, err := client.Pack(&message.Message{ID: uint32(i), Flag: packing.OKType, Data: []byte("secret")})
+		require.NoError(t, err)
+		assert.NotContains(t, string(frame), "secret")
+		wire.Write(frame)
+	}
+	for i := 0; i < 3; i++ {
+		msg, err := server.Unpack(&wire)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(i), msg.ID)
+		assert.Equal(t, uint16(packing.OKType), msg.Flag)
+		assert.Equal(t, []byte("secret"), msg.Data)
+	}
+
+	// the other direction has its own key.
+	frame, err := server.Pack(&message.Message{ID: 9, Data: []byte("reply")})
+	require.NoError(t, err)
+	_, err = server.Unpack(bytes.NewReader(frame))
+	assert.Error(t, err)
+	msg, err := client.Unpack(bytes.NewReader(frame))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("reply"), msg.Data)
+}
+
+func TestPacker_Replayed(t *testing.T) {
+	client, server := handshake(t)
+
+	first, err := client.Pack(&message.Message{ID: 1})
+	require.NoError(t, err)
+	second, err := client.Pack(&message.Message{ID: 2})
+	require.NoError(t, err)
+
+	// reordered.
+	_, err = server.Unpack(bytes.NewReader(second))
+	assert.True(t, errors.Is(err, ErrReplayed))
+
+	_, err = server.Unpack(bytes.NewReader(first))
+	require.NoError(t, err)
+	// replayed.
+	_, err = server.Unpack(bytes.NewReader(first))
+	assert.True(t, errors.Is(err, ErrReplayed))
+}
+
+func TestPacker_Tampered(t *testing.T) {
+	client, server := handshake(t)
+
+	frame, err := client.Pack(&message.Message{ID: 1, Data: []byte("data")})
+	require.NoError(t, err)
+	frame[4]++ // the id is authenticated.
+	_, err = server.Unpack(bytes.NewReader(frame))
+	assert.Error(t, err)
+}
+
+func TestPacker_PackFailed(t *testing.T) {
+	p, err := packing.NewFramePacker(packing.DefaultFrameSpec)
+	require.NoError(t, err)
+	client, server := handshakeWith(t, p)
+
+	// the frame beyond the max data size of the wrapped packer is not numbered.
+	_, err = client.Pack(&message.Message{ID: 1, Data: make([]byte, packing.DefaultFrameSpec.MaxDataSize)})
+	require.Error(t, err)
+
+	frame, err := client.Pack(&message.Message{ID: 2, Data: []byte("data")})
+	require.NoError(t, err)
+	msg, err := server.Unpack(bytes.NewReader(frame))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), msg.Data)
+}
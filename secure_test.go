@@ -0,0 +1,45 @@
+package ktcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/encoding/proto"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/kwstars/ktcp/secure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Encryption(t *testing.T) {
+	_, conn := serveTest(t, &echoHandler{}, Encryption(&secure.Config{HandshakeTimeout: time.Second}))
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	packer, err := secure.ClientHandshake(conn, packing.NewDefaultPacker())
+	require.NoError(t, err)
+
+	codec := proto.New()
+	for i := uint32(0); i < 3; i++ {
+		data, err := codec.Marshal(&testData.TestModel{Id: int64(i), Name: "ktcp"})
+		require.NoError(t, err)
+		require.NoError(t, packing.PackTo(conn, packer, &message.Message{ID: 100 + i, Data: data}))
+
+		msg, err := packer.Unpack(conn)
+		require.NoError(t, err)
+		assert.Equal(t, 101+i, msg.ID)
+		out := &testData.TestModel{}
+		require.NoError(t, codec.Unmarshal(msg.Data, out))
+		assert.Equal(t, "ktcp", out.Name)
+	}
+}
+
+func TestServer_EncryptionHandshakeTimeout(t *testing.T) {
+	_, conn := serveTest(t, &echoHandler{}, Encryption(&secure.Config{HandshakeTimeout: 50 * time.Millisecond}))
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+
+	// no public key is sent, the connection is closed.
+	_, err := conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package ktcp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -12,9 +13,18 @@ import (
 
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/kwstars/ktcp/capture"
 	"github.com/kwstars/ktcp/encoding"
 	"github.com/kwstars/ktcp/encoding/proto"
+	"github.com/kwstars/ktcp/fragment"
+	"github.com/kwstars/ktcp/integrity"
+	"github.com/kwstars/ktcp/metrics"
+	"github.com/kwstars/ktcp/negotiate"
 	"github.com/kwstars/ktcp/packing"
+	"github.com/kwstars/ktcp/proxyproto"
+	"github.com/kwstars/ktcp/ratelimit"
+	"github.com/kwstars/ktcp/secure"
+	"github.com/kwstars/ktcp/sync/atomic"
 )
 
 // Byte unit helpers.
@@ -48,6 +58,20 @@ func Address(addr string) ServerOption {
 	}
 }
 
+// Listener with server listener, it takes precedence over Network and Address.
+func Listener(lis net.Listener) ServerOption {
+	return func(s *Server) {
+		s.Listener = lis
+	}
+}
+
+// ReadBufferSize with the size of the buffered reader of a session.
+func ReadBufferSize(n int) ServerOption {
+	return func(s *Server) {
+		s.readBufferSize = n
+	}
+}
+
 // ReadTimeout with server timeout.
 func ReadTimeout(readTimeout time.Duration) ServerOption {
 	return func(s *Server) {
@@ -86,6 +110,15 @@ type ServerOption func(*Server)
 type Server struct {
 	socketReadBufferSize  int
 	socketWriteBufferSize int
+	readBufferSize        int
+	batchDelay            time.Duration
+	batchMaxBytes         int
+	compression           *compression
+	fragment              *fragment.Config
+	channelWindow         int
+	maxChannels           int
+	channelHandlers       map[uint16]func(ch *Channel)
+	maxDecompressedSize   int
 	reqQueueSize          int
 	respQueueSize         int
 	writeAttemptTimes     int
@@ -96,6 +129,20 @@ type Server struct {
 	Listener              net.Listener
 	Packer                packing.Packer // Packer is the message packer, will be passed to session.
 	Codec                 encoding.Codec // Codec is the message codec, will be passed to session.
+	tlsConf               *tls.Config
+	proxy                 *proxyproto.Policy
+	secure                *secure.Config
+	integrity             *integrity.Config
+	negotiate             *negotiate.Config
+	metrics               *metrics.Metrics // nil if the server is not measured
+	capture               *capture.Writer  // nil if the frames are not captured
+	captureFilter         func(sess *Session) bool
+	messageCodecs         map[uint32]string
+	listeners             []*listener // listeners added by AddListener and AddAddress
+	lisMu                 sync.Mutex  // guards Listener and the listener sockets between Serve and Stop
+	limiter               *connLimiter
+	rateLimit             *ratelimit.Limiter
+	closed                [closeReasonEnd]atomic.Int64
 	callback              Handler
 	quit                  *ksync.Event
 	log                   *log.Helper
@@ -110,6 +157,8 @@ func NewServer(handler Handler, opts ...ServerOption) *Server {
 	srv := &Server{
 		socketReadBufferSize:  1 * MB,
 		socketWriteBufferSize: 1 * MB,
+		readBufferSize:        4 * KB,
+		maxDecompressedSize:   4 * MB,
 		reqQueueSize:          1024,
 		respQueueSize:         1024,
 		writeAttemptTimes:     3,
@@ -124,6 +173,7 @@ func NewServer(handler Handler, opts ...ServerOption) *Server {
 		log:                   log.NewHelper(log.DefaultLogger),
 		pool:                  &sync.Pool{New: func() interface{} { return NewContext() }},
 		quit:                  ksync.NewEvent(),
+		limiter:               newConnLimiter(),
 	}
 
 	logger := log.NewHelper(log.DefaultLogger)
@@ -136,25 +186,83 @@ func NewServer(handler Handler, opts ...ServerOption) *Server {
 	return srv
 }
 
-// Serve the TCP server
+// Serve the TCP server on the default listener and the listeners added by AddListener and AddAddress.
 func (s *Server) Serve() error {
-	address, err := net.ResolveTCPAddr(s.network, s.address)
-	if err != nil {
-		return err
+	lis := s.Listener
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen(s.network, s.address); err != nil {
+			return err
+		}
 	}
 
-	s.log.Infof("start tcp server at %s", address.String())
-	lis, err := net.ListenTCP(s.network, address)
-	if err != nil {
-		return err
-	}
+	return s.ServeListener(lis)
+}
 
+// ServeListener accepts incoming connections on the listener lis as the default listener,
+// together with the listeners added by AddListener and AddAddress.
+// The listeners are closed when the server stops.
+func (s *Server) ServeListener(lis net.Listener) error {
+	if s.fragment != nil {
+		if err := s.fragment.Validate(); err != nil {
+			return err
+		}
+	}
+	s.lisMu.Lock()
+	if s.quit.HasFired() {
+		s.lisMu.Unlock()
+		_ = lis.Close()
+		return nil
+	}
 	s.Listener = lis
+	def := &listener{name: DefaultListenerName, lis: lis, tlsConf: s.tlsConf, proxy: s.proxy, secure: s.secure, integrity: s.integrity, negotiate: s.negotiate}
+
+	listeners := append([]*listener{def}, s.listeners...)
+	for i, l := range listeners {
+		if l.packer == nil {
+			l.packer = s.Packer
+		}
+		if l.codec == nil {
+			l.codec = s.Codec
+		}
+		if err := l.listen(); err != nil {
+			for _, opened := range listeners[:i] {
+				_ = opened.lis.Close()
+			}
+			s.lisMu.Unlock()
+			return fmt.Errorf("listener %s listen err: %s", l.name, err)
+		}
+	}
+	s.lisMu.Unlock()
+
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l *listener) {
+			errc <- s.acceptLoop(l)
+		}(l)
+	}
+
+	// the first fatal accept error closes the other listeners, so that Serve returns.
+	var err error
+	for range listeners {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+			for _, l := range listeners {
+				_ = l.lis.Close()
+			}
+		}
+	}
+	return err
+}
+
+// acceptLoop accepts incoming connections on the listener l.
+func (s *Server) acceptLoop(l *listener) error {
+	s.log.Infof("start %s listener %s at %s", l.lis.Addr().Network(), l.name, l.lis.Addr().String())
 
 	var tempDelay time.Duration
 
 	for {
-		conn, err := s.Listener.Accept()
+		conn, err := l.lis.Accept()
 		if err != nil {
 			if ne, ok := err.(interface{ Temporary() bool }); ok && ne.Temporary() {
 				if tempDelay == 0 {
@@ -165,7 +273,7 @@ func (s *Server) Serve() error {
 				if max := 1 * time.Second; tempDelay > max {
 					tempDelay = max
 				}
-				s.log.Errorf("http: Accept error: %v; retrying in %v", err, tempDelay)
+				s.log.Errorf("ktcp: Accept error: %v; retrying in %v", err, tempDelay)
 				timer := time.NewTimer(tempDelay)
 				select {
 				case <-timer.C:
@@ -178,51 +286,108 @@ func (s *Server) Serve() error {
 			if s.quit.HasFired() {
 				return nil
 			}
-			return err
+			return fmt.Errorf("listener %s accept err: %s", l.name, err)
 		}
 
 		tempDelay = 0
 
-		if s.socketReadBufferSize > 0 {
-			if err = conn.(*net.TCPConn).SetReadBuffer(s.socketReadBufferSize); err != nil {
-				return fmt.Errorf("conn set read buffer err: %s", err)
-			}
-		}
-		if s.socketWriteBufferSize > 0 {
-			if err = conn.(*net.TCPConn).SetWriteBuffer(s.socketWriteBufferSize); err != nil {
-				return fmt.Errorf("conn set write buffer err: %s", err)
-			}
+		if !s.limiter.allowAccept() {
+			s.measureReject(l, RejectAcceptRate)
+			// the reject policy may write to a slow peer.
+			s.serveWG.Add(1)
+			go func() {
+				s.limiter.reject(conn, l, RejectAcceptRate)
+				s.serveWG.Done()
+			}()
+			continue
 		}
 
+		s.setSocketBuffer(conn)
+
 		s.serveWG.Add(1)
 		go func() {
-			s.handleRawConn(conn)
+			s.handleRawConn(conn, l)
 			s.serveWG.Done()
 		}()
 	}
 }
 
-// handleRawConn handles the connection
-func (s *Server) handleRawConn(conn net.Conn) {
+// setSocketBuffer applies the socket buffer sizes if the connection supports them,
+// e.g. *net.TCPConn and *net.UnixConn. Failures are logged and the connection is kept.
+func (s *Server) setSocketBuffer(conn net.Conn) {
+	if s.socketReadBufferSize > 0 {
+		if c, ok := conn.(interface{ SetReadBuffer(int) error }); ok {
+			if err := c.SetReadBuffer(s.socketReadBufferSize); err != nil {
+				s.log.Warnf("conn %s set read buffer err: %s", conn.RemoteAddr(), err)
+			}
+		}
+	}
+	if s.socketWriteBufferSize > 0 {
+		if c, ok := conn.(interface{ SetWriteBuffer(int) error }); ok {
+			if err := c.SetWriteBuffer(s.socketWriteBufferSize); err != nil {
+				s.log.Warnf("conn %s set write buffer err: %s", conn.RemoteAddr(), err)
+			}
+		}
+	}
+}
+
+// handleRawConn handles the connection accepted on the listener l.
+func (s *Server) handleRawConn(conn net.Conn, l *listener) {
 	if s.quit.HasFired() {
 		conn.Close()
 		return
 	}
+	if s.metrics != nil {
+		s.metrics.Accepts.With(l.name).Inc()
+	}
+
+	if l.proxy != nil {
+		c, err := l.proxy.Wrap(conn)
+		if err != nil {
+			s.log.Errorf("listener %s conn %s read proxy protocol header err: %s", l.name, conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		conn = c
+	}
+
+	release, reason := s.limiter.acquire(l, conn.RemoteAddr())
+	if reason != 0 {
+		s.log.Warnf("listener %s reject conn %s: %s", l.name, conn.RemoteAddr(), reason)
+		s.measureReject(l, reason)
+		s.limiter.reject(conn, l, reason)
+		return
+	}
+	defer release()
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
-	sess := newSession(conn, s, cancelFunc)
+	sess := newSession(l.wrap(conn), s, l, cancelFunc)
+	if err := sess.handshake(l); err != nil {
+		s.log.Errorf("session %s handshake err: %s", sess.ID(), err)
+		s.setCloseReason(sess, CloseHandshake)
+		sess.Close()
+		return
+	}
 
 	s.sessions.Store(sess.ID(), sess)
+	if s.metrics != nil {
+		s.metrics.Sessions.With(l.name).Add(1)
+	}
 	defer func() {
 		s.removeSession(sess)
+		if s.metrics != nil {
+			s.metrics.Sessions.With(l.name).Sub(1)
+		}
 	}()
 
 	s.callback.OnConnect(sess)
 
-	if err := sess.readInbound(ctx); err != nil {
+	err := sess.readInbound(ctx)
+	if err != nil {
 		s.log.Errorf("session read inbound err: %s", err)
 	}
+	s.setCloseReason(sess, closeReasonOf(sess, err))
 
 	s.callback.OnClose(sess)
 }
@@ -231,10 +396,22 @@ func (s *Server) handleRawConn(conn net.Conn) {
 func (s *Server) Stop(ctx context.Context) (err error) {
 	s.quit.Fire()
 
-	// close the listener
-	if err := s.Listener.Close(); err != nil {
-		s.log.Errorf("close listener err: %s", err)
+	// close the listeners, the ones ServeListener opens after are closed by it.
+	s.lisMu.Lock()
+	if s.Listener != nil {
+		if err := s.Listener.Close(); err != nil {
+			s.log.Errorf("close listener %s err: %s", DefaultListenerName, err)
+		}
+	}
+	for _, l := range s.listeners {
+		if l.lis == nil {
+			continue
+		}
+		if err := l.lis.Close(); err != nil {
+			s.log.Errorf("close listener %s err: %s", l.name, err)
+		}
 	}
+	s.lisMu.Unlock()
 
 	// close sessions
 	s.sessions.Range(func(k, v interface{}) bool {
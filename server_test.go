@@ -0,0 +1,200 @@
+package ktcp
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kwstars/ktcp/encoding/proto"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/packing"
+	"github.com/kwstars/ktcp/proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoHandler replies to every request with the same payload and id+1.
+type echoHandler struct {
+	connected chan *Session
+}
+
+func (h *echoHandler) OnConnect(s *Session) {
+	if h.connected != nil {
+		h.connected <- s
+	}
+}
+
+func (h *echoHandler) OnMessage(c Context) {
+	var in testData.TestModel
+	if err := c.Bind(&in); err != nil {
+		return
+	}
+	_ = c.Send(c.GetReqMsg().ID+1, &in)
+}
+
+func (h *echoHandler) OnClose(s *Session) {}
+
+// serveTest serves h with opts on a local listener and dials it,
+// the server is stopped and the connection closed once the test ends.
+func serveTest(t *testing.T, h Handler, opts ...ServerOption) (*Server, net.Conn) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(h, append([]ServerOption{Listener(lis)}, opts...)...)
+	go func() { _ = srv.Serve() }()
+	t.Cleanup(func() { _ = srv.Stop(context.Background()) })
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return srv, conn
+}
+
+// roundTrip writes a request frame to conn and reads the reply frame.
+func roundTrip(t *testing.T, conn net.Conn, id uint32, in *testData.TestModel) (*message.Message, *testData.TestModel) {
+	t.Helper()
+	packer := packing.NewDefaultPacker()
+	codec := proto.New()
+	data, err := codec.Marshal(in)
+	require.NoError(t, err)
+	frame, err := packer.Pack(&message.Message{ID: id, Flag: packing.OKType, Data: data})
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+	_, err = conn.Write(frame)
+	require.NoError(t, err)
+	msg, err := packer.Unpack(conn)
+	require.NoError(t, err)
+	out := &testData.TestModel{}
+	require.NoError(t, codec.Unmarshal(msg.Data, out))
+	return msg, out
+}
+
+func TestServer_ServeListenerUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ktcp.sock")
+	lis, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	srv := NewServer(&echoHandler{})
+	go func() { _ = srv.ServeListener(lis) }()
+	defer func() { _ = srv.Stop(context.Background()) }()
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg, out := roundTrip(t, conn, 100, &testData.TestModel{Id: 1, Name: "ktcp"})
+	assert.Equal(t, uint32(101), msg.ID)
+	assert.Equal(t, uint16(packing.OKType), msg.Flag)
+	assert.Equal(t, "ktcp", out.Name)
+}
+
+func TestServer_ListenerOption(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := NewServer(&echoHandler{}, Listener(lis), ReadTimeout(time.Second))
+	go func() { _ = srv.Serve() }()
+	defer func() { _ = srv.Stop(context.Background()) }()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg, out := roundTrip(t, conn, 1, &testData.TestModel{Id: 2})
+	assert.Equal(t, uint32(2), msg.ID)
+	assert.Equal(t, int64(2), out.Id)
+	assert.Equal(t, lis, srv.Listener)
+}
+
+func TestServer_MultipleListeners(t *testing.T) {
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	private, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "ktcp.sock")
+
+	h := &echoHandler{connected: make(chan *Session, 3)}
+	srv := NewServer(h,
+		Listener(public),
+		AddListener("private", private, ListenerMaxSessions(1)),
+		AddAddress("local", "unix", path),
+	)
+	go func() { _ = srv.Serve() }()
+	defer func() { _ = srv.Stop(context.Background()) }()
+
+	for _, c := range []struct {
+		network, address, name string
+	}{
+		{"tcp", public.Addr().String(), DefaultListenerName},
+		{"tcp", private.Addr().String(), "private"},
+		{"unix", path, "local"},
+	} {
+		var conn net.Conn
+		require.Eventually(t, func() bool {
+			conn, err = net.Dial(c.network, c.address)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		defer conn.Close()
+
+		msg, _ := roundTrip(t, conn, 10, &testData.TestModel{Id: 3})
+		assert.Equal(t, uint32(11), msg.ID)
+		sess := <-h.connected
+		assert.Equal(t, c.name, sess.ListenerName())
+		_, ok := srv.sessions.Load(sess.ID())
+		assert.True(t, ok)
+	}
+
+	// the private listener accepts a single session.
+	conn, err := net.Dial("tcp", private.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	policy, err := proxyproto.NewPolicy(time.Second, "127.0.0.0/8")
+	require.NoError(t, err)
+
+	h := &echoHandler{connected: make(chan *Session, 1)}
+	srv := NewServer(h, Listener(lis), ProxyProtocol(policy), MaxSessionsPerIP(1))
+	go func() { _ = srv.Serve() }()
+	defer func() { _ = srv.Stop(context.Background()) }()
+
+	for _, src := range []string{"192.0.2.1", "192.0.2.2"} {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("PROXY TCP4 " + src + " 198.51.100.1 56324 9090\r\n"))
+		require.NoError(t, err)
+
+		// the per IP limit applies to the client addresses.
+		roundTrip(t, conn, 1, &testData.TestModel{Id: 1})
+		sess := <-h.connected
+		assert.Equal(t, src+":56324", sess.RemoteAddr().String())
+		assert.Equal(t, "198.51.100.1:9090", sess.LocalAddr().String())
+	}
+}
+
+func TestServer_StopWhileServing(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(&echoHandler{}, AddAddress("local", "unix", filepath.Join(t.TempDir(), "ktcp.sock")))
+
+	// Stop may run while ServeListener opens the listeners.
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ServeListener(lis) }()
+	require.NoError(t, srv.Stop(context.Background()))
+	select {
+	case err := <-errc:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("ServeListener does not return once stopped")
+	}
+}
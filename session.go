@@ -1,18 +1,33 @@
 package ktcp
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/kwstars/ktcp/sync/atomic"
 
+	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/metadata"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/kwstars/ktcp/capture"
+	"github.com/kwstars/ktcp/compress"
 	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/fragment"
+	"github.com/kwstars/ktcp/integrity"
 	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/metrics"
+	"github.com/kwstars/ktcp/negotiate"
 	"github.com/kwstars/ktcp/packing"
+	"github.com/kwstars/ktcp/ratelimit"
+	"github.com/kwstars/ktcp/secure"
 	"github.com/segmentio/ksuid"
 )
 
@@ -23,6 +38,9 @@ const (
 // ErrSessionClosed is returned when session stopped.
 var ErrSessionClosed = fmt.Errorf("session closed")
 
+// ErrRateLimited is returned when a session is closed for exceeding the rate limits.
+var ErrRateLimited = fmt.Errorf("rate limited")
+
 type CallBack interface {
 	OnMessage(c Context)
 	OnClose(c *Session)
@@ -31,37 +49,81 @@ type CallBack interface {
 // Session is a server side network connection.
 type Session struct {
 	connected         atomic.Bool
+	closeReason       atomic.Int32
 	writeAttemptTimes int
 	id                string                // session's ID. it's a UUID
+	listener          string                // name of the listener which accepted the connection
 	conn              net.Conn              // tcp connection
+	reader            *bufio.Reader         // buffered reader of conn
 	respQueue         chan Context          // response queue channel, pushed in SendResp() and popped in writeOutbound()
 	reqQueue          chan *message.Message // request queue channel, pushed in readInbound() and popped in Handle()
 	packer            packing.Packer        // to pack and unpack message
 	codec             encoding.Codec        // encode/decode message data
 	callback          CallBack
+	rateLimit         *ratelimit.SessionLimiter // nil if the server has no rate limit
+	wmu               sync.Mutex                // serializes the writes to conn
+	batch             *writeBatch               // nil if the writes are not batched
+	compression       *compression              // nil if compression is disabled
+	compressor        compress.Compressor       // compressor of the outbound frames
+	fragment          *fragment.Config          // nil if fragmentation is disabled
+	fragmentSeq       atomic.Int32              // id of the last outbound fragmented message
+	reassembler       *fragment.Reassembler     // used by readInbound only
+	streamsMu         sync.Mutex
+	streams           map[uint32]*serverStream // opened streams
+	lastStreamID      uint32                   // used by readInbound only
+	channels          *channelMux
+	negotiated        *negotiate.Result // nil if the listener does not negotiate
+	messageCodecs     map[uint32]string // codec names by message id, see MessageCodec
+	metrics           *metrics.Metrics  // nil if the server is not measured
+	createdAt         time.Time
+	capture           *capture.Writer // nil if the frames are not captured
+	capturing         atomic.Bool
+	stats             sessionStats
+	ms                []middleware.Middleware
+	mu                sync.RWMutex
+	userID            string // the user bound to the session
 	log               *log.Helper
 	cancelFunc        context.CancelFunc
 	pool              *sync.Pool
 }
 
-func (s *Session) Codec() encoding.Codec {
-	return s.codec
-}
-
-// newSession creates a new session.
-func newSession(conn net.Conn, s *Server, cancelFunc context.CancelFunc) (sess *Session) {
+// newSession creates a new session for the connection accepted on the listener l.
+func newSession(conn net.Conn, s *Server, l *listener, cancelFunc context.CancelFunc) (sess *Session) {
 	sess = &Session{
 		conn:              conn,
+		reader:            bufio.NewReaderSize(conn, s.readBufferSize),
+		listener:          l.name,
 		cancelFunc:        cancelFunc,
 		id:                ksuid.New().String(),
 		reqQueue:          make(chan *message.Message, s.reqQueueSize),
 		respQueue:         make(chan Context, s.respQueueSize),
 		writeAttemptTimes: s.writeAttemptTimes,
-		packer:            s.Packer,
-		codec:             s.Codec,
+		packer:            l.packer,
+		codec:             l.codec,
 		callback:          s.callback,
 		log:               s.log,
 		pool:              s.pool,
+		compression:       s.compression,
+		fragment:          s.fragment,
+		messageCodecs:     s.messageCodecs,
+		metrics:           s.metrics,
+		createdAt:         time.Now(),
+		capture:           s.capture,
+		ms:                s.ms,
+	}
+
+	if s.rateLimit != nil {
+		sess.rateLimit = s.rateLimit.Session()
+	}
+	if s.capture != nil && (s.captureFilter == nil || s.captureFilter(sess)) {
+		sess.capturing.SetTrue()
+	}
+	sess.channels = newChannelMux(sess, sess.writeMessage, sess.Codec, s.channelWindow, s.maxChannels, s.channelHandlers)
+	if s.fragment != nil {
+		sess.reassembler = fragment.NewReassembler(*s.fragment)
+	}
+	if s.batchDelay > 0 || s.batchMaxBytes > 0 {
+		sess.batch = newWriteBatch(sess, s.batchDelay, s.batchMaxBytes)
 	}
 
 	sess.connected.SetTrue()
@@ -74,16 +136,90 @@ func (s *Session) ID() string {
 	return s.id
 }
 
+// ListenerName returns the name of the listener which accepted the session.
+func (s *Session) ListenerName() string {
+	return s.listener
+}
+
+// RemoteAddr returns the address of the client, which is read from the PROXY protocol
+// header if the connection comes from a trusted proxy.
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to.
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// SetUserID binds the session to the user id, the user rate limit is shared
+// by the sessions bound to the same user.
+func (s *Session) SetUserID(id string) {
+	s.mu.Lock()
+	s.userID = id
+	s.mu.Unlock()
+	if s.rateLimit != nil {
+		s.rateLimit.SetUser(id)
+	}
+}
+
+// UserID returns the user bound to the session.
+func (s *Session) UserID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.userID
+}
+
 // Send pushes response message entry to respQueue.
 func (s *Session) Send(ctx Context) (err error) {
-	outboundMsg, err := s.packResponse(ctx)
-	if err != nil {
-		return fmt.Errorf("session %s pack outbound message err: %s", s.id, err)
+	if ctx.Response() == nil {
+		return fmt.Errorf("session %s out message is nil", s.id)
 	}
 
-	if outboundMsg == nil {
-		return fmt.Errorf("session %s out message is nil", s.id)
+	return s.writeMessage(ctx.Response())
+}
+
+// writeMessage packs the message into a pooled buffer and writes it to the connection,
+// or appends it to the pending frames if the session batches writes.
+func (s *Session) writeMessage(msg *message.Message) (err error) {
+	s.measureError(msg)
+	if msg, err = packing.EncodeHeader(msg); err != nil {
+		return fmt.Errorf("session %s message %s", s.id, err)
 	}
+	s.captureFrame(capture.Outbound, msg)
+	if c := s.outboundCompressor(); c != nil {
+		buf := packing.GetBuffer()
+		defer packing.PutBuffer(buf)
+		if msg, err = compress.CompressMessage(*buf, msg, c, s.compression.threshold); err != nil {
+			return fmt.Errorf("session %s message %s", s.id, err)
+		}
+	}
+
+	if s.fragment != nil && len(msg.Data) > s.fragment.ChunkSize {
+		return s.writeFragments(msg)
+	}
+	return s.writeFrame(msg)
+}
+
+// writeFrame writes the frame of msg.
+func (s *Session) writeFrame(msg *message.Message) (err error) {
+	s.measureOut(msg)
+	if s.batch != nil {
+		return s.batchMessage(msg)
+	}
+
+	buf := packing.GetBuffer()
+	defer packing.PutBuffer(buf)
+
+	// the frames are packed in the write order, the packer may number them.
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	outboundMsg, err := packing.AppendPack(s.packer, *buf, msg)
+	if err != nil {
+		return fmt.Errorf("session %s pack outbound message err: %s", s.id, err)
+	}
+	*buf = outboundMsg
 
 	if err = s.attemptConnWrite(outboundMsg, s.writeAttemptTimes); err != nil {
 		return fmt.Errorf("session %s conn write err: %s", s.id, err)
@@ -92,10 +228,109 @@ func (s *Session) Send(ctx Context) (err error) {
 	return
 }
 
+// handshake negotiates the capabilities of the session, exchanges its keys and wraps its packer
+// with the encryption and the integrity trailer of the listener.
+func (s *Session) handshake(l *listener) error {
+	secureConf, integrityConf := l.secure, l.integrity
+	if l.negotiate != nil {
+		r, err := s.negotiate(l)
+		if err != nil {
+			return err
+		}
+		if !r.Encryption {
+			secureConf = nil
+		}
+		if !r.Sequence {
+			integrityConf = nil
+		}
+	}
+	if secureConf == nil && integrityConf == nil {
+		return nil
+	}
+	if secureConf != nil && secureConf.HandshakeTimeout > 0 {
+		if err := s.conn.SetDeadline(time.Now().Add(secureConf.HandshakeTimeout)); err != nil {
+			return err
+		}
+		defer s.conn.SetDeadline(time.Time{})
+	}
+	if secureConf != nil {
+		p, err := secure.ServerHandshake(struct {
+			io.Reader
+			io.Writer
+		}{s.reader, s.conn}, s.packer)
+		if err != nil {
+			return err
+		}
+		s.packer = p
+	}
+	if integrityConf != nil {
+		p, err := integrity.ServerHandshake(s.conn, s.packer, integrityConf)
+		if err != nil {
+			return err
+		}
+		s.packer = p
+	}
+	return nil
+}
+
+// negotiate selects the protocol version and the capabilities of the session among the ones of
+// the listener, the codec and the compressor of the session are the selected ones.
+func (s *Session) negotiate(l *listener) (*negotiate.Result, error) {
+	conf := l.negotiate
+	if conf.Timeout > 0 {
+		if err := s.conn.SetDeadline(time.Now().Add(conf.Timeout)); err != nil {
+			return nil, err
+		}
+		defer s.conn.SetDeadline(time.Time{})
+	}
+
+	server := negotiate.Capabilities{Encryption: l.secure != nil, Sequence: l.integrity != nil}
+	if l.codec != nil {
+		server.Codecs = append(server.Codecs, l.codec.Name())
+	}
+	for _, name := range conf.Codecs {
+		if encoding.GetCodec(name) != nil {
+			server.Codecs = append(server.Codecs, name)
+		}
+	}
+	if s.compression != nil {
+		server.Compressors = s.compression.names
+	}
+
+	r, err := negotiate.ServerHandshake(struct {
+		io.Reader
+		io.Writer
+	}{s.reader, s.conn}, s.packer, conf, server)
+	if err != nil {
+		return nil, err
+	}
+	if l.codec == nil || r.Codec != l.codec.Name() {
+		s.codec = encoding.GetCodec(r.Codec)
+	}
+	if err := s.SetCompressor(r.Compressor); err != nil {
+		return nil, err
+	}
+	s.negotiated = r
+	return r, nil
+}
+
+// Negotiated returns the result of the negotiation handshake of the session, nil if the listener
+// does not negotiate, see Negotiation.
+func (s *Session) Negotiated() *negotiate.Result {
+	return s.negotiated
+}
+
 // Close closes the session, but doesn't close the connection.
 func (s *Session) Close() {
 	s.connected.SetFalse()
 	s.cancelFunc()
+	if s.rateLimit != nil {
+		s.rateLimit.Close()
+	}
+	s.channels.close()
+	if err := s.Flush(); err != nil {
+		s.log.Errorf("session %s flush err: %s", s.id, err)
+	}
 	if err := s.conn.Close(); err != nil {
 		s.log.Errorf("connection close err: %s", err)
 	}
@@ -109,21 +344,79 @@ func (s *Session) readInbound(ctx context.Context) (err error) {
 			s.log.Info("readInbound", ctx.Err())
 			return
 		default:
-			reqMsg, err := s.packer.Unpack(s.conn)
+			reqMsg, err := s.packer.Unpack(s.reader)
 			if err != nil {
-				return fmt.Errorf("session %s unpack inbound packet err: %s", s.id, err)
+				return fmt.Errorf("session %s unpack inbound packet err: %w", s.id, err)
 			}
 
 			if reqMsg == nil {
 				continue
 			}
+			s.measureIn(reqMsg)
+
+			if reqMsg.Flag&packing.FlagFragment != 0 {
+				if reqMsg, err = s.reassemble(reqMsg); err != nil {
+					return err
+				} else if reqMsg == nil {
+					continue
+				}
+			}
+
+			if s.rateLimit != nil {
+				if ok, err := s.allowMessage(reqMsg); err != nil {
+					reqMsg.Release()
+					return err
+				} else if !ok {
+					reqMsg.Release()
+					continue
+				}
+			}
+
+			if err := s.decompressMessage(reqMsg); err != nil {
+				reqMsg.Release()
+				return err
+			}
+			s.captureFrame(capture.Inbound, reqMsg)
+			if err := packing.DecodeHeader(reqMsg); err != nil {
+				reqMsg.Release()
+				return fmt.Errorf("session %s %w", s.id, err)
+			}
+
+			if reqMsg.Flag&packing.FlagChannel != 0 {
+				if err := s.channels.dispatch(reqMsg); err != nil {
+					return fmt.Errorf("session %s %s", s.id, err)
+				}
+				continue
+			}
 
-			go func(ctx context.Context) {
+			var stream *serverStream
+			if reqMsg.Flag&packing.FlagStream != 0 {
+				if stream, err = s.dispatchStream(ctx, reqMsg); err != nil {
+					return err
+				} else if stream == nil {
+					continue
+				}
+			}
+
+			go func(ctx context.Context, stream *serverStream) {
 				routerCtx := s.pool.Get().(*routerCtx)
 				routerCtx.Reset(s, reqMsg)
+				routerCtx.codec = s.codecOf(reqMsg)
+				routerCtx.ctx = transport.NewServerContext(ctx, s.serverTransport(routerCtx))
+				if reqMsg.Header != nil {
+					routerCtx.ctx = metadata.NewServerContext(routerCtx.ctx, metadata.Metadata(reqMsg.Header))
+				}
+				routerCtx.stream = stream
+				done := s.measureHandler(reqMsg.ID)
 				s.callback.OnMessage(routerCtx)
+				done()
+				if stream != nil {
+					s.closeStream(stream)
+				}
 				s.pool.Put(routerCtx)
-			}(ctx)
+				// the request buffer is reused once the handler finishes.
+				reqMsg.Release()
+			}(ctx, stream)
 		}
 	}
 }
@@ -168,12 +461,40 @@ func (s *Session) writeOutbound(ctx context.Context, doneChan chan<- struct{}, w
 	}
 }
 
+// allowMessage applies the rate limits to the inbound message,
+// returns an error if the session has to be closed.
+func (s *Session) allowMessage(msg *message.Message) (bool, error) {
+	allowed, strikes, disconnect := s.rateLimit.Allow(msg.ID)
+	if allowed {
+		return true, nil
+	}
+	if disconnect {
+		return false, fmt.Errorf("session %s message %d rate limited %d times: %w", s.id, msg.ID, strikes, ErrRateLimited)
+	}
+
+	conf := s.rateLimit.Config()
+	if conf.Action == ratelimit.Reply {
+		id := conf.ReplyID
+		if id == 0 {
+			id = msg.ID
+		}
+		if err := s.sendMessage(id, packing.ErrType, errors.New(http.StatusTooManyRequests, "RATE_LIMITED", "too many requests")); err != nil {
+			s.log.Errorf("session %s send rate limit err: %s", s.id, err)
+		}
+	}
+	return false, nil
+}
+
 func (s *Session) attemptConnWrite(outboundMsg []byte, attemptTimes int) (err error) {
 	for i := 0; i < attemptTimes; i++ {
-		_, err = s.conn.Write(outboundMsg)
+		var n int
+		n, err = s.conn.Write(outboundMsg)
 		if err != nil {
+			// the written bytes are not written again.
+			outboundMsg = outboundMsg[n:]
 			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
-				s.log.Info("session %s write attempt %d temporary err: %s", s.id, i+1, err)
+				s.log.Infof("session %s write attempt %d temporary err: %s", s.id, i+1, err)
+				s.measureRetry()
 				time.Sleep(tempErrDelay * time.Duration(i))
 				continue
 			} else {
@@ -195,21 +516,25 @@ func (s *Session) packResponse(ctx Context) ([]byte, error) {
 
 // SendMsg sends message to session.
 func (s *Session) SendMsg(id uint32, data interface{}) (err error) {
-	b, err := s.codec.Marshal(data)
+	return s.sendMessage(id, packing.OKType, data)
+}
+
+// sendMessage marshals data and writes the message to the connection.
+func (s *Session) sendMessage(id uint32, flag uint16, data interface{}) (err error) {
+	codec := s.Codec()
+	if codec == nil {
+		return fmt.Errorf("session %s message codec is nil", s.id)
+	}
+	b, err := codec.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("session %s marshal data err: %s", s.id, err)
 	}
 
 	msg := &message.Message{
 		ID:   id,
-		Flag: packing.OKType,
+		Flag: flag,
 		Data: b,
 	}
 
-	pack, err := s.packer.Pack(msg)
-	if err != nil {
-		return fmt.Errorf("session %s pack message err: %s", s.id, err)
-	}
-
-	return s.attemptConnWrite(pack, s.writeAttemptTimes)
+	return s.writeMessage(msg)
 }
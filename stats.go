@@ -0,0 +1,72 @@
+package ktcp
+
+import (
+	"time"
+
+	"github.com/kwstars/ktcp/sync/atomic"
+)
+
+// SessionStats is the traffic of a session.
+type SessionStats struct {
+	FramesIn  int64
+	FramesOut int64
+	BytesIn   int64 // data bytes of the inbound frames
+	BytesOut  int64 // data bytes of the outbound frames
+	// LastActive is the time of the last inbound or outbound frame, zero if none.
+	LastActive time.Time
+}
+
+// sessionStats is the SessionStats updated by the reads and writes of a session.
+type sessionStats struct {
+	framesIn   atomic.Int64
+	framesOut  atomic.Int64
+	bytesIn    atomic.Int64
+	bytesOut   atomic.Int64
+	lastActive atomic.Int64 // unix nanoseconds
+}
+
+// CreatedAt returns the time the session is accepted.
+func (s *Session) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// Stats returns the traffic of the session.
+func (s *Session) Stats() SessionStats {
+	stats := SessionStats{
+		FramesIn:  s.stats.framesIn.Get(),
+		FramesOut: s.stats.framesOut.Get(),
+		BytesIn:   s.stats.bytesIn.Get(),
+		BytesOut:  s.stats.bytesOut.Get(),
+	}
+	if t := s.stats.lastActive.Get(); t != 0 {
+		stats.LastActive = time.Unix(0, t)
+	}
+	return stats
+}
+
+// Session returns the active session of id.
+func (s *Server) Session(id string) (*Session, bool) {
+	v, ok := s.sessions.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return v.(*Session), true
+}
+
+// RangeSessions calls f for the active sessions until it returns false.
+func (s *Server) RangeSessions(f func(sess *Session) bool) {
+	s.sessions.Range(func(_, v interface{}) bool {
+		return f(v.(*Session))
+	})
+}
+
+// Kick closes the active session of id with CloseKicked, it returns false if there is no such session.
+func (s *Server) Kick(id string) bool {
+	sess, ok := s.Session(id)
+	if !ok {
+		return false
+	}
+	s.setCloseReason(sess, CloseKicked)
+	sess.Close()
+	return true
+}
@@ -0,0 +1,290 @@
+package ktcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/kwstars/ktcp/encoding"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/msgtype"
+	"github.com/kwstars/ktcp/packing"
+)
+
+// The streams are multiplexed on a connection as the frames marked with packing.FlagStream,
+// whose data start with the stream id:
+//
+//	stream id(4)|payload(n)
+//
+// A client opens a stream with the first frame of an unused stream id, the ids of the streams of a
+// connection increase. The frames of the client carry the request id of the stream method, the data
+// frames of the server carry its response id. A side ends its sending with an empty frame marked with
+// packing.FlagEndStream, the end frame of the server is an ErrType frame carrying the error if the
+// method fails. Either side cancels a stream with an empty frame marked with packing.FlagCancelStream.
+
+const (
+	streamIDSize = 4
+	// streamQueueSize is the number of the received frames a stream buffers, a stream is canceled
+	// when it is full rather than blocking the inbound reads of the other streams.
+	streamQueueSize = 64
+)
+
+var (
+	// ErrStreamCanceled is returned when a stream is canceled by the peer.
+	ErrStreamCanceled = errors.New("stream canceled")
+	// ErrStreamOverflow is returned when a stream is canceled as its received frames are not read in time.
+	ErrStreamOverflow = errors.New("stream queue overflow")
+)
+
+// Stream is a server stream of a session.
+type Stream interface {
+	// Context returns the context of the stream, it is done when the stream is canceled or finished.
+	Context() context.Context
+	// ID returns the stream id.
+	ID() uint32
+	// SendMsg sends v as a message of id.
+	SendMsg(id uint32, v interface{}) error
+	// RecvMsg receives a message into v, it returns io.EOF once the client ends its sending.
+	RecvMsg(v interface{}) error
+	// Finish ends the stream with the result err of the stream method and returns err,
+	// the client receives the error if it is not nil.
+	Finish(err error) error
+}
+
+// streamFrame returns a stream frame message whose payload is the marshaled v, nil v means no payload.
+func streamFrame(codec encoding.Codec, streamID, id uint32, flag uint16, v interface{}) (*message.Message, error) {
+	data := make([]byte, streamIDSize, streamIDSize+64)
+	binary.BigEndian.PutUint32(data, streamID)
+	if v != nil {
+		if codec == nil {
+			return nil, fmt.Errorf("message codec is nil")
+		}
+		payload, err := codec.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, payload...)
+	}
+	return &message.Message{ID: id, Flag: flag | packing.FlagStream, Data: data}, nil
+}
+
+// parseStreamID returns the stream id of the stream frame msg and strips it from the data in place.
+func parseStreamID(msg *message.Message) (uint32, error) {
+	if len(msg.Data) < streamIDSize {
+		return 0, fmt.Errorf("the stream frame size %d is less than the stream id", len(msg.Data))
+	}
+	id := binary.BigEndian.Uint32(msg.Data)
+	msg.Data = msg.Data[:copy(msg.Data, msg.Data[streamIDSize:])]
+	return id, nil
+}
+
+// streamError returns the error carried by an ErrType end frame.
+func streamError(codec encoding.Codec, msg *message.Message) error {
+	se := &kerrors.Error{}
+	if codec == nil || codec.Unmarshal(msg.Data, se) != nil {
+		return kerrors.InternalServer("STREAM_ERROR", "invalid stream error")
+	}
+	return se
+}
+
+// serverStream is a Stream opened by a client.
+type serverStream struct {
+	sess     *Session
+	id       uint32
+	reqID    uint32
+	ctx      context.Context
+	cancel   context.CancelFunc
+	codec    encoding.Codec   // the codec of the opening frame
+	first    *message.Message // the opening frame
+	recv     chan *message.Message
+	ended    bool // the end frame is dispatched, used by readInbound only
+	eof      bool // the end frame is received, used by RecvMsg only
+	mu       sync.Mutex
+	finished bool
+}
+
+var _ Stream = (*serverStream)(nil)
+
+func (st *serverStream) Context() context.Context {
+	return st.ctx
+}
+
+func (st *serverStream) ID() uint32 {
+	return st.id
+}
+
+func (st *serverStream) SendMsg(id uint32, v interface{}) error {
+	if err := st.ctx.Err(); err != nil {
+		return err
+	}
+	if err := msgtype.Validate(id, v); err != nil {
+		return err
+	}
+	msg, err := streamFrame(st.codec, st.id, id, packing.OKType, v)
+	if err != nil {
+		return err
+	}
+	return st.sess.writeMessage(msg)
+}
+
+func (st *serverStream) RecvMsg(v interface{}) error {
+	for !st.eof {
+		// the opening frame is released with the routing context.
+		msg, owned := st.first, false
+		if msg != nil {
+			st.first = nil
+		} else {
+			select {
+			case m, ok := <-st.recv:
+				if !ok {
+					st.eof = true
+					return io.EOF
+				}
+				msg, owned = m, true
+			case <-st.ctx.Done():
+				return st.ctx.Err()
+			}
+		}
+
+		if msg.Flag&packing.FlagEndStream != 0 {
+			st.eof = true
+		} else {
+			err := st.unmarshal(msg, v)
+			if owned {
+				msg.Release()
+			}
+			return err
+		}
+		if owned {
+			msg.Release()
+		}
+	}
+	return io.EOF
+}
+
+func (st *serverStream) unmarshal(msg *message.Message, v interface{}) error {
+	if st.codec == nil {
+		return fmt.Errorf("message codec is nil")
+	}
+	return st.codec.Unmarshal(msg.Data, v)
+}
+
+func (st *serverStream) Finish(err error) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.finished {
+		return err
+	}
+	st.finished = true
+	defer st.cancel()
+	if st.ctx.Err() != nil {
+		return err
+	}
+
+	var (
+		msg     *message.Message
+		sendErr error
+	)
+	if err != nil {
+		msg, sendErr = streamFrame(st.codec, st.id, st.reqID, packing.ErrType|packing.FlagEndStream, kerrors.FromError(err))
+	} else {
+		msg, sendErr = streamFrame(nil, st.id, st.reqID, packing.OKType|packing.FlagEndStream, nil)
+	}
+	if sendErr == nil {
+		sendErr = st.sess.writeMessage(msg)
+	}
+	if err == nil {
+		return sendErr
+	}
+	return err
+}
+
+// dispatchStream dispatches the inbound stream frame msg, it returns the stream opened by msg,
+// or nil if msg is dispatched to an opened stream or dropped.
+func (s *Session) dispatchStream(ctx context.Context, msg *message.Message) (*serverStream, error) {
+	id, err := parseStreamID(msg)
+	if err != nil {
+		msg.Release()
+		return nil, fmt.Errorf("session %s message %d %s", s.id, msg.ID, err)
+	}
+
+	s.streamsMu.Lock()
+	st := s.streams[id]
+	s.streamsMu.Unlock()
+
+	if st == nil {
+		// the frames of the finished or canceled streams are dropped.
+		if msg.Flag&packing.FlagCancelStream != 0 || id <= s.lastStreamID {
+			msg.Release()
+			return nil, nil
+		}
+		s.lastStreamID = id
+		st = &serverStream{sess: s, id: id, reqID: msg.ID, codec: s.codecOf(msg), first: msg, recv: make(chan *message.Message, streamQueueSize)}
+		st.ctx, st.cancel = context.WithCancel(ctx)
+		if msg.Flag&packing.FlagEndStream != 0 {
+			st.ended = true
+			close(st.recv)
+		}
+		s.streamsMu.Lock()
+		if s.streams == nil {
+			s.streams = make(map[uint32]*serverStream)
+		}
+		s.streams[id] = st
+		s.streamsMu.Unlock()
+		return st, nil
+	}
+
+	switch {
+	case msg.Flag&packing.FlagCancelStream != 0:
+		st.cancel()
+		msg.Release()
+	case st.ended || st.ctx.Err() != nil:
+		msg.Release()
+	default:
+		end := msg.Flag&packing.FlagEndStream != 0
+		select {
+		case st.recv <- msg:
+		default:
+			// the handler doesn't keep up, the stream is canceled rather than blocking the session.
+			msg.Release()
+			s.log.Errorf("session %s stream %d err: %s", s.id, id, ErrStreamOverflow)
+			s.cancelStream(st)
+			return nil, nil
+		}
+		if end {
+			st.ended = true
+			close(st.recv)
+		}
+	}
+	return nil, nil
+}
+
+// cancelStream cancels st and notifies the client, the frames of st received later are dropped.
+func (s *Session) cancelStream(st *serverStream) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.finished {
+		return
+	}
+	st.finished = true
+	st.cancel()
+	msg, err := streamFrame(nil, st.id, st.reqID, packing.OKType|packing.FlagCancelStream, nil)
+	if err == nil {
+		err = s.writeMessage(msg)
+	}
+	if err != nil {
+		s.log.Errorf("session %s cancel stream %d err: %s", s.id, st.id, err)
+	}
+}
+
+// closeStream finishes st once its handler returns.
+func (s *Session) closeStream(st *serverStream) {
+	_ = st.Finish(nil)
+	s.streamsMu.Lock()
+	delete(s.streams, st.id)
+	s.streamsMu.Unlock()
+}
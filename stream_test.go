@@ -0,0 +1,229 @@
+package ktcp
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/metadata"
+	"github.com/kwstars/ktcp/compress/gzip"
+	"github.com/kwstars/ktcp/fragment"
+	testData "github.com/kwstars/ktcp/internal/testdata/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	feedRequestID   = 10
+	feedResponseID  = 11
+	sumRequestID    = 20
+	cancelRequestID = 30
+)
+
+// streamHandler serves the streams of the tests.
+type streamHandler struct {
+	canceled chan struct{}
+}
+
+func (h *streamHandler) OnConnect(*Session) {}
+
+func (h *streamHandler) OnMessage(c Context) {
+	stream := c.Stream()
+	if stream == nil {
+		return
+	}
+	switch c.GetReqMsg().ID {
+	case feedRequestID:
+		// server streaming: a request and the feed of its name.
+		var in testData.TestModel
+		if err := stream.RecvMsg(&in); err != nil {
+			_ = stream.Finish(err)
+			return
+		}
+		for i := 0; i < 3; i++ {
+			if err := stream.SendMsg(feedResponseID, &testData.TestModel{Id: int64(i), Name: in.Name}); err != nil {
+				return
+			}
+		}
+		_ = stream.Finish(nil)
+	case sumRequestID:
+		// client streaming: the sum of the ids, an error if it is negative.
+		var sum int64
+		for {
+			var in testData.TestModel
+			err := stream.RecvMsg(&in)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				_ = stream.Finish(err)
+				return
+			}
+			sum += in.Id
+		}
+		if sum < 0 {
+			_ = stream.Finish(errors.BadRequest("NEGATIVE_SUM", "negative sum"))
+			return
+		}
+		_ = stream.SendMsg(sumRequestID+1, &testData.TestModel{Id: sum})
+	case cancelRequestID:
+		<-stream.Context().Done()
+		close(h.canceled)
+	}
+}
+
+func (h *streamHandler) OnClose(*Session) {}
+
+func TestStream_ServerStreaming(t *testing.T) {
+	_, conn := serveTest(t, &streamHandler{})
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// two concurrent streams on the same connection.
+	var streams []ClientStream
+	for _, name := range []string{"a", "b"} {
+		stream, err := c.NewStream(ctx, feedRequestID)
+		require.NoError(t, err)
+		require.NoError(t, stream.SendMsg(&testData.TestModel{Name: name}))
+		require.NoError(t, stream.CloseSend())
+		streams = append(streams, stream)
+	}
+	for i, name := range []string{"a", "b"} {
+		for j := 0; j < 3; j++ {
+			var out testData.TestModel
+			require.NoError(t, streams[i].RecvMsg(&out))
+			assert.Equal(t, int64(j), out.Id)
+			assert.Equal(t, name, out.Name)
+		}
+		assert.Equal(t, io.EOF, streams[i].RecvMsg(&testData.TestModel{}))
+	}
+}
+
+func TestStream_ClientStreaming(t *testing.T) {
+	_, conn := serveTest(t, &streamHandler{})
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stream, err := c.NewStream(ctx, sumRequestID)
+	require.NoError(t, err)
+	for i := int64(1); i <= 4; i++ {
+		require.NoError(t, stream.SendMsg(&testData.TestModel{Id: i}))
+	}
+	require.NoError(t, stream.CloseSend())
+	var out testData.TestModel
+	require.NoError(t, stream.RecvMsg(&out))
+	assert.Equal(t, int64(10), out.Id)
+	assert.Equal(t, io.EOF, stream.RecvMsg(&out))
+
+	// the error of the method.
+	stream, err = c.NewStream(ctx, sumRequestID)
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(&testData.TestModel{Id: -1}))
+	require.NoError(t, stream.CloseSend())
+	err = stream.RecvMsg(&out)
+	assert.True(t, errors.IsBadRequest(err))
+	assert.Equal(t, "NEGATIVE_SUM", errors.Reason(err))
+}
+
+func TestStream_ConcurrentOpen(t *testing.T) {
+	_, conn := serveTest(t, &streamHandler{})
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// the opening frame carrying the metadata is written by SendMsg or CloseSend, whichever comes first.
+	stream, err := c.NewStream(metadata.AppendToClientContext(ctx, "x-locale", "fr"), sumRequestID)
+	require.NoError(t, err)
+	sent := make(chan error, 1)
+	go func() { sent <- stream.SendMsg(&testData.TestModel{Id: 5}) }()
+	require.NoError(t, stream.CloseSend())
+	require.NoError(t, <-sent)
+	var out testData.TestModel
+	require.NoError(t, stream.RecvMsg(&out))
+}
+
+// compressingHandler compresses the outbound frames of its sessions.
+type compressingHandler struct {
+	streamHandler
+}
+
+func (h *compressingHandler) OnConnect(sess *Session) {
+	_ = sess.SetCompressor(gzip.Name)
+}
+
+func TestStream_FragmentedCompressed(t *testing.T) {
+	_, conn := serveTest(t, &compressingHandler{}, Compression(64, gzip.Name), Fragmentation(fragment.Config{ChunkSize: 32}))
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var name strings.Builder
+	for i := 0; i < 200; i++ {
+		name.WriteString(strconv.Itoa(i * i))
+	}
+	stream, err := c.NewStream(ctx, feedRequestID)
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(&testData.TestModel{Name: name.String()}))
+	require.NoError(t, stream.CloseSend())
+	for i := 0; i < 3; i++ {
+		var out testData.TestModel
+		require.NoError(t, stream.RecvMsg(&out))
+		assert.Equal(t, name.String(), out.Name)
+	}
+	assert.Equal(t, io.EOF, stream.RecvMsg(&testData.TestModel{}))
+}
+
+func TestStream_Cancel(t *testing.T) {
+	h := &streamHandler{canceled: make(chan struct{})}
+	_, conn := serveTest(t, h)
+	c := NewClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.NewStream(ctx, cancelRequestID)
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(&testData.TestModel{}))
+	cancel()
+
+	select {
+	case <-h.canceled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("stream not canceled")
+	}
+	assert.Equal(t, context.Canceled, stream.RecvMsg(&testData.TestModel{}))
+}
+
+func TestStream_Overflow(t *testing.T) {
+	h := &streamHandler{canceled: make(chan struct{})}
+	_, conn := serveTest(t, h)
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// the handler never reads the stream, it is canceled once its queue is full.
+	stream, err := c.NewStream(ctx, cancelRequestID)
+	require.NoError(t, err)
+	for i := 0; i < streamQueueSize+2; i++ {
+		require.NoError(t, stream.SendMsg(&testData.TestModel{Id: int64(i)}))
+	}
+	select {
+	case <-h.canceled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("stream not canceled")
+	}
+	assert.Equal(t, ErrStreamCanceled, stream.RecvMsg(&testData.TestModel{}))
+
+	// the other streams of the session are served.
+	feed, err := c.NewStream(ctx, feedRequestID)
+	require.NoError(t, err)
+	require.NoError(t, feed.SendMsg(&testData.TestModel{Name: "a"}))
+	require.NoError(t, feed.CloseSend())
+	var out testData.TestModel
+	require.NoError(t, feed.RecvMsg(&out))
+	assert.Equal(t, "a", out.Name)
+}
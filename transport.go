@@ -0,0 +1,127 @@
+package ktcp
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-kratos/kratos/v2/metadata"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/kwstars/ktcp/message"
+	"github.com/kwstars/ktcp/msgtype"
+)
+
+// KindTCP is the kratos transport kind of ktcp.
+const KindTCP transport.Kind = "tcp"
+
+var (
+	_ transport.Transporter = (*Transport)(nil)
+	_ transport.Header      = message.Header(nil)
+)
+
+// Transporter is the kratos transport of a ktcp message, the server one is in the context of the
+// handled messages and the client one in the context of the client middlewares, see ClientMiddleware.
+type Transporter interface {
+	transport.Transporter
+	// MessageID returns the id of the request message.
+	MessageID() uint32
+}
+
+// Transport is a Transporter, its request header is the header of the request message.
+type Transport struct {
+	endpoint  string
+	operation string
+	id        uint32
+	reqHeader message.Header
+	ctx       *routerCtx // handled message on the server side, nil on the client side
+}
+
+// Kind returns KindTCP.
+func (tr *Transport) Kind() transport.Kind {
+	return KindTCP
+}
+
+// Endpoint returns the local address on the server side and the remote address on the client side,
+// e.g. tcp://127.0.0.1:9090.
+func (tr *Transport) Endpoint() string {
+	return tr.endpoint
+}
+
+// Operation returns the /service/method of the registered request message, see msgtype,
+// or the message id of the other messages.
+func (tr *Transport) Operation() string {
+	return tr.operation
+}
+
+// MessageID returns the id of the request message.
+func (tr *Transport) MessageID() uint32 {
+	return tr.id
+}
+
+// RequestHeader returns the header of the request message.
+func (tr *Transport) RequestHeader() transport.Header {
+	if tr.reqHeader == nil {
+		tr.reqHeader = make(message.Header)
+	}
+	return tr.reqHeader
+}
+
+// ReplyHeader returns the header of the response of the handled message, see Context.ReplyHeader.
+// It is empty on the client side.
+func (tr *Transport) ReplyHeader() transport.Header {
+	if tr.ctx == nil {
+		return message.Header{}
+	}
+	return tr.ctx.ReplyHeader()
+}
+
+// operation returns the operation of the request message id.
+func operation(id uint32) string {
+	if t, ok := msgtype.Lookup(id); ok && t.Service != "" {
+		return "/" + t.Service + "/" + t.Method
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// serverTransport returns the transport of the message handled by c.
+func (s *Session) serverTransport(c *routerCtx) *Transport {
+	return &Transport{
+		endpoint:  string(KindTCP) + "://" + s.LocalAddr().String(),
+		operation: operation(c.reqMsg.ID),
+		id:        c.reqMsg.ID,
+		reqHeader: c.reqMsg.Header,
+		ctx:       c,
+	}
+}
+
+// ClientMiddleware with the middlewares of Client.SendContext and Client.NewStream, e.g. tracing.Client.
+// The request header of their Transporter is sent as the message header.
+func ClientMiddleware(ms ...middleware.Middleware) ClientOption {
+	return func(c *Client) {
+		c.ms = ms
+	}
+}
+
+// invoke runs the client middlewares around h with the transport of the request id,
+// h sends req with the request header of the transport.
+func (c *Client) invoke(ctx context.Context, id uint32, req interface{},
+	h func(ctx context.Context, req interface{}, header message.Header) (interface{}, error)) (interface{}, error) {
+	tr := &Transport{
+		endpoint:  string(KindTCP) + "://" + c.conn.RemoteAddr().String(),
+		operation: operation(id),
+		id:        id,
+	}
+	if md, ok := metadata.FromClientContext(ctx); ok {
+		tr.reqHeader = make(message.Header, len(md))
+		for k, v := range md {
+			tr.reqHeader[k] = v
+		}
+	}
+	next := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return h(ctx, req, tr.reqHeader)
+	}
+	if len(c.ms) > 0 {
+		next = middleware.Chain(c.ms...)(next)
+	}
+	return next(transport.NewClientContext(ctx, tr), req)
+}